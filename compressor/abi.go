@@ -0,0 +1,149 @@
+package compressor
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/0xsequence/ethkit/go-ethereum/accounts/abi"
+)
+
+// A parameter of ABI encoded calldata, dynamic parameters hold
+// the value without its size word and padding, static ones the head word.
+type abiParam struct {
+	data    []byte
+	dynamic bool
+
+	// Only known when the calldata is decoded using its ABI
+	typ *abi.Type
+}
+
+// FLAG_READ_DYNAMIC_ABI can only mark the first 8 parameters as dynamic
+const maxDynamicABIParams = 8
+
+// Splits ABI encoded calldata into the parameters that FLAG_READ_DYNAMIC_ABI can
+// rebuild, using the bitmap for bytes and string values. The flag writes the size
+// of each dynamic value in bytes, so dynamic arrays (their size counts elements)
+// and dynamic tuples (they have no size) can't be rebuilt by it, and calldata with
+// them is rejected; WriteCalldataABI writes them using their types instead. Empty
+// arrays are the exception, they are encoded in the same way as empty bytes.
+// Returns false if the calldata has no dynamic parameters, or if the decoder
+// can't rebuild it exactly.
+func parseDynamicABI(data []byte) ([]abiParam, byte, bool) {
+	if len(data) < 4+32 || (len(data)-4)%32 != 0 {
+		return nil, 0, false
+	}
+
+	args := data[4:]
+
+	// A static word may look like a pointer, so every parameter
+	// is tried as the first dynamic one until the layout matches
+	for first := 0; first < maxDynamicABIParams && first*32 < len(args); first++ {
+		params, bitmap, ok := parseDynamicABIFrom(args, first)
+		if ok && bytes.Equal(rebuildDynamicABI(data[:4], params, bitmap), data) {
+			return params, bitmap, true
+		}
+	}
+
+	return nil, 0, false
+}
+
+func parseDynamicABIFrom(args []byte, first int) ([]abiParam, byte, bool) {
+	// The first dynamic value starts right after the heads
+	headsEnd, ok := abiPointer(args[first*32 : first*32+32])
+	if !ok || headsEnd%32 != 0 || headsEnd <= first*32 || headsEnd/32 > 0xff || headsEnd >= len(args) {
+		return nil, 0, false
+	}
+
+	var params []abiParam
+	var bitmap byte
+
+	next := headsEnd
+
+	for i := 0; i*32 < headsEnd; i++ {
+		head := args[i*32 : i*32+32]
+
+		if i >= first && i < maxDynamicABIParams {
+			if pointer, ok := abiPointer(head); ok && pointer == next {
+				if value, end, ok := abiTail(args, pointer); ok {
+					params = append(params, abiParam{data: value, dynamic: true})
+					bitmap |= 1 << i
+					next = end
+					continue
+				}
+			}
+		}
+
+		if i == first {
+			return nil, 0, false
+		}
+
+		params = append(params, abiParam{data: head})
+	}
+
+	// All the data must be rebuilt by the heads and the dynamic values
+	if next != len(args) {
+		return nil, 0, false
+	}
+
+	return params, bitmap, true
+}
+
+// Reads a head word as a pointer, it must be small enough to point to the calldata
+func abiPointer(word []byte) (int, bool) {
+	n := new(big.Int).SetBytes(word)
+	if !n.IsUint64() || n.Uint64() > 0xffffffff {
+		return 0, false
+	}
+
+	return int(n.Uint64()), true
+}
+
+// Reads the dynamic value at pointer, returns the value and the end of its padding.
+// The padding must be zero, as the decoder always pads the values with zeros.
+func abiTail(args []byte, pointer int) ([]byte, int, bool) {
+	if pointer+32 > len(args) {
+		return nil, 0, false
+	}
+
+	size, ok := abiPointer(args[pointer : pointer+32])
+	if !ok {
+		return nil, 0, false
+	}
+
+	start := pointer + 32
+	end := start + size + abiPadding(size)
+	if end > len(args) || !bytesAreZero(args[start+size:end]) {
+		return nil, 0, false
+	}
+
+	return args[start : start+size], end, true
+}
+
+// Builds the calldata in the same way FLAG_READ_DYNAMIC_ABI does
+func rebuildDynamicABI(selector []byte, params []abiParam, bitmap byte) []byte {
+	heads := append([]byte{}, selector...)
+	var tails []byte
+
+	for i, param := range params {
+		if i >= maxDynamicABIParams || bitmap&(1<<i) == 0 {
+			heads = append(heads, param.data...)
+			continue
+		}
+
+		heads = append(heads, abiWord(len(params)*32+len(tails))...)
+		tails = append(tails, abiWord(len(param.data))...)
+		tails = append(tails, param.data...)
+		tails = append(tails, make([]byte, abiPadding(len(param.data)))...)
+	}
+
+	return append(heads, tails...)
+}
+
+func abiWord(n int) []byte {
+	return new(big.Int).SetInt64(int64(n)).FillBytes(make([]byte, 32))
+}
+
+// Number of zeros needed to pad size bytes to a multiple of 32
+func abiPadding(size int) int {
+	return (32 - size%32) % 32
+}
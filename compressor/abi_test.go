@@ -0,0 +1,111 @@
+package compressor
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/0xsequence/ethkit/go-ethereum/accounts/abi"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+)
+
+var testSelector = common.FromHex("0x12345678")
+
+func mustType(t *testing.T, typ string, components []abi.ArgumentMarshaling) abi.Type {
+	res, err := abi.NewType(typ, "", components)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+// Calldata of a method with the given types and values
+func packCalldata(t *testing.T, types []abi.Type, values ...interface{}) []byte {
+	args := make(abi.Arguments, len(types))
+	for i := range types {
+		args[i] = abi.Argument{Type: types[i]}
+	}
+
+	data, err := args.Pack(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(append([]byte{}, testSelector...), data...)
+}
+
+type testTuple struct {
+	Amount *big.Int
+	Data   []byte
+}
+
+func TestParseDynamicABI(t *testing.T) {
+	uint256 := mustType(t, "uint256", nil)
+	tuple := mustType(t, "tuple", []abi.ArgumentMarshaling{{Name: "amount", Type: "uint256"}, {Name: "data", Type: "bytes"}})
+
+	tests := []struct {
+		name string
+		data []byte
+
+		ok     bool
+		bitmap byte
+	}{
+		{
+			name: "bytes",
+			data: packCalldata(t, []abi.Type{uint256, mustType(t, "bytes", nil)}, big.NewInt(1), []byte{1, 2, 3}),
+			ok:   true, bitmap: 0x02,
+		},
+		{
+			name: "bytes and string",
+			data: packCalldata(t, []abi.Type{mustType(t, "string", nil), uint256, mustType(t, "bytes", nil)}, "hello", big.NewInt(1), bytes.Repeat([]byte{0xaa}, 40)),
+			ok:   true, bitmap: 0x05,
+		},
+		{
+			name: "empty array",
+			data: packCalldata(t, []abi.Type{mustType(t, "uint256[]", nil)}, []*big.Int{}),
+			ok:   true, bitmap: 0x01,
+		},
+		{
+			name: "dynamic array",
+			data: packCalldata(t, []abi.Type{uint256, mustType(t, "uint256[]", nil)}, big.NewInt(1), []*big.Int{big.NewInt(5), big.NewInt(6)}),
+		},
+		{
+			name: "array of bytes",
+			data: packCalldata(t, []abi.Type{mustType(t, "bytes[]", nil)}, [][]byte{{1}, {2, 3}}),
+		},
+		{
+			name: "bytes and dynamic array",
+			data: packCalldata(t, []abi.Type{mustType(t, "bytes", nil), mustType(t, "address[]", nil)}, []byte{1}, []common.Address{common.HexToAddress("0x01")}),
+		},
+		{
+			name: "dynamic tuple",
+			data: packCalldata(t, []abi.Type{tuple}, testTuple{Amount: big.NewInt(7), Data: []byte{1, 2}}),
+		},
+		{
+			name: "static only",
+			data: packCalldata(t, []abi.Type{uint256, uint256}, big.NewInt(1), big.NewInt(2)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, bitmap, ok := parseDynamicABI(tt.data)
+			if ok != tt.ok {
+				t.Fatalf("parsed %v, expected %v", ok, tt.ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			if bitmap != tt.bitmap {
+				t.Fatalf("bitmap %08b, expected %08b", bitmap, tt.bitmap)
+			}
+
+			if rebuilt := rebuildDynamicABI(tt.data[:4], params, bitmap); !bytes.Equal(rebuilt, tt.data) {
+				t.Fatalf("rebuilt %x, expected %x", rebuilt, tt.data)
+			}
+		})
+	}
+}
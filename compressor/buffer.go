@@ -1,6 +1,10 @@
 package compressor
 
-import "bytes"
+import (
+	"bytes"
+
+	"github.com/0xsequence/ethkit/go-ethereum/accounts/abi"
+)
 
 type Indexes struct {
 	AddressIndexes map[string]uint
@@ -18,16 +22,62 @@ type Buffer struct {
 	Pending  []byte
 
 	Refs *References
+
+	pastData *pastDataIndex
 }
 
 type References struct {
 	AllowOpcodes       *AllowOpcodes
 	useContractStorage bool
 
+	// Never modified by the buffer, copies of the references share them
 	Indexes *Indexes
 
+	// Version of the indexes when they come from SharedIndexes, 0 otherwise
+	IndexesVersion uint64
+
+	// Used to choose between encodings, if nil only the size is taken into account
+	CostModel CostModel
+
+	// If set, calldata of the methods of this ABI is encoded
+	// using the types of the arguments, see WriteCalldataABI
+	ABI *abi.ABI
+
 	usedFlags        map[string]int
 	usedStorageFlags map[string]int
+
+	// Gas used by the decompressor to execute the flags written so far
+	gas uint64
+
+	// Part of the cost of storage writes that is expected to be recovered
+	// by future reads, it is discounted when comparing encodings
+	saveCredit uint64
+
+	// Storage writes of the payload, in the order they are executed
+	writes []StorageWrite
+
+	// If set, every flag written is recorded, see Buffer.Stats
+	RecordStats bool
+
+	stats          []FlagStat
+	selectorHits   int
+	selectorMisses int
+}
+
+// A value written to storage by FLAG_SAVE_ADDRESS or FLAG_SAVE_BYTES32
+type StorageWrite struct {
+	Flag  uint
+	Value []byte
+}
+
+// Creates a buffer pinned to the current version of the shared indexes
+func NewSharedBuffer(method uint, indexes *SharedIndexes, allowOpcodes *AllowOpcodes, useStorage bool) *Buffer {
+	snapshot, version := indexes.Snapshot()
+
+	buf := NewBuffer(method, snapshot, allowOpcodes, useStorage)
+	buf.Refs.IndexesVersion = version
+
+	return buf
 }
 
 func NewBuffer(method uint, indexes *Indexes, allowOpcodes *AllowOpcodes, useStorage bool) *Buffer {
@@ -70,10 +120,24 @@ func (r *References) Copy() *References {
 	return &References{
 		AllowOpcodes:       r.AllowOpcodes,
 		Indexes:            r.Indexes,
+		IndexesVersion:     r.IndexesVersion,
 		useContractStorage: r.useContractStorage,
+		CostModel:          r.CostModel,
+		ABI:                r.ABI,
 
 		usedFlags:        usedFlags,
 		usedStorageFlags: usedStorageFlags,
+
+		gas:        r.gas,
+		saveCredit: r.saveCredit,
+
+		// Writes are only appended, the copy can share them
+		writes: r.writes[:len(r.writes):len(r.writes)],
+
+		RecordStats:    r.RecordStats,
+		stats:          r.stats[:len(r.stats):len(r.stats)],
+		selectorHits:   r.selectorHits,
+		selectorMisses: r.selectorMisses,
 	}
 }
 
@@ -109,17 +173,66 @@ func (cb *Buffer) commitUint(i uint) {
 	cb.commitByte(byte(i))
 }
 
+// Commits a flag, adding the gas used to execute it
+func (cb *Buffer) commitFlag(flag uint) {
+	cb.Refs.gas += cb.costModel().FlagCost(flag)
+	cb.commitByte(byte(flag))
+}
+
+var defaultCostModel = SizeCostModel()
+
+func (cb *Buffer) costModel() CostModel {
+	if cb.Refs.CostModel == nil {
+		return defaultCostModel
+	}
+
+	return cb.Refs.CostModel
+}
+
+// Gas used by the decompressor to execute the flags written so far, as
+// estimated by the cost model, storage reads and writes are included.
+func (cb *Buffer) Gas() uint64 {
+	return cb.Refs.gas
+}
+
+// Values written to storage by the payload, in the order they are executed.
+// Their indexes are only known once the payload is executed, see Ledger.
+func (cb *Buffer) StorageWrites() []StorageWrite {
+	return cb.Refs.writes
+}
+
 func (cb *Buffer) FindPastData(data []byte) int {
-	for i := 0; i+len(data) < len(cb.Commited); i++ {
-		if bytes.Equal(cb.Commited[i:i+len(data)], data) {
-			return i
+	// Short data can't be looked up on the index
+	if len(data) < gramSize {
+		for i := 0; i+len(data) < len(cb.Commited); i++ {
+			if bytes.Equal(cb.Commited[i:i+len(data)], data) {
+				return i
+			}
 		}
+
+		return -1
 	}
 
-	return -1
+	cb.syncPastDataIndex()
+	return cb.pastData.find(cb.Commited, data)
 }
 
 func (cb *Buffer) end(uncompressed []byte, t EncodeType) {
+	cb.endWith(uncompressed, len(uncompressed), t)
+}
+
+// Ends bytes that are not a flag, like the counts and the data of other flags
+func (cb *Buffer) endData(t EncodeType) {
+	cb.endWith([]byte{}, -1, t)
+}
+
+// Ends a flag that writes produced bytes when decompressed, that can
+// be more than uncompressed for flags that must not be mirrored
+func (cb *Buffer) endWith(uncompressed []byte, produced int, t EncodeType) {
+	if cb.Refs.RecordStats && produced >= 0 && len(cb.Pending) != 0 {
+		cb.Refs.stats = append(cb.Refs.stats, FlagStat{Flag: uint(cb.Pending[0]), In: produced, Out: len(cb.Pending)})
+	}
+
 	// We need 2 bytes to point to a flag, so any uncompressed value
 	// that is 2 bytes or less is not worth saving.
 	if len(uncompressed) > 2 {
@@ -135,8 +248,34 @@ func (cb *Buffer) end(uncompressed []byte, t EncodeType) {
 		}
 	}
 
+	if t == WriteStorage {
+		cb.Refs.writes = append(cb.Refs.writes, StorageWrite{Flag: uint(cb.Pending[0]), Value: uncompressed})
+	}
+
+	// Commited is exported, if it was shortened outside of the
+	// buffer the index is rebuilt on the next lookup
+	if cb.pastData != nil && cb.pastData.size > len(cb.Commited) {
+		cb.pastData = nil
+	}
+
 	cb.Commited = append(cb.Commited, cb.Pending...)
 	cb.Pending = nil
+
+	if cb.pastData != nil {
+		cb.pastData.extend(cb.Commited)
+	}
+}
+
+// Mirror flags execute the flag again, including its storage writes. If the flag
+// written at rindex for uncompressed wrote to storage, it can't be mirrored.
+func (cb *Buffer) forgetStorageWrites(uncompressed []byte, rindex int, t EncodeType) {
+	if t != WriteStorage {
+		return
+	}
+
+	if cb.Refs.usedFlags[string(uncompressed)] == rindex+1 {
+		delete(cb.Refs.usedFlags, string(uncompressed))
+	}
 }
 
 type Snapshot struct {
@@ -162,6 +301,26 @@ func (cb *Buffer) Snapshot() *Snapshot {
 }
 
 func (cb *Buffer) Restore(snap *Snapshot) {
+	// Only the grams that are not shared with the
+	// snapshot need to be removed from the index
+	if cb.pastData != nil {
+		cb.pastData.truncate(cb.Commited, commonPrefix(cb.Commited, snap.Commited))
+	}
+
 	cb.Commited = snap.Commited
+	cb.Pending = nil
 	cb.Refs = snap.Refs
 }
+
+// Snapshots can only be restored once, as the buffer keeps
+// writing on top of them, use a copy to restore them again.
+func (snap *Snapshot) Copy() *Snapshot {
+	com := make([]byte, len(snap.Commited))
+	copy(com, snap.Commited)
+
+	return &Snapshot{
+		Commited:       com,
+		SignatureLevel: snap.SignatureLevel,
+		Refs:           snap.Refs.Copy(),
+	}
+}
@@ -0,0 +1,176 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+	"github.com/0xsequence/ethkit/go-ethereum/crypto"
+)
+
+// Selector tables (COMMON_4BYTES on state_machine.huff) of each decompressor build,
+// the compressor must use the same table as the decompressor it encodes for.
+var BYTES4_TABLES = map[string]string{
+	"v1": BYTES4_TABLE,
+}
+
+const DEFAULT_BYTES4_TABLE = "v1"
+
+// The index of a selector is a single byte, index 0 means that the selector is not on the table
+const BYTES4_TABLE_SIZE = 256
+
+// Returns the embedded selector table of a decompressor version
+func Bytes4TableVersion(version string) ([]byte, error) {
+	table, ok := BYTES4_TABLES[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown bytes4 table version: %s", version)
+	}
+
+	return common.Hex2Bytes(table), nil
+}
+
+// Maps each selector of the table to its index, to be used as Indexes.Bytes4Indexes
+func LoadBytes4Table(table []byte) (map[string]uint, error) {
+	if len(table)%4 != 0 || len(table) > BYTES4_TABLE_SIZE*4 {
+		return nil, fmt.Errorf("invalid bytes4 table length: %d bytes", len(table))
+	}
+
+	indexes := make(map[string]uint)
+	zero := make([]byte, 4)
+
+	for i := uint(0); i < uint(len(table)); i += 4 {
+		selector := table[i : i+4]
+
+		// Empty entries are allowed to repeat, any other selector would lose one of its indexes
+		if _, ok := indexes[string(selector)]; ok && !bytes.Equal(selector, zero) {
+			return nil, fmt.Errorf("duplicated selector 0x%x on bytes4 table at index %d", selector, i/4)
+		}
+
+		indexes[string(selector)] = i / 4
+	}
+
+	return indexes, nil
+}
+
+// Builds the table back from its indexes, empty entries are left as zeros
+func Bytes4TableFromIndexes(indexes map[string]uint) []byte {
+	table := make([]byte, BYTES4_TABLE_SIZE*4)
+
+	for k, v := range indexes {
+		if v < BYTES4_TABLE_SIZE && len(k) == 4 {
+			copy(table[v*4:v*4+4], k)
+		}
+	}
+
+	return table
+}
+
+var huffBytes4Table = regexp.MustCompile(`#define\s+table\s+COMMON_4BYTES\s*\{\s*(?:0x)?([0-9a-fA-F]*)\s*\}`)
+
+// Reads the COMMON_4BYTES table from the source of the decompressor
+func HuffBytes4Table(source []byte) ([]byte, error) {
+	match := huffBytes4Table.FindSubmatch(source)
+	if match == nil {
+		return nil, fmt.Errorf("COMMON_4BYTES table not found")
+	}
+
+	if len(match[1])%8 != 0 {
+		return nil, fmt.Errorf("invalid COMMON_4BYTES table length: %d hex chars", len(match[1]))
+	}
+
+	return common.Hex2Bytes(string(match[1])), nil
+}
+
+// Returns the definition of the table, to be used on state_machine.huff
+func FormatHuffBytes4Table(table []byte) string {
+	return fmt.Sprintf("#define table COMMON_4BYTES {\n  0x%x\n}\n", table)
+}
+
+// Checks that a selector table is the one compiled into the decompressor source,
+// any difference would make the decompressor write the wrong selectors.
+func CheckBytes4Table(source []byte, table []byte) error {
+	huff, err := HuffBytes4Table(source)
+	if err != nil {
+		return err
+	}
+
+	if len(huff) != len(table) {
+		return fmt.Errorf("decompressor table has %d selectors, expected %d", len(huff)/4, len(table)/4)
+	}
+
+	for i := 0; i < len(table); i += 4 {
+		if !bytes.Equal(huff[i:i+4], table[i:i+4]) {
+			return fmt.Errorf("selector %d is 0x%x on the decompressor, expected 0x%x", i/4, huff[i:i+4], table[i:i+4])
+		}
+	}
+
+	return nil
+}
+
+// Parses a selector table, it can be the source of a decompressor (with the COMMON_4BYTES table),
+// a hex encoded table, or a list of selectors. Lists use one selector per line, either as hex (0xa9059cbb)
+// or as a function signature (transfer(address,uint256)), starting on index 1. Any text after # is ignored.
+func ParseBytes4Table(data []byte) ([]byte, error) {
+	if bytes.Contains(data, []byte("COMMON_4BYTES")) {
+		return HuffBytes4Table(data)
+	}
+
+	var table []byte
+	raw := false
+
+	for n, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if raw {
+			return nil, fmt.Errorf("unexpected selector on line %d, after a full table", n+1)
+		}
+
+		if strings.Contains(line, "(") {
+			table = append(table, crypto.Keccak256([]byte(strings.ReplaceAll(line, " ", "")))[:4]...)
+			continue
+		}
+
+		value, err := hex.DecodeString(strings.TrimPrefix(line, "0x"))
+		if err != nil || len(value) == 0 || len(value)%4 != 0 {
+			return nil, fmt.Errorf("invalid selector on line %d: %s", n+1, line)
+		}
+
+		// A single hex value is the whole table, including index 0
+		if len(value) != 4 {
+			if len(table) != 0 {
+				return nil, fmt.Errorf("unexpected table on line %d, after a list of selectors", n+1)
+			}
+
+			table = value
+			raw = true
+			continue
+		}
+
+		table = append(table, value...)
+	}
+
+	if len(table) == 0 {
+		return nil, fmt.Errorf("empty bytes4 table")
+	}
+
+	// Lists start on index 1, index 0 is reserved for selectors out of the table
+	if !raw {
+		table = append(make([]byte, 4), table...)
+	}
+
+	if _, err := LoadBytes4Table(table); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
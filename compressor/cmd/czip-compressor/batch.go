@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+var batchCmd = &cobra.Command{
+	Use:   "batch [file]",
+	Short: "Compress the NDJSON jobs of a file, or stdin, printing one NDJSON result per job in the same order.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := "-"
+		if len(args) != 0 {
+			path = args[0]
+		}
+
+		workers, err := cmd.Flags().GetUint("workers")
+		if err != nil {
+			fail(withCode(errInvalidOptions, err))
+		}
+
+		if workers == 0 {
+			fail(withCode(errInvalidOptions, fmt.Errorf("at least one worker is required")))
+		}
+
+		var r io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				fail(withCode(errIO, err))
+			}
+
+			defer f.Close()
+			r = f
+		}
+
+		// The indexes are loaded once, and shared by all the jobs
+		c, sync, err := useCompressor(cmd)
+		if err != nil {
+			fail(err)
+		}
+
+		b := &batch{cmd: cmd, compressor: c, sync: sync}
+		if err := b.run(r, int(workers)); err != nil {
+			fail(withCode(errIO, err))
+		}
+
+		fmt.Fprintf(os.Stderr, "%d jobs, %d failed\n", b.jobs, b.failed)
+		printStats(b.stats)
+	},
+}
+
+func init() {
+	batchCmd.Flags().Uint("workers", uint(runtime.NumCPU()), "Number of jobs compressed at the same time.")
+}
+
+// A line of the input, the fields are the arguments of the encode commands
+type batchJob struct {
+	// Copied as is to the result, it can be any JSON value
+	ID json.RawMessage `json:"id,omitempty"`
+
+	// any, call, calls or sequence-tx
+	Kind string `json:"kind"`
+
+	// How the decompressor handles the payload, the subcommands of the CLI (decode, call, call-return)
+	Method string `json:"method"`
+
+	To       string      `json:"to"`
+	Data     string      `json:"data"`
+	Calls    []serveCall `json:"calls"`
+	Wallet   string      `json:"wallet"`
+	Execdata string      `json:"execdata"`
+}
+
+// The result of a job, it has either the encoded payload or the error
+type batchResult struct {
+	// Position of the job on the input, starting at 0 and not counting empty lines
+	Index int             `json:"index"`
+	ID    json.RawMessage `json:"id,omitempty"`
+
+	*encodeOutput
+	Error *errorOutput `json:"error,omitempty"`
+}
+
+type batch struct {
+	cmd        *cobra.Command
+	compressor *compressor.Compressor
+	sync       *indexSync
+
+	// Totals of the contract, only read if a job writes to storage
+	totalsOnce sync.Once
+	addresses  uint
+	bytes32    uint
+	totalsErr  error
+
+	jobs   int
+	failed int
+	stats  *compressor.Stats
+}
+
+type batchLine struct {
+	index int
+	line  string
+}
+
+// Jobs are compressed by the workers, and the results are printed as soon as
+// all the jobs before them are done. Only reading the input can fail the batch.
+func (b *batch) run(r io.Reader, workers int) error {
+	lines := make(chan *batchLine, workers)
+	results := make(chan *batchResult, workers)
+
+	// Jobs read but not printed yet, a slow job stops the input from being
+	// read after it, instead of holding all the next results in memory
+	inflight := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for l := range lines {
+				results <- b.encode(l)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.print(results, inflight)
+	}()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+
+	index := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		inflight <- struct{}{}
+		lines <- &batchLine{index: index, line: line}
+		index++
+	}
+
+	close(lines)
+	wg.Wait()
+	close(results)
+	<-done
+
+	return scanner.Err()
+}
+
+// Results arrive in any order, they are held until the previous ones are printed,
+// and each printed result frees a place of inflight for the next job
+func (b *batch) print(results <-chan *batchResult, inflight <-chan struct{}) {
+	pending := make(map[int]*batchResult)
+	next := 0
+
+	for res := range results {
+		pending[res.Index] = res
+
+		for {
+			res, ok := pending[next]
+			if !ok {
+				break
+			}
+
+			delete(pending, next)
+			next++
+
+			b.jobs++
+			if res.Error != nil {
+				b.failed++
+			} else if res.Stats != nil {
+				if b.stats == nil {
+					b.stats = &compressor.Stats{}
+				}
+
+				b.stats.Add(res.Stats)
+			}
+
+			printJSON(res)
+			<-inflight
+		}
+	}
+}
+
+func (b *batch) encode(l *batchLine) *batchResult {
+	res := &batchResult{Index: l.index}
+
+	var job batchJob
+	if err := json.Unmarshal([]byte(l.line), &job); err != nil {
+		res.Error = newErrorOutput(withCode(errInvalidArguments, fmt.Errorf("invalid job: %w", err)))
+		return res
+	}
+
+	res.ID = job.ID
+
+	out, err := b.encodeJob(&job)
+	if err != nil {
+		res.Error = newErrorOutput(err)
+		return res
+	}
+
+	res.encodeOutput = out
+	return res
+}
+
+func (b *batch) encodeJob(job *batchJob) (*encodeOutput, error) {
+	methods, ok := serveMethods["encode-"+job.Kind]
+	if !ok {
+		return nil, withCode(errInvalidArguments, fmt.Errorf("unknown kind %q, use any, call, calls or sequence-tx", job.Kind))
+	}
+
+	method, ok := methods[job.Method]
+	if !ok {
+		return nil, withCode(errInvalidArguments, fmt.Errorf("unknown method %q for %s", job.Method, job.Kind))
+	}
+
+	var res *compressor.EncodeResult
+	var err error
+
+	switch job.Kind {
+	case "any":
+		res, err = b.compressor.EncodeAny(common.FromHex(job.Data))
+	case "call":
+		res, err = b.compressor.EncodeCall(method, common.FromHex(job.To), common.FromHex(job.Data))
+	case "calls":
+		addrs := make([][]byte, len(job.Calls))
+		datas := make([][]byte, len(job.Calls))
+
+		for i, call := range job.Calls {
+			addrs[i] = common.FromHex(call.To)
+			datas[i] = common.FromHex(call.Data)
+		}
+
+		res, err = b.compressor.EncodeCalls(method, addrs, datas)
+	case "sequence-tx":
+		res, err = b.compressor.EncodeSequenceTx(method, common.FromHex(job.Wallet), common.FromHex(job.Execdata))
+	}
+
+	if err != nil {
+		return nil, withCode(errEncode, err)
+	}
+
+	writes := []writeOutput{}
+	if len(res.Writes) != 0 {
+		writes, err = b.predictWrites(res.Writes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &encodeOutput{
+		Payload:        fmt.Sprintf("0x%x", res.Payload),
+		Method:         res.Method,
+		MethodName:     methodNames[res.Method],
+		EncodeType:     res.EncodeType.String(),
+		Size:           res.Size,
+		CompressedSize: res.CompressedSize,
+		Writes:         writes,
+		Stats:          res.Stats,
+	}, nil
+}
+
+// Each job is predicted on its own, as if it was the first payload executed after the load
+func (b *batch) predictWrites(writes []compressor.StorageWrite) ([]writeOutput, error) {
+	b.totalsOnce.Do(func() {
+		b.addresses, b.bytes32, b.totalsErr = useContractTotals(b.cmd, b.sync)
+	})
+
+	if b.totalsErr != nil {
+		return nil, withCode(errIndexes, b.totalsErr)
+	}
+
+	return ledgerWrites(b.addresses, b.bytes32, writes)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/spf13/cobra"
+)
+
+var bytes4Cmd = &cobra.Command{
+	Use:   "bytes4",
+	Short: "Tools for the selector table of the decompressor, selected with --bytes4-table.",
+}
+
+var bytes4CheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check that the selector table matches the one of a decompressor source: <decompressor.huff>",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		table, err := useBytes4Table(cmd)
+		if err != nil {
+			fail(withCode(errInvalidOptions, err))
+		}
+
+		source, err := readHuffSource(args[0])
+		if err != nil {
+			fail(withCode(errIO, err))
+		}
+
+		err = compressor.CheckBytes4Table(source, table)
+		if err != nil {
+			fail(withCode(errTableMismatch, err))
+		}
+
+		if output == outputJSON {
+			printJSON(map[string]int{"selectors": len(table) / 4})
+			return
+		}
+
+		fmt.Printf("ok: %d selectors match\n", len(table)/4)
+	},
+}
+
+var bytes4HuffCmd = &cobra.Command{
+	Use:   "huff",
+	Short: "Print the selector table as a COMMON_4BYTES table, to be used on state_machine.huff.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		table, err := useBytes4Table(cmd)
+		if err != nil {
+			fail(withCode(errInvalidOptions, err))
+		}
+
+		if output == outputJSON {
+			printJSON(map[string]string{"huff": compressor.FormatHuffBytes4Table(table)})
+			return
+		}
+
+		fmt.Print(compressor.FormatHuffBytes4Table(table))
+	},
+}
+
+func init() {
+	bytes4Cmd.AddCommand(bytes4CheckCmd)
+	bytes4Cmd.AddCommand(bytes4HuffCmd)
+}
+
+// The table is either an embedded version, or a path to a file, see compressor.ParseBytes4Table
+func useBytes4Table(cmd *cobra.Command) ([]byte, error) {
+	name, err := cmd.Flags().GetString("bytes4-table")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := compressor.BYTES4_TABLES[name]; ok {
+		return compressor.Bytes4TableVersion(name)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bytes4 table: %s, error: %v", name, err)
+	}
+
+	table, err := compressor.ParseBytes4Table(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bytes4 table: %s, error: %v", name, err)
+	}
+
+	return table, nil
+}
+
+func useBytes4Indexes(cmd *cobra.Command) (map[string]uint, error) {
+	table, err := useBytes4Table(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return compressor.LoadBytes4Table(table)
+}
+
+var huffInclude = regexp.MustCompile(`#include\s+"([^"]+)"`)
+
+// Reads a huff file and the files it includes, the table may be defined on any of them
+func readHuffSource(path string) ([]byte, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, include := range huffInclude.FindAllSubmatch(source, -1) {
+		included, err := readHuffSource(filepath.Join(filepath.Dir(path), string(include[1])))
+		if err != nil {
+			return nil, err
+		}
+
+		source = append(source, included...)
+	}
+
+	return source, nil
+}
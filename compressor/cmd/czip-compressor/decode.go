@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/0xsequence/czip/compressor/decompressor"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+	"github.com/0xsequence/go-sequence"
+	"github.com/spf13/cobra"
+)
+
+var decodeCmd = &cobra.Command{
+	Use:   "decode",
+	Short: "Decompress a compressed payload, without sending it to the decompressor contract: <hex>",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		indexes, meta, err := useDecodeIndexes(context.Background(), cmd)
+		if err != nil {
+			fail(withCode(errIndexes, err))
+		}
+
+		addresses, bytes32 := decodeTotals(meta)
+		res, err := decompressor.Decompress(common.FromHex(args[0]), indexes, addresses, bytes32)
+		if err != nil {
+			fail(withCode(errDecode, err))
+		}
+
+		printDecoded(res)
+	},
+}
+
+func init() {
+	decodeCmd.Flags().Uint64("chain-id", 0, "Chain ID of the cached indexes, used with --use-storage when no provider is given.")
+}
+
+// Storage reads are resolved using the cached indexes, if a provider is given
+// the cache is synced first, otherwise the cache is used as-is. The metadata
+// is nil without --use-storage.
+func useDecodeIndexes(ctx context.Context, cmd *cobra.Command) (*compressor.Indexes, *compressor.IndexMetadata, error) {
+	useStorage, err := cmd.Flags().GetBool("use-storage")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !useStorage {
+		bytes4, err := useBytes4Indexes(cmd)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &compressor.Indexes{Bytes4Indexes: bytes4}, nil, nil
+	}
+
+	providerUrl, err := cmd.Flags().GetString("provider")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if providerUrl != "" {
+		indexes, sync, err := UseIndexes(ctx, cmd)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return indexes, sync.IndexMetadata, nil
+	}
+
+	chainId, err := cmd.Flags().GetUint64("chain-id")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if chainId == 0 {
+		return nil, nil, fmt.Errorf("chain id is required to use the cached indexes without a provider, use --chain-id")
+	}
+
+	contractAddr, err := cmd.Flags().GetString("contract")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contract := common.HexToAddress(contractAddr)
+	if contract == (common.Address{}) {
+		return nil, nil, fmt.Errorf("contract address is required to use the cached indexes, use --contract")
+	}
+
+	indexes, meta, err := findIndexStore(cmd, chainId, contract)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexes.Bytes4Indexes, err = useBytes4Indexes(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return indexes, meta, nil
+}
+
+// Values saved by the payload go after the ones on the block the indexes were loaded,
+// the totals are returned like compressor.GetTotals does. Without storage the contract is empty.
+func decodeTotals(meta *compressor.IndexMetadata) (uint, uint) {
+	if meta == nil {
+		return 1, 1
+	}
+
+	return meta.Addresses + 1, meta.Bytes32 + 1
+}
+
+type callOutput struct {
+	To   string `json:"to"`
+	Data string `json:"data"`
+}
+
+type sequenceTxOutput struct {
+	Wallet   string `json:"wallet"`
+	Execdata string `json:"execdata"`
+}
+
+type decodeOutput struct {
+	Method      uint               `json:"method"`
+	MethodName  string             `json:"methodName"`
+	Data        string             `json:"data,omitempty"`
+	Calls       []callOutput       `json:"calls,omitempty"`
+	SequenceTxs []sequenceTxOutput `json:"sequenceTxs,omitempty"`
+	Writes      []writeOutput      `json:"writes"`
+}
+
+func printDecodedJSON(res *decompressor.Result) {
+	out := &decodeOutput{
+		Method:     res.Method,
+		MethodName: methodNames[res.Method],
+		Writes:     make([]writeOutput, 0, len(res.Writes)),
+	}
+
+	if res.Data != nil {
+		out.Data = fmt.Sprintf("0x%x", res.Data)
+	}
+
+	for _, call := range res.Calls {
+		out.Calls = append(out.Calls, callOutput{To: call.To.Hex(), Data: fmt.Sprintf("0x%x", call.Data)})
+	}
+
+	for _, tx := range res.SequenceTxs {
+		out.SequenceTxs = append(out.SequenceTxs, sequenceTxOutput{Wallet: tx.Wallet.Hex(), Execdata: fmt.Sprintf("0x%x", tx.Execdata)})
+	}
+
+	for _, write := range res.Writes {
+		out.Writes = append(out.Writes, newWriteOutput(write.Flag, write.Value, write.Index))
+	}
+
+	printJSON(out)
+}
+
+func printDecoded(res *decompressor.Result) {
+	if output == outputJSON {
+		printDecodedJSON(res)
+		return
+	}
+
+	switch res.Method {
+	case compressor.METHOD_DECODE_ANY:
+		fmt.Printf("0x%x\n", res.Data)
+
+	case compressor.METHOD_DECODE_CALL,
+		compressor.METHOD_DECODE_N_CALLS,
+		compressor.METHOD_EXECUTE_CALL,
+		compressor.METHOD_EXECUTE_CALL_RETURN,
+		compressor.METHOD_EXECUTE_N_CALLS:
+		for i, call := range res.Calls {
+			fmt.Printf("call %d:\n", i)
+			fmt.Printf("  to: %s\n", call.To.Hex())
+			fmt.Printf("  data: 0x%x\n", call.Data)
+		}
+
+	default:
+		for i, tx := range res.SequenceTxs {
+			fmt.Printf("sequence tx %d:\n", i)
+			fmt.Printf("  wallet: %s\n", tx.Wallet.Hex())
+			printSequenceTransaction(tx.Transaction, "  ")
+		}
+	}
+
+	for _, write := range res.Writes {
+		if write.Flag == compressor.FLAG_SAVE_ADDRESS {
+			fmt.Printf("save address %d: 0x%x\n", write.Index, write.Value[12:])
+		} else {
+			fmt.Printf("save bytes32 %d: 0x%x\n", write.Index, write.Value)
+		}
+	}
+}
+
+func printSequenceTransaction(tx *sequence.Transaction, indent string) {
+	if tx.Nonce != nil {
+		fmt.Printf("%snonce: %s\n", indent, tx.Nonce.String())
+	}
+
+	if tx.Signature != nil {
+		fmt.Printf("%ssignature: 0x%x\n", indent, tx.Signature)
+	}
+
+	for i, t := range tx.Transactions {
+		fmt.Printf("%stransaction %d:\n", indent, i)
+		fmt.Printf("%s  delegate call: %t\n", indent, t.DelegateCall)
+		fmt.Printf("%s  revert on error: %t\n", indent, t.RevertOnError)
+		fmt.Printf("%s  gas limit: %s\n", indent, t.GasLimit.String())
+		fmt.Printf("%s  to: %s\n", indent, t.To.Hex())
+		fmt.Printf("%s  value: %s\n", indent, t.Value.String())
+
+		// Nested Sequence transactions are decoded too
+		if len(t.Transactions) != 0 {
+			printSequenceTransaction(t, indent+"  ")
+		} else {
+			fmt.Printf("%s  data: 0x%x\n", indent, t.Data)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/0xsequence/czip/compressor/decompressor"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+var disasmCmd = &cobra.Command{
+	Use:   "disasm",
+	Short: "Print the flags of a compressed payload, one per line, with their arguments and output: <hex>",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		indexes, meta, err := useDecodeIndexes(context.Background(), cmd)
+		if err != nil {
+			fail(withCode(errIndexes, err))
+		}
+
+		addresses, bytes32 := decodeTotals(meta)
+
+		// The partial listing is printed anyway, as it is most
+		// useful when the payload can't be decompressed
+		instructions, err := decompressor.Disassemble(common.FromHex(args[0]), indexes, addresses, bytes32)
+		err = withCode(errDecode, err)
+
+		if output == outputJSON {
+			out := &disasmOutput{Instructions: newInstructionOutputs(instructions)}
+			if err != nil {
+				out.Error = newErrorOutput(err)
+			}
+
+			printJSON(out)
+			if err != nil {
+				os.Exit(1)
+			}
+
+			return
+		}
+
+		fmt.Print(decompressor.FormatInstructions(instructions))
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
+func init() {
+	disasmCmd.Flags().Uint64("chain-id", 0, "Chain ID of the cached indexes, used with --use-storage when no provider is given.")
+}
+
+type instructionOutput struct {
+	Offset   uint                 `json:"offset"`
+	Name     string               `json:"name"`
+	Args     string               `json:"args,omitempty"`
+	Output   string               `json:"output"`
+	Children []*instructionOutput `json:"children,omitempty"`
+}
+
+// On error the instructions are the ones read before the error
+type disasmOutput struct {
+	Instructions []*instructionOutput `json:"instructions"`
+	Error        *errorOutput         `json:"error,omitempty"`
+}
+
+func newInstructionOutputs(instructions []*decompressor.Instruction) []*instructionOutput {
+	res := make([]*instructionOutput, 0, len(instructions))
+	for _, ins := range instructions {
+		res = append(res, &instructionOutput{
+			Offset:   ins.Offset,
+			Name:     ins.Name,
+			Args:     ins.Args,
+			Output:   fmt.Sprintf("0x%x", ins.Output),
+			Children: newInstructionOutputs(ins.Children),
+		})
+	}
+
+	return res
+}
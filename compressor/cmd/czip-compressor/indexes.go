@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"math/big"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/0xsequence/czip/compressor"
 	"github.com/0xsequence/ethkit/ethrpc"
@@ -12,180 +14,376 @@ import (
 	"github.com/spf13/cobra"
 )
 
-func LoadCachedData(path string) (*compressor.Indexes, error) {
-	// See if the file exists, if it doesn't, return a new Indexes obj.
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return &compressor.Indexes{
-			AddressIndexes: make(map[string]uint),
-			Bytes32Indexes: make(map[string]uint),
-		}, nil
+// Caches are namespaced by chain, contract and code hash, so different
+// decompressor deployments never share their indexes
+func cacheFileName(chainId uint64, contract common.Address, codeHash common.Hash, kind string) string {
+	return fmt.Sprintf("czip-indexes-%d-%s-%x.%s", chainId, strings.ToLower(contract.Hex()), codeHash[:8], kind)
+}
+
+func useCacheStore(cmd *cobra.Command) (string, string, error) {
+	cachePath, err := cmd.Flags().GetString("cache-dir")
+	if err != nil {
+		return "", "", err
 	}
 
-	// Load the file
-	dat, err := os.ReadFile(path)
+	kind, err := cmd.Flags().GetString("cache-store")
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
-	// Parse the JSON
-	tmp := &compressor.Indexes{
-		AddressIndexes: make(map[string]uint),
-		Bytes32Indexes: make(map[string]uint),
+	if kind != "json" && kind != "log" {
+		return "", "", fmt.Errorf("unknown cache store: %s, use json or log", kind)
 	}
 
-	err = json.Unmarshal(dat, tmp)
+	// If path does not exist, create it
+	err = ensureDir(cachePath)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
-	return fromHumanReadable(tmp), nil
+	return cachePath, kind, nil
 }
 
-func SaveCachedData(path string, data *compressor.Indexes) error {
-	// Convert to human readable
-	tmp := toHumanReadable(data)
+func newIndexStore(path string, kind string) compressor.IndexStore {
+	if kind == "log" {
+		return compressor.NewLogIndexStore(path)
+	}
+
+	return compressor.NewFileIndexStore(path)
+}
 
-	// Marshal the JSON
-	dat, err := json.Marshal(tmp)
+func useIndexStore(cmd *cobra.Command, chainId uint64, contract common.Address, codeHash common.Hash) (compressor.IndexStore, error) {
+	cachePath, kind, err := useCacheStore(cmd)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Write the file
-	return os.WriteFile(path, dat, 0644)
+	return newIndexStore(filepath.Join(cachePath, cacheFileName(chainId, contract, codeHash, kind)), kind), nil
 }
 
-func fromHumanReadable(from *compressor.Indexes) *compressor.Indexes {
-	next := &compressor.Indexes{
-		AddressIndexes: make(map[string]uint),
-		Bytes32Indexes: make(map[string]uint),
+// Without a provider the code hash is unknown, so the most recently synced cache of the contract is used
+func findIndexStore(cmd *cobra.Command, chainId uint64, contract common.Address) (*compressor.Indexes, *compressor.IndexMetadata, error) {
+	cachePath, kind, err := useCacheStore(cmd)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	for k, v := range from.AddressIndexes {
-		next.AddressIndexes[string(common.FromHex(k))] = v
+	pattern := fmt.Sprintf("czip-indexes-%d-%s-*.%s", chainId, strings.ToLower(contract.Hex()), kind)
+	paths, err := filepath.Glob(filepath.Join(cachePath, pattern))
+	if err != nil {
+		return nil, nil, err
 	}
 
-	for k, v := range from.Bytes32Indexes {
-		next.Bytes32Indexes[string(common.FromHex(k))] = v
+	var indexes *compressor.Indexes
+	var latest *compressor.IndexMetadata
+
+	for _, path := range paths {
+		cached, meta, err := newIndexStore(path, kind).Load()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := meta.Validate(chainId, contract, meta.CodeHash); err != nil || filepath.Base(path) != cacheFileName(chainId, contract, meta.CodeHash, kind) {
+			continue
+		}
+
+		if indexes == nil || meta.Block > latest.Block {
+			indexes, latest = cached, meta
+		}
 	}
 
-	return next
+	if indexes == nil {
+		return nil, nil, fmt.Errorf("no cached indexes for contract %s on chain %d", contract.Hex(), chainId)
+	}
+
+	return indexes, latest, nil
 }
 
-func toHumanReadable(from *compressor.Indexes) *compressor.Indexes {
-	next := &compressor.Indexes{
-		AddressIndexes: make(map[string]uint),
-		Bytes32Indexes: make(map[string]uint),
+// Caches of older versions were only namespaced by chain, returns the path and the
+// indexes of the one of the chain, or an empty path if there is none
+func loadLegacyCache(cmd *cobra.Command, chainId uint64) (string, *compressor.Indexes, error) {
+	cachePath, _, err := useCacheStore(cmd)
+	if err != nil {
+		return "", nil, err
 	}
 
-	for k, v := range from.AddressIndexes {
-		next.AddressIndexes[common.Bytes2Hex([]byte(k))] = v
+	path := filepath.Join(cachePath, fmt.Sprintf("czip-indexes-%d.json", chainId))
+	if _, err := os.Stat(path); err != nil {
+		return "", &compressor.Indexes{
+			AddressIndexes: make(map[string]uint),
+			Bytes32Indexes: make(map[string]uint),
+		}, nil
 	}
 
-	for k, v := range from.Bytes32Indexes {
-		next.Bytes32Indexes[common.Bytes2Hex([]byte(k))] = v
+	// The file store reads it, it is the same format without the metadata
+	indexes, _, err := compressor.NewFileIndexStore(path).Load()
+	if err != nil {
+		return "", nil, err
 	}
 
-	return next
+	return path, indexes, nil
+}
+
+// How the cached indexes were synced with the contract, it is part of the JSON output
+type indexSync struct {
+	*compressor.IndexMetadata
+
+	// Values read from the contract, and cached values that were reorged out
+	LoadedAddresses int `json:"loadedAddresses"`
+	LoadedBytes32   int `json:"loadedBytes32"`
+	Evicted         int `json:"evicted"`
 }
 
-func UseIndexes(ctx context.Context, cmd *cobra.Command) (*compressor.Indexes, error) {
+// Returns the indexes of the decompressor, and how they were synced if --use-storage is set
+func UseIndexes(ctx context.Context, cmd *cobra.Command) (*compressor.Indexes, *indexSync, error) {
 	var indexes *compressor.Indexes
+	var sync *indexSync
 
 	useStorage, err := cmd.Flags().GetBool("use-storage")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if useStorage {
 		providerUrl, err := cmd.Flags().GetString("provider")
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		provider, err := ethrpc.NewProvider(providerUrl)
-		if err != nil {
-			return nil, err
+		if providerUrl == "" {
+			return nil, nil, fmt.Errorf("provider is required to use the storage indexes, use --provider")
 		}
 
-		chainId, err := provider.ChainID(ctx)
+		provider, err := ethrpc.NewProvider(providerUrl)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		// Load the cache file
-		cachePath, err := cmd.Flags().GetString("cache-dir")
+		indexes, sync, err = loadStorageIndexes(ctx, cmd, provider)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-
-		// If path does not exist, create it
-		err = ensureDir(cachePath)
-		if err != nil {
-			return nil, err
+	} else {
+		indexes = &compressor.Indexes{
+			AddressIndexes: make(map[string]uint),
+			Bytes32Indexes: make(map[string]uint),
 		}
+	}
 
-		path := fmt.Sprintf("%s/czip-indexes-%d.json", cachePath, chainId)
+	indexes.Bytes4Indexes, err = useBytes4Indexes(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		indexes, err = LoadCachedData(path)
-		if err != nil {
-			return nil, err
+	return indexes, sync, nil
+}
+
+// The calls used to sync the indexes of a chain, implemented by *ethrpc.Provider
+type chainReader interface {
+	compressor.StateReader
+	ChainID(ctx context.Context) (*big.Int, error)
+}
+
+// Syncs the cached indexes of the contract with the chain, each call returns new indexes.
+// The metadata has the block and the number of values they were loaded at.
+func loadStorageIndexes(ctx context.Context, cmd *cobra.Command, provider chainReader) (*compressor.Indexes, *indexSync, error) {
+	chainId, err := provider.ChainID(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	confirmations, err := cmd.Flags().GetUint("confirmations")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Everything is loaded on the same block, that must be deep
+	// enough so the indexes are not reorged out
+	block, err := compressor.ConfirmedBlock(ctx, provider, confirmations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contractAddr, err := cmd.Flags().GetString("contract")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contract := common.HexToAddress(contractAddr)
+	if contract == (common.Address{}) {
+		return nil, nil, fmt.Errorf("contract address is required, use --contract")
+	}
+
+	codeHash, err := compressor.ContractCodeHash(ctx, provider, contract, block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Load the cache
+	store, err := useIndexStore(cmd, chainId.Uint64(), contract, codeHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexes, meta, err := store.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Indexes of any other decompressor are discarded, and the cache is rewritten
+	rewrite := false
+	if meta != nil && meta.Validate(chainId.Uint64(), contract, codeHash) != nil {
+		indexes = &compressor.Indexes{
+			AddressIndexes: make(map[string]uint),
+			Bytes32Indexes: make(map[string]uint),
 		}
 
-		contractAddr, err := cmd.Flags().GetString("contract")
+		rewrite = true
+	}
+
+	// Without a cache, the one of older versions is used if it matches the contract
+	legacy := ""
+	if meta == nil && len(indexes.AddressIndexes) == 0 && len(indexes.Bytes32Indexes) == 0 {
+		legacy, indexes, err = loadLegacyCache(cmd, chainId.Uint64())
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		contract := common.HexToAddress(contractAddr)
-		if contract == (common.Address{}) {
-			return nil, fmt.Errorf("contract address is required, use --contract")
-		}
+		rewrite = legacy != ""
+	}
 
-		// Get the highest indexes for addresses and bytes32
-		var maxAddressIndex uint
-		var maxBytes32Index uint
+	opts, err := useLoadOptions(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		for _, v := range indexes.AddressIndexes {
-			if v > maxAddressIndex {
-				maxAddressIndex = v
-			}
-		}
+	// Cached values that were reorged out are evicted, they are loaded again below
+	evicted, err := compressor.VerifyIndexes(ctx, provider, contract, block, opts, indexes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The legacy cache has no contract, if any value doesn't match
+	// it may belong to another one, so all of it is discarded
+	if legacy != "" {
+		if evicted != 0 {
+			fmt.Fprintf(os.Stderr, "warning: %s doesn't match contract %s, loading all the indexes\n", legacy, contract.Hex())
 
-		for _, v := range indexes.Bytes32Indexes {
-			if v > maxBytes32Index {
-				maxBytes32Index = v
+			indexes = &compressor.Indexes{
+				AddressIndexes: make(map[string]uint),
+				Bytes32Indexes: make(map[string]uint),
 			}
-		}
 
-		// Fetch the state
-		_, ra, _, rb, err := compressor.LoadState(ctx, provider, contract, 2048, maxAddressIndex, maxBytes32Index, 0)
-		if err != nil {
-			return nil, err
+			evicted = 0
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: migrating %s to the cache of contract %s, the old file is no longer used\n", legacy, contract.Hex())
 		}
+	}
 
-		// Update the indexes
-		for k, v := range ra {
-			indexes.AddressIndexes[k] = v
-		}
+	rewrite = rewrite || evicted != 0
+
+	// Get the highest indexes for addresses and bytes32
+	var maxAddressIndex uint
+	var maxBytes32Index uint
 
-		for k, v := range rb {
-			indexes.Bytes32Indexes[k] = v
+	for _, v := range indexes.AddressIndexes {
+		if v > maxAddressIndex {
+			maxAddressIndex = v
 		}
+	}
 
-		// Save the cache file
-		err = SaveCachedData(path, indexes)
-		if err != nil {
-			return nil, err
+	for _, v := range indexes.Bytes32Indexes {
+		if v > maxBytes32Index {
+			maxBytes32Index = v
 		}
+	}
+
+	// Fetch the state
+	asize, ra, bsize, rb, err := compressor.LoadStateAt(ctx, provider, contract, block, opts, maxAddressIndex, maxBytes32Index)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Update the indexes
+	for k, v := range ra {
+		indexes.AddressIndexes[k] = v
+	}
+
+	for k, v := range rb {
+		indexes.Bytes32Indexes[k] = v
+	}
+
+	meta = &compressor.IndexMetadata{
+		ChainID:   chainId.Uint64(),
+		Contract:  contract,
+		CodeHash:  codeHash,
+		Block:     block,
+		Addresses: asize - 1,
+		Bytes32:   bsize - 1,
+		Version:   compressor.VERSION,
+	}
+
+	// Only the new values are added to the cache, unless some were evicted
+	if rewrite {
+		err = store.Save(indexes, meta)
 	} else {
-		indexes = &compressor.Indexes{
-			AddressIndexes: make(map[string]uint),
-			Bytes32Indexes: make(map[string]uint),
-		}
+		err = store.Append(&compressor.Indexes{AddressIndexes: ra, Bytes32Indexes: rb}, meta)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return indexes, &indexSync{
+		IndexMetadata:   meta,
+		LoadedAddresses: len(ra),
+		LoadedBytes32:   len(rb),
+		Evicted:         evicted,
+	}, nil
+}
+
+func useLoadOptions(cmd *cobra.Command) (*compressor.LoadOptions, error) {
+	opts := compressor.DefaultLoadOptions()
+
+	var err error
+	opts.BatchSize, err = cmd.Flags().GetUint("load-batch-size")
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Concurrency, err = cmd.Flags().GetUint("load-concurrency")
+	if err != nil {
+		return nil, err
 	}
 
-	indexes.Bytes4Indexes = compressor.LoadBytes4()
+	opts.Retries, err = cmd.Flags().GetUint("load-retries")
+	if err != nil {
+		return nil, err
+	}
+
+	progress, err := cmd.Flags().GetBool("progress")
+	if err != nil {
+		return nil, err
+	}
 
-	return indexes, nil
+	if progress {
+		opts.Progress = printProgress
+	}
+
+	return opts, nil
+}
+
+// Draws a progress bar on stderr, stdout only contains the output
+func printProgress(loaded uint, total uint) {
+	const width = 40
+
+	filled := width
+	if total != 0 {
+		filled = int(uint64(loaded) * width / uint64(total))
+	}
+
+	fmt.Fprintf(os.Stderr, "\rloading indexes [%s%s] %d/%d", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), loaded, total)
+	if loaded == total {
+		fmt.Fprintln(os.Stderr)
+	}
 }
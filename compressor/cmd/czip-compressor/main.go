@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
 
 	"github.com/0xsequence/czip/compressor"
 	"github.com/0xsequence/ethkit/go-ethereum/common"
@@ -20,8 +19,17 @@ var (
 )
 
 func main() {
-	err := rootCmd.Execute()
+	cmd, err := rootCmd.ExecuteC()
 	if err != nil {
+		// Invalid flags and arguments fail before the output format is read
+		if format, _ := cmd.Flags().GetString("output"); format == outputJSON {
+			output = outputJSON
+		}
+
+		if errorCode(err) == errInternal {
+			err = withCode(errInvalidArguments, err)
+		}
+
 		fail(err)
 	}
 }
@@ -31,32 +39,78 @@ func init() {
 	rootCmd.PersistentFlags().StringP("provider", "p", "", "Ethereum RPC provider URL.")
 	rootCmd.PersistentFlags().StringP("contract", "c", "", "Contract address of the decompressor contract.")
 	rootCmd.PersistentFlags().String("cache-dir", "/tmp/czip-cache", "Path to the cache dir for indexes.")
-	rootCmd.MarkFlagsRequiredTogether("provider", "contract")
-
-	rootCmd.PersistentFlags().StringSlice("allow-opcodes", []string{}, "Will only encode using these operations, separated by commas.")
-	rootCmd.PersistentFlags().StringSlice("disallow-opcodes", []string{}, "Will not encode using these operations, separated by commas.")
+	rootCmd.PersistentFlags().String("cache-store", "json", "Format of the indexes cache: json (a single file, rewritten on each update) or log (new indexes are appended).")
+	rootCmd.PersistentFlags().Uint("confirmations", 2, "Only use indexes written at least this many blocks ago, newer ones may be reorged out.")
+	rootCmd.PersistentFlags().Uint("load-batch-size", 2048, "Maximum number of indexes read on a single call, it is reduced if the provider rejects the call.")
+	rootCmd.PersistentFlags().Uint("load-concurrency", 4, "Number of calls used at the same time to read the indexes.")
+	rootCmd.PersistentFlags().Uint("load-retries", 3, "Number of times a failed call to read the indexes is retried.")
+	rootCmd.PersistentFlags().Bool("progress", false, "Show the progress of loading the indexes on stderr.")
+
+	rootCmd.PersistentFlags().StringSlice("allow-opcodes", []string{}, "Will only encode using these operations, separated by commas: exact flag names, globs (FLAG_READ_WORD_*) or groups (words, pow, storage, ...), see list-opcodes.")
+	rootCmd.PersistentFlags().StringSlice("disallow-opcodes", []string{}, "Will not encode using these operations, separated by commas, with the same names as --allow-opcodes.")
 	rootCmd.MarkFlagsMutuallyExclusive("allow-opcodes", "disallow-opcodes")
 
+	rootCmd.PersistentFlags().String("cost-model", "size", "Cost model used to choose between encodings: size, l1, arbitrum or op.")
+	rootCmd.PersistentFlags().String("bytes4-table", compressor.DEFAULT_BYTES4_TABLE, "Selector table of the decompressor: an embedded version (v1), or a path to a file with the table.")
+	rootCmd.PersistentFlags().String("abi", "", "Path to the JSON ABI of the called contracts, calldata of its methods is encoded using the argument types.")
+	rootCmd.PersistentFlags().String("output", outputText, "Output format: text, or json to print the details of the payload and errors with a stable code.")
+	rootCmd.PersistentFlags().Bool("stats", false, "Print the flags used by the payload and the bytes saved by each kind of flag on stderr, as JSON.")
+
+	// Errors are printed by fail, in the output format
+	rootCmd.SilenceErrors = true
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		return useOutput(cmd)
+	}
+
 	rootCmd.AddCommand(encodeAnyCmd)
 	rootCmd.AddCommand(extrasCmd)
+	rootCmd.AddCommand(decodeCmd)
+	rootCmd.AddCommand(disasmCmd)
+	rootCmd.AddCommand(bytes4Cmd)
+	rootCmd.AddCommand(trainCmd)
+	rootCmd.AddCommand(seedCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(batchCmd)
+	rootCmd.AddCommand(listOpcodesCmd)
 
 	addEncodeCallCommands(rootCmd)
 	addEncodeCallsCommands(rootCmd)
 	addEncodeSequenceCommands(rootCmd)
+	addEncodeSequencesCommands(rootCmd)
 }
 
-func fail(err error) {
-	fmt.Print("Error: ")
-	fmt.Println(err)
-	os.Exit(1)
+func useBuffer(method uint, cmd *cobra.Command) (*compressor.Buffer, *indexSync, error) {
+	c, sync, err := useCompressor(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c.NewBuffer(method), sync, nil
 }
 
-func useBuffer(method uint, cmd *cobra.Command) (*compressor.Buffer, error) {
-	indexes, err := UseIndexes(context.Background(), cmd)
+func useCompressor(cmd *cobra.Command) (*compressor.Compressor, *indexSync, error) {
+	indexes, sync, err := UseIndexes(context.Background(), cmd)
 	if err != nil {
-		fail(err)
+		return nil, nil, withCode(errIndexes, err)
 	}
 
+	opts, err := useCompressorOptions(cmd)
+	if err != nil {
+		return nil, nil, withCode(errInvalidOptions, err)
+	}
+
+	opts.Indexes = compressor.StaticIndexes(indexes)
+
+	c, err := compressor.NewCompressor(opts)
+	if err != nil {
+		return nil, nil, withCode(errInvalidOptions, err)
+	}
+
+	return c, sync, nil
+}
+
+// Options of the encoding flags, without the indexes
+func useCompressorOptions(cmd *cobra.Command) (*compressor.CompressorOptions, error) {
 	allowOpcodes, err := cmd.Flags().GetStringSlice("allow-opcodes")
 	if err != nil {
 		return nil, err
@@ -72,9 +126,52 @@ func useBuffer(method uint, cmd *cobra.Command) (*compressor.Buffer, error) {
 		return nil, err
 	}
 
-	allowList := ParseAllowOpcodes(allowOpcodes, disallowOpcodes)
+	costModelName, err := cmd.Flags().GetString("cost-model")
+	if err != nil {
+		return nil, err
+	}
 
-	return compressor.NewBuffer(method, indexes, allowList, useStorage), nil
+	costModel, ok := compressor.CostModelByName(costModelName)
+	if !ok {
+		return nil, fmt.Errorf("unknown cost model %s", costModelName)
+	}
+
+	abiPath, err := cmd.Flags().GetString("abi")
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := cmd.Flags().GetBool("stats")
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := ParseOpcodes(allowOpcodes)
+	if err != nil {
+		return nil, err
+	}
+
+	disallowed, err := ParseOpcodes(disallowOpcodes)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &compressor.CompressorOptions{
+		AllowOpcodes:    allowed,
+		DisallowOpcodes: disallowed,
+		UseStorage:      useStorage,
+		CostModel:       costModel,
+		Stats:           stats,
+	}
+
+	if abiPath != "" {
+		opts.ABI, err = loadABI(abiPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
 }
 
 var encodeAnyCmd = &cobra.Command{
@@ -82,17 +179,17 @@ var encodeAnyCmd = &cobra.Command{
 	Short: "Compress any calldata: <hex>",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		buf, err := useBuffer(compressor.METHOD_DECODE_ANY, cmd)
+		c, sync, err := useCompressor(cmd)
 		if err != nil {
 			fail(err)
 		}
 
-		input := common.FromHex(args[0])
-		if _, err := buf.WriteBytesOptimized(input, true); err != nil {
-			fail(err)
+		res, err := c.EncodeAny(common.FromHex(args[0]))
+		if err != nil {
+			fail(withCode(errEncode, err))
 		}
 
-		fmt.Printf("0x%x\n", buf.Commited)
+		printEncoded(cmd, res, sync)
 	},
 }
 
@@ -141,20 +238,21 @@ func writeCallsForMethod(cmd *cobra.Command, method uint, args []string) {
 		addrs[i/2] = common.FromHex(args[i+1])
 
 		if len(addrs[i/2]) != 20 {
-			fail(fmt.Errorf("invalid address length"))
+			fail(withCode(errInvalidArguments, fmt.Errorf("invalid address length")))
 		}
 	}
 
-	buf, err := useBuffer(method, cmd)
+	c, sync, err := useCompressor(cmd)
 	if err != nil {
 		fail(err)
 	}
 
-	if _, err := buf.WriteCalls(addrs, datas); err != nil {
-		fail(err)
+	res, err := c.EncodeCalls(method, addrs, datas)
+	if err != nil {
+		fail(withCode(errEncode, err))
 	}
 
-	fmt.Printf("0x%x\n", buf.Commited)
+	printEncoded(cmd, res, sync)
 }
 
 func addEncodeCallCommands(cmd *cobra.Command) {
@@ -190,23 +288,24 @@ func addEncodeCallCommands(cmd *cobra.Command) {
 }
 
 func writeCallForMethod(cmd *cobra.Command, method uint, args []string) {
-	buf, err := useBuffer(method, cmd)
-	if err != nil {
-		fail(err)
-	}
-
 	data := common.FromHex(args[0])
 	addr := common.FromHex(args[1])
 
 	if len(addr) != 20 {
-		fail(fmt.Errorf("invalid address length"))
+		fail(withCode(errInvalidArguments, fmt.Errorf("invalid address length")))
 	}
 
-	if _, err := buf.WriteCall(addr, data); err != nil {
+	c, sync, err := useCompressor(cmd)
+	if err != nil {
 		fail(err)
 	}
 
-	fmt.Printf("0x%x\n", buf.Commited)
+	res, err := c.EncodeCall(method, addr, data)
+	if err != nil {
+		fail(withCode(errEncode, err))
+	}
+
+	printEncoded(cmd, res, sync)
 }
 
 func addEncodeSequenceCommands(cmd *cobra.Command) {
@@ -236,33 +335,102 @@ func addEncodeSequenceCommands(cmd *cobra.Command) {
 func writeSequenceForMethod(cmd *cobra.Command, method uint, args []string) {
 	data := common.FromHex(args[0])
 	if len(data) == 0 {
-		fail(fmt.Errorf("invalid data length"))
+		fail(withCode(errInvalidArguments, fmt.Errorf("invalid data length")))
 	}
 
 	addr := common.FromHex(args[1])
 	if len(addr) != 20 {
-		fail(fmt.Errorf("invalid address length"))
+		fail(withCode(errInvalidArguments, fmt.Errorf("invalid address length")))
 	}
 
-	txs, nonce, sig, err := sequence.DecodeExecdata(data)
+	c, sync, err := useCompressor(cmd)
 	if err != nil {
 		fail(err)
 	}
 
-	buf, err := useBuffer(method, cmd)
+	res, err := c.EncodeSequenceTx(method, addr, data)
 	if err != nil {
-		fail(err)
+		fail(withCode(errEncode, err))
 	}
 
-	_, err = buf.WriteSequenceExecute(addr, &sequence.Transaction{
-		Nonce:        nonce,
-		Transactions: txs,
-		Signature:    sig,
+	printEncoded(cmd, res, sync)
+}
+
+func addEncodeSequencesCommands(cmd *cobra.Command) {
+	encodeSequencesCmd := &cobra.Command{
+		Use:   "encode-sequence-txs",
+		Short: "Compress many Sequence Wallet transactions: <data> <wallet> <data> <wallet> ... <data> <wallet>",
+	}
+	encodeSequencesCmd.AddCommand(&cobra.Command{
+		Use:   "decode",
+		Short: "The decompressor contract will only return the decompressed Sequence transactions.",
+		Args:  validateSequencesArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			writeSequencesForMethod(cmd, compressor.METHOD_DECODE_SEQUENCE_N_TXS, args)
+		},
+	})
+	encodeSequencesCmd.AddCommand(&cobra.Command{
+		Use:   "call",
+		Short: "The decompressor contract will execute the decompressed Sequence transactions.",
+		Args:  validateSequencesArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			writeSequencesForMethod(cmd, compressor.METHOD_EXECUTE_SEQUENCE_N_TXS, args)
+		},
 	})
+	cmd.AddCommand(encodeSequencesCmd)
+}
 
+func validateSequencesArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: <decode/call> <data> <wallet>")
+	}
+
+	if len(args)%2 != 0 {
+		return fmt.Errorf("invalid number of arguments, must be even")
+	}
+
+	return nil
+}
+
+func writeSequencesForMethod(cmd *cobra.Command, method uint, args []string) {
+	wallets := make([][]byte, len(args)/2)
+	transactions := make([]*sequence.Transaction, len(args)/2)
+	size := 0
+
+	for i := 0; i < len(args); i += 2 {
+		data := common.FromHex(args[i])
+		if len(data) == 0 {
+			fail(withCode(errInvalidArguments, fmt.Errorf("invalid data length")))
+		}
+
+		size += len(data)
+
+		wallets[i/2] = common.FromHex(args[i+1])
+		if len(wallets[i/2]) != 20 {
+			fail(withCode(errInvalidArguments, fmt.Errorf("invalid address length")))
+		}
+
+		txs, nonce, sig, err := sequence.DecodeExecdata(data)
+		if err != nil {
+			fail(withCode(errInvalidArguments, err))
+		}
+
+		transactions[i/2] = &sequence.Transaction{
+			Nonce:        nonce,
+			Transactions: txs,
+			Signature:    sig,
+		}
+	}
+
+	buf, sync, err := useBuffer(method, cmd)
 	if err != nil {
 		fail(err)
 	}
 
-	fmt.Printf("0x%x\n", buf.Commited)
+	t, err := buf.WriteSequenceExecutes(wallets, transactions)
+	if err != nil {
+		fail(withCode(errEncode, err))
+	}
+
+	printEncoded(cmd, buf.Result(t, size), sync)
 }
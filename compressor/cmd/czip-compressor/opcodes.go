@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/spf13/cobra"
+)
+
+var listOpcodesCmd = &cobra.Command{
+	Use:   "list-opcodes",
+	Short: "List the flags of the decompressor with their value and group, and if --allow-opcodes or --disallow-opcodes allow them.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		onlyAllowed, err := cmd.Flags().GetBool("allowed")
+		if err != nil {
+			fail(withCode(errInvalidOptions, err))
+		}
+
+		opts, err := useCompressorOptions(cmd)
+		if err != nil {
+			fail(withCode(errInvalidOptions, err))
+		}
+
+		c, err := compressor.NewCompressor(opts)
+		if err != nil {
+			fail(withCode(errInvalidOptions, err))
+		}
+
+		// The same check the encoder does, so it reflects how the flags were parsed
+		buf := c.NewBuffer(compressor.METHOD_DECODE_ANY)
+
+		res := &listOpcodesOutput{Opcodes: []*opcodeOutput{}}
+		for name, flag := range compressor.FlagNames() {
+			allowed := buf.Allows(flag)
+			if onlyAllowed && !allowed {
+				continue
+			}
+
+			res.Opcodes = append(res.Opcodes, &opcodeOutput{Value: flag, Name: name, Group: opcodeGroup(flag), Allowed: allowed})
+		}
+
+		sort.Slice(res.Opcodes, func(i, j int) bool {
+			return res.Opcodes[i].Value < res.Opcodes[j].Value
+		})
+
+		if output == outputJSON {
+			printJSON(res)
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VALUE\tNAME\tGROUP\tALLOWED")
+
+		for _, op := range res.Opcodes {
+			fmt.Fprintf(w, "0x%02x\t%s\t%s\t%v\n", op.Value, op.Name, op.Group, op.Allowed)
+		}
+
+		w.Flush()
+	},
+}
+
+func init() {
+	listOpcodesCmd.Flags().Bool("allowed", false, "Only list the flags that can be used.")
+}
+
+type opcodeOutput struct {
+	Value   uint   `json:"value"`
+	Name    string `json:"name"`
+	Group   string `json:"group"`
+	Allowed bool   `json:"allowed"`
+}
+
+type listOpcodesOutput struct {
+	Opcodes []*opcodeOutput `json:"opcodes"`
+}
+
+// Groups that can be used on --allow-opcodes and --disallow-opcodes, every flag is on one of them
+var opcodeGroups = []string{"words", "literals", "pow", "storage", "mirror", "copy", "abi", "nested", "sequence"}
+
+// Groups follow the categories of the stats, literals are split
+// between the words read as they are and the literal values
+func opcodeGroup(flag uint) string {
+	switch category := compressor.FlagCategory(flag); category {
+	case compressor.CategoryLiterals:
+		if flag == compressor.LITERAL_ZERO {
+			return "literals"
+		}
+
+		return "words"
+	case compressor.CategoryPow2, compressor.CategoryPow10:
+		return "pow"
+	case compressor.CategoryStorageReads, compressor.CategoryStorageWrites:
+		return "storage"
+	default:
+		return category
+	}
+}
+
+// Resolves the names of the --allow-opcodes and --disallow-opcodes flags, each one is
+// either the exact name of a flag, a glob like FLAG_READ_WORD_* or a group like words
+func ParseOpcodes(names []string) ([]uint, error) {
+	flags := compressor.FlagNames()
+
+	seen := make(map[uint]bool)
+	var res []uint
+
+	for _, name := range names {
+		ops, err := findOpcodes(flags, strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, op := range ops {
+			if !seen[op] {
+				seen[op] = true
+				res = append(res, op)
+			}
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
+
+	return res, nil
+}
+
+// Groups are lowercase, names and globs are not case sensitive
+// and a glob or a deprecated partial name must match at least one flag
+func findOpcodes(flags map[string]uint, name string) ([]uint, error) {
+	if name == "" {
+		return nil, fmt.Errorf("empty opcode name")
+	}
+
+	var res []uint
+
+	for _, group := range opcodeGroups {
+		if group != name {
+			continue
+		}
+
+		for _, op := range flags {
+			if opcodeGroup(op) == group {
+				res = append(res, op)
+			}
+		}
+
+		return res, nil
+	}
+
+	pattern := strings.ToUpper(name)
+	if strings.ContainsAny(pattern, "*?[") {
+		for n, op := range flags {
+			ok, err := path.Match(pattern, n)
+			if err != nil {
+				return nil, fmt.Errorf("invalid opcode glob %s: %w", name, err)
+			}
+
+			if ok {
+				res = append(res, op)
+			}
+		}
+
+		if len(res) == 0 {
+			return nil, fmt.Errorf("opcode glob %s matches no flags, see list-opcodes", name)
+		}
+
+		return res, nil
+	}
+
+	if op, ok := flags[pattern]; ok {
+		return []uint{op}, nil
+	}
+
+	// Older versions matched any flag that contains the name, it is kept for the
+	// names that are not a flag, so FLAG_READ_WORD still selects FLAG_READ_WORD_*
+	var matched []string
+	for n, op := range flags {
+		if strings.Contains(n, pattern) {
+			matched = append(matched, n)
+			res = append(res, op)
+		}
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("unknown opcode %s, see list-opcodes for the names and groups", name)
+	}
+
+	sort.Strings(matched)
+	fmt.Fprintf(os.Stderr, "warning: opcode %s is not a flag, matching the flags that contain it is deprecated, use the glob *%s* or a group instead (matched %s)\n", name, pattern, strings.Join(matched, ", "))
+
+	return res, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/0xsequence/czip/compressor/decompressor"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+)
+
+func TestParseOpcodes(t *testing.T) {
+	tests := []struct {
+		names    []string
+		expected []uint
+		err      bool
+	}{
+		{names: []string{"FLAG_POW_10"}, expected: []uint{compressor.FLAG_POW_10}},
+		{names: []string{"flag_pow_2", "LITERAL_ZERO"}, expected: []uint{compressor.FLAG_POW_2, compressor.LITERAL_ZERO}},
+		{names: []string{"FLAG_POW_10*"}, expected: []uint{compressor.FLAG_POW_10, compressor.FLAG_POW_10_MANTISSA_S, compressor.FLAG_POW_10_MANTISSA_L}},
+		{names: []string{"pow"}, expected: []uint{compressor.FLAG_POW_2, compressor.FLAG_POW_2_MINUS_1, compressor.FLAG_POW_10, compressor.FLAG_POW_10_MANTISSA_S, compressor.FLAG_POW_10_MANTISSA_L}},
+		{names: []string{"pow", "FLAG_POW_2"}, expected: []uint{compressor.FLAG_POW_2, compressor.FLAG_POW_2_MINUS_1, compressor.FLAG_POW_10, compressor.FLAG_POW_10_MANTISSA_S, compressor.FLAG_POW_10_MANTISSA_L}},
+
+		// Names that are not a flag still match the flags that contain them, as on older versions
+		{names: []string{"FLAG_SEQUENCE_ADDRESS"}, expected: []uint{compressor.FLAG_SEQUENCE_ADDRESS_W0, compressor.FLAG_SEQUENCE_ADDRESS_W0 + 1, compressor.FLAG_SEQUENCE_ADDRESS_W0 + 2, compressor.FLAG_SEQUENCE_ADDRESS_W0 + 3, compressor.FLAG_SEQUENCE_ADDRESS_W0 + 4}},
+		{names: []string{"mantissa"}, expected: []uint{compressor.FLAG_POW_10_MANTISSA_S, compressor.FLAG_POW_10_MANTISSA_L}},
+
+		{names: []string{"FLAG_NOT_A_FLAG"}, err: true},
+		{names: []string{"FLAG_NOT_*"}, err: true},
+		{names: []string{"FLAG_[POW"}, err: true},
+		{names: []string{""}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.names), func(t *testing.T) {
+			res, err := ParseOpcodes(tt.names)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("parsed as %v, expected an error", res)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.Slice(tt.expected, func(i, j int) bool {
+				return tt.expected[i] < tt.expected[j]
+			})
+
+			if fmt.Sprint(res) != fmt.Sprint(tt.expected) {
+				t.Fatalf("parsed as %v, expected %v", res, tt.expected)
+			}
+		})
+	}
+}
+
+// Encodes the data with the parsed --allow-opcodes or --disallow-opcodes, and checks it decodes back
+func encodeWithOpcodes(t *testing.T, allow []string, disallow []string, data []byte) (*compressor.EncodeResult, error) {
+	t.Helper()
+
+	opts := &compressor.CompressorOptions{Stats: true}
+
+	var err error
+	if opts.AllowOpcodes, err = ParseOpcodes(allow); err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.DisallowOpcodes, err = ParseOpcodes(disallow); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := compressor.NewCompressor(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := c.EncodeAny(data)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := decompressor.Decompress(res.Payload, nil, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decoded.Data, data) {
+		t.Fatalf("decoded %x, expected %x", decoded.Data, data)
+	}
+
+	return res, nil
+}
+
+func usesFlag(res *compressor.EncodeResult, flag uint) bool {
+	for _, f := range res.Stats.Flags {
+		if f.Flag == flag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Each size of FLAG_READ_WORD_* and each number of ABI params is allowed on its own
+func TestEncodeAllowsExactOpcodes(t *testing.T) {
+	word := common.LeftPadBytes(common.FromHex("0x123456789a"), 32)
+	calldata := append(common.FromHex("0xa9059cbb"), append(append([]byte{}, word...), word...)...)
+
+	tests := []struct {
+		name     string
+		allow    []string
+		disallow []string
+		data     []byte
+
+		used    uint
+		notUsed uint
+	}{
+		{name: "word size disallowed", disallow: []string{"FLAG_READ_WORD_5"}, data: word, notUsed: compressor.FLAG_READ_WORD_5},
+		{name: "only the word size allowed", allow: []string{"FLAG_READ_WORD_5"}, data: word, used: compressor.FLAG_READ_WORD_5},
+		{name: "smaller word size disallowed", disallow: []string{"FLAG_READ_WORD_1"}, data: word, used: compressor.FLAG_READ_WORD_5},
+		{name: "bigger word size allowed", allow: []string{"FLAG_READ_WORD_8"}, data: word, used: compressor.FLAG_READ_WORD_8},
+		{name: "abi params disallowed", disallow: []string{"FLAG_ABI_2_PARAMS"}, data: calldata, notUsed: compressor.FLAG_ABI_2_PARAMS},
+		{name: "only the abi params allowed", allow: []string{"FLAG_ABI_2_PARAMS", "FLAG_READ_WORD_5"}, data: calldata, used: compressor.FLAG_ABI_2_PARAMS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := encodeWithOpcodes(t, tt.allow, tt.disallow, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.used != 0 && !usesFlag(res, tt.used) {
+				t.Fatalf("payload %x doesn't use flag 0x%02x", res.Payload, tt.used)
+			}
+
+			if tt.notUsed != 0 && usesFlag(res, tt.notUsed) {
+				t.Fatalf("payload %x uses the disallowed flag 0x%02x", res.Payload, tt.notUsed)
+			}
+		})
+	}
+}
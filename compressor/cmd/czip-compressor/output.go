@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/spf13/cobra"
+)
+
+// Output formats, selected with --output
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+var output = outputText
+
+func useOutput(cmd *cobra.Command) error {
+	format, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	if format != outputText && format != outputJSON {
+		return withCode(errInvalidOptions, fmt.Errorf("unknown output format: %s, use text or json", format))
+	}
+
+	output = format
+	return nil
+}
+
+// Codes of the errors printed with --output json, they never change
+const (
+	errInvalidArguments = "invalid_arguments"
+	errInvalidOptions   = "invalid_options"
+	errIndexes          = "indexes_unavailable"
+	errEncode           = "encode_failed"
+	errDecode           = "decode_failed"
+	errTableMismatch    = "table_mismatch"
+	errIO               = "io_failed"
+	errInternal         = "internal"
+)
+
+type codeError struct {
+	code string
+	err  error
+}
+
+func (e *codeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codeError) Unwrap() error {
+	return e.err
+}
+
+func withCode(code string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &codeError{code: code, err: err}
+}
+
+// Errors without a code are reported as internal
+func errorCode(err error) string {
+	var ce *codeError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+
+	return errInternal
+}
+
+type errorOutput struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newErrorOutput(err error) *errorOutput {
+	return &errorOutput{Code: errorCode(err), Message: err.Error()}
+}
+
+func printJSON(v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		fail(err)
+	}
+
+	fmt.Println(string(out))
+}
+
+var methodNames = map[uint]string{
+	compressor.METHOD_EXECUTE_SEQUENCE_TX:    "EXECUTE_SEQUENCE_TX",
+	compressor.METHOD_EXECUTE_SEQUENCE_N_TXS: "EXECUTE_SEQUENCE_N_TXS",
+	compressor.METHOD_READ_ADDRESS:           "READ_ADDRESS",
+	compressor.METHOD_READ_BYTES32:           "READ_BYTES32",
+	compressor.METHOD_READ_SIZES:             "READ_SIZES",
+	compressor.METHOD_READ_STORAGE_SLOTS:     "READ_STORAGE_SLOTS",
+	compressor.METHOD_DECODE_SEQUENCE_TX:     "DECODE_SEQUENCE_TX",
+	compressor.METHOD_DECODE_SEQUENCE_N_TXS:  "DECODE_SEQUENCE_N_TXS",
+	compressor.METHOD_EXECUTE_CALL:           "EXECUTE_CALL",
+	compressor.METHOD_EXECUTE_CALL_RETURN:    "EXECUTE_CALL_RETURN",
+	compressor.METHOD_EXECUTE_N_CALLS:        "EXECUTE_N_CALLS",
+	compressor.METHOD_DECODE_CALL:            "DECODE_CALL",
+	compressor.METHOD_DECODE_N_CALLS:         "DECODE_N_CALLS",
+	compressor.METHOD_DECODE_ANY:             "DECODE_ANY",
+}
+
+type writeOutput struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+	Index uint   `json:"index"`
+}
+
+// Values are 32 bytes, addresses are printed with 20 bytes
+func newWriteOutput(flag uint, value []byte, index uint) writeOutput {
+	if flag == compressor.FLAG_SAVE_ADDRESS {
+		return writeOutput{Kind: "address", Value: fmt.Sprintf("0x%x", value[len(value)-20:]), Index: index}
+	}
+
+	return writeOutput{Kind: "bytes32", Value: fmt.Sprintf("0x%x", value), Index: index}
+}
+
+type encodeOutput struct {
+	Payload        string            `json:"payload"`
+	Method         uint              `json:"method"`
+	MethodName     string            `json:"methodName"`
+	EncodeType     string            `json:"encodeType"`
+	Size           int               `json:"size"`
+	CompressedSize int               `json:"compressedSize"`
+	Writes         []writeOutput     `json:"writes"`
+	Indexes        *indexSync        `json:"indexes,omitempty"`
+	Stats          *compressor.Stats `json:"stats,omitempty"`
+}
+
+// Prints the payload, or all the details of the encoding with --output json
+func printEncoded(cmd *cobra.Command, res *compressor.EncodeResult, sync *indexSync) {
+	if output != outputJSON {
+		fmt.Printf("0x%x\n", res.Payload)
+		printStats(res.Stats)
+		return
+	}
+
+	writes, err := predictWrites(cmd, sync, res.Writes)
+	if err != nil {
+		fail(err)
+	}
+
+	printJSON(&encodeOutput{
+		Payload:        fmt.Sprintf("0x%x", res.Payload),
+		Method:         res.Method,
+		MethodName:     methodNames[res.Method],
+		EncodeType:     res.EncodeType.String(),
+		Size:           res.Size,
+		CompressedSize: res.CompressedSize,
+		Writes:         writes,
+		Indexes:        sync,
+		Stats:          res.Stats,
+	})
+}
+
+// The values are written after the ones on the contract at the block the indexes were
+// loaded at, assuming no other payload writes to storage before this one is executed
+func predictWrites(cmd *cobra.Command, sync *indexSync, writes []compressor.StorageWrite) ([]writeOutput, error) {
+	res := make([]writeOutput, 0, len(writes))
+	if len(writes) == 0 {
+		return res, nil
+	}
+
+	addresses, bytes32, err := useContractTotals(cmd, sync)
+	if err != nil {
+		return nil, withCode(errIndexes, err)
+	}
+
+	return ledgerWrites(addresses, bytes32, writes)
+}
+
+// Indexes of the writes when the contract has these many addresses and bytes32 saved
+func ledgerWrites(addresses uint, bytes32 uint, writes []compressor.StorageWrite) ([]writeOutput, error) {
+	res := make([]writeOutput, 0, len(writes))
+
+	pending, err := compressor.NewLedger(nil, addresses, bytes32).Record("payload", writes)
+	if err != nil {
+		return nil, withCode(errInternal, err)
+	}
+
+	for _, w := range pending.Writes {
+		res = append(res, newWriteOutput(w.Flag, w.Value, w.Index))
+	}
+
+	return res, nil
+}
+
+// With --output json errors are printed on stdout as {"error": {"code", "message"}}
+func fail(err error) {
+	if output == outputJSON {
+		out, _ := json.Marshal(map[string]*errorOutput{"error": newErrorOutput(err)})
+		fmt.Println(string(out))
+		os.Exit(1)
+	}
+
+	fmt.Print("Error: ")
+	fmt.Println(err)
+	os.Exit(1)
+}
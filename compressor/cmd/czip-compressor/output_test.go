@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+)
+
+func TestLedgerWrites(t *testing.T) {
+	address := common.HexToAddress("0x8ba1f109551bd432803012645ac136ddd64dba72")
+	word := common.HexToHash("0x01")
+
+	writes := []compressor.StorageWrite{
+		{Flag: compressor.FLAG_SAVE_ADDRESS, Value: common.LeftPadBytes(address.Bytes(), 32)},
+		{Flag: compressor.FLAG_SAVE_BYTES32, Value: word.Bytes()},
+		{Flag: compressor.FLAG_SAVE_ADDRESS, Value: address.Bytes()},
+	}
+
+	tests := []struct {
+		addresses uint
+		bytes32   uint
+		expected  []uint
+	}{
+		{addresses: 0, bytes32: 0, expected: []uint{1, 1, 2}},
+		{addresses: 41, bytes32: 7, expected: []uint{42, 8, 43}},
+	}
+
+	for _, tt := range tests {
+		res, err := ledgerWrites(tt.addresses, tt.bytes32, writes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []writeOutput{
+			{Kind: "address", Value: "0x8ba1f109551bd432803012645ac136ddd64dba72", Index: tt.expected[0]},
+			{Kind: "bytes32", Value: fmt.Sprintf("0x%x", word.Bytes()), Index: tt.expected[1]},
+			{Kind: "address", Value: "0x8ba1f109551bd432803012645ac136ddd64dba72", Index: tt.expected[2]},
+		}
+
+		if fmt.Sprint(res) != fmt.Sprint(expected) {
+			t.Fatalf("writes %v, expected %v", res, expected)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/0xsequence/ethkit/ethrpc"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+var seedCmd = &cobra.Command{
+	Use:   "seed",
+	Short: "Build the payloads that save addresses and bytes32 on storage, before they are used: <values...>",
+	Run: func(cmd *cobra.Command, args []string) {
+		values, err := readSeedValues(cmd, args)
+		if err != nil {
+			fail(withCode(errInvalidArguments, err))
+		}
+
+		if len(values) == 0 {
+			fail(withCode(errInvalidArguments, fmt.Errorf("no values to seed, pass them as arguments or use --file")))
+		}
+
+		opts, err := useSeedOptions(cmd)
+		if err != nil {
+			fail(withCode(errInvalidOptions, err))
+		}
+
+		// Values already on the contract are dropped, and the
+		// new ones are appended after the ones on the contract
+		indexes, sync, err := UseIndexes(context.Background(), cmd)
+		if err != nil {
+			fail(withCode(errIndexes, err))
+		}
+
+		if useStorage, _ := cmd.Flags().GetBool("use-storage"); useStorage {
+			opts.Addresses, opts.Bytes32, err = useContractTotals(cmd, sync)
+			if err != nil {
+				fail(withCode(errIndexes, err))
+			}
+		}
+
+		payloads, err := compressor.SeedPayloads(values, indexes, opts)
+		if err != nil {
+			fail(withCode(errEncode, err))
+		}
+
+		if output == outputJSON {
+			printSeedPayloads(payloads, sync)
+			return
+		}
+
+		if len(payloads) == 0 {
+			fmt.Println("# all the values are already on storage")
+			return
+		}
+
+		for i, payload := range payloads {
+			fmt.Printf("# payload %d of %d, %d values, %d bytes, %d gas\n", i+1, len(payloads), len(payload.Writes), len(payload.Payload), payload.Gas)
+
+			for _, w := range payload.Writes {
+				fmt.Printf("# 0x%x %s index %d\n", w.Value, seedKind(w.Flag), w.Index)
+			}
+
+			fmt.Printf("0x%x\n", payload.Payload)
+		}
+	},
+}
+
+func init() {
+	seedCmd.Flags().String("file", "", "Read the values from this file (- for stdin), one per line, any text after # is ignored. The output of train --seeds-out can be used as is.")
+	seedCmd.Flags().Int("max-bytes", 0, "Maximum size of each payload, 0 for no limit.")
+	seedCmd.Flags().Uint64("max-gas", 0, "Maximum gas of each payload (calldata and execution, without the base cost of the transaction), 0 for no limit.")
+}
+
+type seedPayloadOutput struct {
+	Payload string        `json:"payload"`
+	Size    int           `json:"size"`
+	Gas     uint64        `json:"gas"`
+	Writes  []writeOutput `json:"writes"`
+}
+
+type seedOutput struct {
+	Payloads []seedPayloadOutput `json:"payloads"`
+	Indexes  *indexSync          `json:"indexes,omitempty"`
+}
+
+func printSeedPayloads(payloads []*compressor.SeedPayload, sync *indexSync) {
+	out := &seedOutput{Payloads: make([]seedPayloadOutput, 0, len(payloads)), Indexes: sync}
+
+	for _, payload := range payloads {
+		p := seedPayloadOutput{
+			Payload: fmt.Sprintf("0x%x", payload.Payload),
+			Size:    len(payload.Payload),
+			Gas:     payload.Gas,
+		}
+
+		for _, w := range payload.Writes {
+			p.Writes = append(p.Writes, writeOutput{Kind: seedKind(w.Flag), Value: fmt.Sprintf("0x%x", w.Value), Index: w.Index})
+		}
+
+		out.Payloads = append(out.Payloads, p)
+	}
+
+	printJSON(out)
+}
+
+func seedKind(flag uint) string {
+	if flag == compressor.FLAG_SAVE_ADDRESS {
+		return "address"
+	}
+
+	return "bytes32"
+}
+
+// Values are hex, addresses are 20 bytes and bytes32 are 32 bytes
+func readSeedValues(cmd *cobra.Command, args []string) ([][]byte, error) {
+	lines := append([]string{}, args...)
+
+	path, err := cmd.Flags().GetString("file")
+	if err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		var r io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+
+			defer f.Close()
+			r = f
+		}
+
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var values [][]byte
+	for _, line := range lines {
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		value := common.FromHex(line)
+		if len(value) != 20 && len(value) != 32 {
+			return nil, fmt.Errorf("invalid value %s, expected an address or a bytes32", line)
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// The gas of the payloads is priced with the cost model, the size model has no gas so L1 prices are used instead
+func useSeedOptions(cmd *cobra.Command) (*compressor.SeedOptions, error) {
+	opts := &compressor.SeedOptions{}
+
+	var err error
+	opts.MaxBytes, err = cmd.Flags().GetInt("max-bytes")
+	if err != nil {
+		return nil, err
+	}
+
+	opts.MaxGas, err = cmd.Flags().GetUint64("max-gas")
+	if err != nil {
+		return nil, err
+	}
+
+	costModelName, err := cmd.Flags().GetString("cost-model")
+	if err != nil {
+		return nil, err
+	}
+
+	if costModelName != "size" {
+		costModel, ok := compressor.CostModelByName(costModelName)
+		if !ok {
+			return nil, fmt.Errorf("unknown cost model %s", costModelName)
+		}
+
+		opts.CostModel = costModel
+	}
+
+	return opts, nil
+}
+
+// Number of values on the contract at the block the indexes were loaded at, the
+// seeded values are written after them. Without the indexes the totals are read
+// on the confirmed block, the same one the indexes would be loaded at.
+func useContractTotals(cmd *cobra.Command, sync *indexSync) (uint, uint, error) {
+	if sync != nil && sync.IndexMetadata != nil {
+		return sync.Addresses, sync.Bytes32, nil
+	}
+
+	providerUrl, err := cmd.Flags().GetString("provider")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if providerUrl == "" {
+		return 0, 0, fmt.Errorf("provider is required to read the contract totals, use --provider")
+	}
+
+	contractAddr, err := cmd.Flags().GetString("contract")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !common.IsHexAddress(contractAddr) || common.HexToAddress(contractAddr) == (common.Address{}) {
+		return 0, 0, fmt.Errorf("contract address is required, use --contract")
+	}
+
+	confirmations, err := cmd.Flags().GetUint("confirmations")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	provider, err := ethrpc.NewProvider(providerUrl)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	asize, bsize, err := compressor.GetTotals(context.Background(), provider, common.HexToAddress(contractAddr), confirmations)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return asize - 1, bsize - 1, nil
+}
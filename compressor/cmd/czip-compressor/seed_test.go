@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/spf13/cobra"
+)
+
+func totalsCmd(provider string, contract string) *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Flags().String("provider", provider, "")
+	cmd.Flags().String("contract", contract, "")
+	cmd.Flags().Uint("confirmations", 2, "")
+	return cmd
+}
+
+func TestUseContractTotals(t *testing.T) {
+	// The totals the indexes were loaded with are used, without reading the contract again
+	sync := &indexSync{IndexMetadata: &compressor.IndexMetadata{Block: 10, Addresses: 41, Bytes32: 7}}
+
+	addresses, bytes32, err := useContractTotals(totalsCmd("", ""), sync)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addresses != 41 || bytes32 != 7 {
+		t.Fatalf("totals %d and %d, expected 41 and 7", addresses, bytes32)
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		contract string
+	}{
+		{name: "no provider", contract: "0x8ba1f109551bd432803012645ac136ddd64dba72"},
+		{name: "no contract", provider: "http://127.0.0.1:1"},
+		{name: "zero contract", provider: "http://127.0.0.1:1", contract: "0x0000000000000000000000000000000000000000"},
+		{name: "invalid contract", provider: "http://127.0.0.1:1", contract: "0x1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := useContractTotals(totalsCmd(tt.provider, tt.contract), nil); err == nil {
+				t.Fatalf("totals read without a valid provider and contract")
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/0xsequence/ethkit/ethrpc"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the encode commands over HTTP, keeping the indexes of each chain in memory.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		server, err := newServer(ctx, cmd, dialChain)
+		if err != nil {
+			fail(err)
+		}
+
+		listen, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			fail(withCode(errInvalidOptions, err))
+		}
+
+		refresh, err := cmd.Flags().GetDuration("refresh")
+		if err != nil {
+			fail(withCode(errInvalidOptions, err))
+		}
+
+		for _, chain := range server.chains {
+			go server.refreshLoop(ctx, chain, refresh)
+		}
+
+		httpServer := &http.Server{Addr: listen, Handler: server.handler()}
+
+		go func() {
+			<-ctx.Done()
+
+			shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			httpServer.Shutdown(shutdown)
+		}()
+
+		fmt.Fprintf(os.Stderr, "listening on %s\n", listen)
+
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fail(withCode(errIO, err))
+		}
+	},
+}
+
+func init() {
+	serveCmd.Flags().String("listen", "localhost:8080", "Address the HTTP server listens on.")
+	serveCmd.Flags().StringArray("chain", []string{}, "RPC provider URL of a chain to serve, it can be repeated. The chain id is read from the provider, and all chains use --contract.")
+	serveCmd.Flags().Duration("refresh", 30*time.Second, "How often the indexes of each chain are synced with the contract.")
+}
+
+// The indexes of a chain, they are replaced on each refresh and never
+// modified, so requests can keep using the ones they started with.
+type chainIndexes struct {
+	chainId  uint64
+	provider chainReader
+
+	mutex     sync.RWMutex
+	indexes   *compressor.Indexes
+	meta      *compressor.IndexMetadata
+	refreshed time.Time
+	err       error
+}
+
+func (c *chainIndexes) current() (*compressor.Indexes, *compressor.IndexMetadata) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.indexes, c.meta
+}
+
+type server struct {
+	cmd    *cobra.Command
+	opts   *compressor.CompressorOptions
+	bytes4 map[string]uint
+
+	// Only used with --use-storage, otherwise all requests use empty indexes
+	chains map[uint64]*chainIndexes
+	empty  *compressor.Indexes
+}
+
+func dialChain(url string) (chainReader, error) {
+	return ethrpc.NewProvider(url)
+}
+
+// Loads the indexes of every chain before serving, a chain that can't be loaded is an error.
+// Each URL of --chain and --provider is connected to with dial.
+func newServer(ctx context.Context, cmd *cobra.Command, dial func(url string) (chainReader, error)) (*server, error) {
+	opts, err := useCompressorOptions(cmd)
+	if err != nil {
+		return nil, withCode(errInvalidOptions, err)
+	}
+
+	bytes4, err := useBytes4Indexes(cmd)
+	if err != nil {
+		return nil, withCode(errInvalidOptions, err)
+	}
+
+	s := &server{
+		cmd:    cmd,
+		opts:   opts,
+		bytes4: bytes4,
+		chains: make(map[uint64]*chainIndexes),
+		empty: &compressor.Indexes{
+			AddressIndexes: make(map[string]uint),
+			Bytes32Indexes: make(map[string]uint),
+			Bytes4Indexes:  bytes4,
+		},
+	}
+
+	if !opts.UseStorage {
+		return s, nil
+	}
+
+	urls, err := cmd.Flags().GetStringArray("chain")
+	if err != nil {
+		return nil, withCode(errInvalidOptions, err)
+	}
+
+	if providerUrl, _ := cmd.Flags().GetString("provider"); providerUrl != "" {
+		urls = append(urls, providerUrl)
+	}
+
+	if len(urls) == 0 {
+		return nil, withCode(errInvalidOptions, fmt.Errorf("at least one chain is required to use the storage indexes, use --chain or --provider"))
+	}
+
+	for _, url := range urls {
+		provider, err := dial(url)
+		if err != nil {
+			return nil, withCode(errInvalidOptions, err)
+		}
+
+		chainId, err := provider.ChainID(ctx)
+		if err != nil {
+			return nil, withCode(errIndexes, fmt.Errorf("chain %s: %w", url, err))
+		}
+
+		if _, ok := s.chains[chainId.Uint64()]; ok {
+			return nil, withCode(errInvalidOptions, fmt.Errorf("chain %d is served twice", chainId.Uint64()))
+		}
+
+		chain := &chainIndexes{chainId: chainId.Uint64(), provider: provider}
+		if err := s.refresh(ctx, chain); err != nil {
+			return nil, withCode(errIndexes, fmt.Errorf("chain %d: %w", chain.chainId, err))
+		}
+
+		s.chains[chain.chainId] = chain
+	}
+
+	return s, nil
+}
+
+// Syncs the indexes of the chain, on error the previous indexes are kept
+func (s *server) refresh(ctx context.Context, chain *chainIndexes) error {
+	indexes, sync, err := loadStorageIndexes(ctx, s.cmd, chain.provider)
+
+	var meta *compressor.IndexMetadata
+	if err == nil {
+		indexes.Bytes4Indexes = s.bytes4
+		meta = sync.IndexMetadata
+	}
+
+	chain.mutex.Lock()
+	defer chain.mutex.Unlock()
+
+	chain.err = err
+	if err != nil {
+		return err
+	}
+
+	chain.indexes, chain.meta, chain.refreshed = indexes, meta, time.Now()
+	return nil
+}
+
+func (s *server) refreshLoop(ctx context.Context, chain *chainIndexes, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.refresh(ctx, chain); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "chain %d: refresh failed: %v\n", chain.chainId, err)
+			}
+		}
+	}
+}
+
+type serveCall struct {
+	Data string `json:"data"`
+	To   string `json:"to"`
+}
+
+type serveRequest struct {
+	// Can be omitted when a single chain is served, or without storage
+	ChainID uint64 `json:"chainId"`
+
+	// How the decompressor handles the payload, the subcommands of the CLI (decode, call, call-return)
+	Method string `json:"method"`
+
+	Data     string      `json:"data"`
+	To       string      `json:"to"`
+	Calls    []serveCall `json:"calls"`
+	Wallet   string      `json:"wallet"`
+	Execdata string      `json:"execdata"`
+}
+
+type serveResponse struct {
+	Payload string `json:"payload"`
+
+	// Chain and block of the indexes used to encode the payload
+	ChainID uint64 `json:"chainId,omitempty"`
+	Block   uint64 `json:"block,omitempty"`
+}
+
+type serveError struct {
+	Error string `json:"error"`
+}
+
+type chainStatus struct {
+	ChainID   uint64    `json:"chainId"`
+	Block     uint64    `json:"block"`
+	Addresses uint      `json:"addresses"`
+	Bytes32   uint      `json:"bytes32"`
+	Refreshed time.Time `json:"refreshed"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Methods of each endpoint, by the name of the subcommand of the CLI
+var serveMethods = map[string]map[string]uint{
+	"encode-any": {
+		"": compressor.METHOD_DECODE_ANY,
+	},
+	"encode-call": {
+		"decode":      compressor.METHOD_DECODE_CALL,
+		"call":        compressor.METHOD_EXECUTE_CALL,
+		"call-return": compressor.METHOD_EXECUTE_CALL_RETURN,
+	},
+	"encode-calls": {
+		"decode": compressor.METHOD_DECODE_N_CALLS,
+		"call":   compressor.METHOD_EXECUTE_N_CALLS,
+	},
+	"encode-sequence-tx": {
+		"decode": compressor.METHOD_DECODE_SEQUENCE_TX,
+		"call":   compressor.METHOD_EXECUTE_SEQUENCE_TX,
+	},
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/encode-any", s.handleEncode("encode-any", func(c *compressor.Compressor, method uint, req *serveRequest) (*compressor.EncodeResult, error) {
+		return c.EncodeAny(common.FromHex(req.Data))
+	}))
+	mux.HandleFunc("/encode-call", s.handleEncode("encode-call", func(c *compressor.Compressor, method uint, req *serveRequest) (*compressor.EncodeResult, error) {
+		return c.EncodeCall(method, common.FromHex(req.To), common.FromHex(req.Data))
+	}))
+	mux.HandleFunc("/encode-calls", s.handleEncode("encode-calls", func(c *compressor.Compressor, method uint, req *serveRequest) (*compressor.EncodeResult, error) {
+		addrs := make([][]byte, len(req.Calls))
+		datas := make([][]byte, len(req.Calls))
+
+		for i, call := range req.Calls {
+			addrs[i] = common.FromHex(call.To)
+			datas[i] = common.FromHex(call.Data)
+		}
+
+		return c.EncodeCalls(method, addrs, datas)
+	}))
+	mux.HandleFunc("/encode-sequence-tx", s.handleEncode("encode-sequence-tx", func(c *compressor.Compressor, method uint, req *serveRequest) (*compressor.EncodeResult, error) {
+		return c.EncodeSequenceTx(method, common.FromHex(req.Wallet), common.FromHex(req.Execdata))
+	}))
+
+	return mux
+}
+
+func (s *server) handleEncode(endpoint string, encode func(*compressor.Compressor, uint, *serveRequest) (*compressor.EncodeResult, error)) http.HandlerFunc {
+	methods := serveMethods[endpoint]
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, &serveError{Error: "use POST"})
+			return
+		}
+
+		var req serveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, &serveError{Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+
+		method, ok := methods[req.Method]
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, &serveError{Error: fmt.Sprintf("unknown method %q for %s", req.Method, endpoint)})
+			return
+		}
+
+		indexes, meta, err := s.indexes(req.ChainID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, &serveError{Error: err.Error()})
+			return
+		}
+
+		// The options are shared, only the indexes change between requests
+		opts := *s.opts
+		opts.Indexes = compressor.StaticIndexes(indexes)
+
+		c, err := compressor.NewCompressor(&opts)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, &serveError{Error: err.Error()})
+			return
+		}
+
+		encoded, err := encode(c, method, &req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, &serveError{Error: err.Error()})
+			return
+		}
+
+		res := &serveResponse{Payload: fmt.Sprintf("0x%x", encoded.Payload)}
+		if meta != nil {
+			res.ChainID, res.Block = meta.ChainID, meta.Block
+		}
+
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// Returns the current indexes of the chain, a chain id of 0 picks the only chain served
+func (s *server) indexes(chainId uint64) (*compressor.Indexes, *compressor.IndexMetadata, error) {
+	if !s.opts.UseStorage {
+		return s.empty, nil, nil
+	}
+
+	if chainId == 0 {
+		if len(s.chains) != 1 {
+			return nil, nil, fmt.Errorf("chainId is required, %d chains are served", len(s.chains))
+		}
+
+		for _, chain := range s.chains {
+			indexes, meta := chain.current()
+			return indexes, meta, nil
+		}
+	}
+
+	chain, ok := s.chains[chainId]
+	if !ok {
+		return nil, nil, fmt.Errorf("chain %d is not served", chainId)
+	}
+
+	indexes, meta := chain.current()
+	return indexes, meta, nil
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := make([]*chainStatus, 0, len(s.chains))
+
+	for _, chain := range s.chains {
+		chain.mutex.RLock()
+
+		st := &chainStatus{
+			ChainID:   chain.chainId,
+			Block:     chain.meta.Block,
+			Addresses: chain.meta.Addresses,
+			Bytes32:   chain.meta.Bytes32,
+			Refreshed: chain.refreshed,
+		}
+
+		if chain.err != nil {
+			st.Error = chain.err.Error()
+		}
+
+		chain.mutex.RUnlock()
+		status = append(status, st)
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].ChainID < status[j].ChainID
+	})
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
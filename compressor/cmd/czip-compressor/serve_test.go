@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/0xsequence/ethkit/go-ethereum"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// A chain with a decompressor that has the given addresses on storage
+type stubChain struct {
+	mutex sync.Mutex
+
+	chainId   uint64
+	block     uint64
+	addresses []common.Address
+
+	// Every call fails with it, if set
+	err error
+}
+
+func (c *stubChain) setErr(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.err = err
+}
+
+func (c *stubChain) ChainID(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).SetUint64(c.chainId), nil
+}
+
+func (c *stubChain) BlockNumber(ctx context.Context) (uint64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.block, c.err
+}
+
+func (c *stubChain) CodeAt(ctx context.Context, account common.Address, blockNum *big.Int) ([]byte, error) {
+	return []byte{0x01}, nil
+}
+
+func (c *stubChain) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNum *big.Int) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	switch uint(msg.Data[0]) {
+	case compressor.METHOD_READ_SIZES:
+		res := make([]byte, 32)
+		binary.BigEndian.PutUint64(res[8:16], uint64(len(c.addresses)))
+		return res, nil
+
+	case compressor.METHOD_READ_STORAGE_SLOTS:
+		var res []byte
+		for i := 1; i+32 <= len(msg.Data); i += 32 {
+			word := make([]byte, 32)
+			for j, addr := range c.addresses {
+				if bytes.Equal(msg.Data[i:i+32], compressor.AddressIndex(uint(j+1))) {
+					word = common.LeftPadBytes(addr.Bytes(), 32)
+				}
+			}
+
+			res = append(res, word...)
+		}
+
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("unexpected call %x", msg.Data)
+}
+
+// A command with its own copy of the flags of serve, parsed from args
+func serveTestCmd(t *testing.T, args ...string) *cobra.Command {
+	cmd := &cobra.Command{}
+
+	clone := func(f *pflag.Flag) {
+		switch f.Value.Type() {
+		case "bool":
+			v, _ := strconv.ParseBool(f.DefValue)
+			cmd.Flags().Bool(f.Name, v, "")
+		case "uint":
+			v, _ := strconv.ParseUint(f.DefValue, 10, 64)
+			cmd.Flags().Uint(f.Name, uint(v), "")
+		case "duration":
+			cmd.Flags().Duration(f.Name, 0, "")
+		case "stringSlice":
+			cmd.Flags().StringSlice(f.Name, nil, "")
+		case "stringArray":
+			cmd.Flags().StringArray(f.Name, nil, "")
+		default:
+			cmd.Flags().String(f.Name, f.DefValue, "")
+		}
+	}
+
+	rootCmd.PersistentFlags().VisitAll(clone)
+	serveCmd.Flags().VisitAll(clone)
+
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd
+}
+
+func postJSON(t *testing.T, url string, body interface{}, res interface{}) int {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(res); err != nil {
+		t.Fatal(err)
+	}
+
+	return r.StatusCode
+}
+
+func TestServe(t *testing.T) {
+	stored := common.HexToAddress("0x8ba1f109551bd432803012645ac136ddd64dba72")
+
+	chains := map[string]*stubChain{
+		"chain-1": {chainId: 1, block: 100, addresses: []common.Address{stored}},
+		"chain-2": {chainId: 2, block: 200},
+	}
+
+	cmd := serveTestCmd(t,
+		"--use-storage",
+		"--contract", "0x8C6C8dBcfe6cA5F5D9E05B4F7ff4DF9e9Ae9f73c",
+		"--cache-dir", t.TempDir(),
+		"--chain", "chain-1",
+		"--chain", "chain-2",
+	)
+
+	s, err := newServer(context.Background(), cmd, func(url string) (chainReader, error) {
+		chain, ok := chains[url]
+		if !ok {
+			return nil, fmt.Errorf("unknown chain %s", url)
+		}
+
+		return chain, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	call := map[string]interface{}{
+		"method": "decode",
+		"to":     stored.Hex(),
+		"data":   "0xa9059cbb0000000000000000000000008ba1f109551bd432803012645ac136ddd64dba720000000000000000000000000000000000000000000000000000000000000001",
+	}
+
+	encode := func(chainId uint64) (int, *serveResponse, *serveError) {
+		call["chainId"] = chainId
+
+		var res struct {
+			serveResponse
+			serveError
+		}
+
+		code := postJSON(t, server.URL+"/encode-call", call, &res)
+		return code, &res.serveResponse, &res.serveError
+	}
+
+	// Each chain uses its own indexes, on the confirmed block
+	code, res1, _ := encode(1)
+	if code != http.StatusOK || res1.ChainID != 1 || res1.Block != 98 {
+		t.Fatalf("chain 1: status %d, chain %d and block %d, expected 200, 1 and 98", code, res1.ChainID, res1.Block)
+	}
+
+	code, res2, _ := encode(2)
+	if code != http.StatusOK || res2.ChainID != 2 || res2.Block != 198 {
+		t.Fatalf("chain 2: status %d, chain %d and block %d, expected 200, 2 and 198", code, res2.ChainID, res2.Block)
+	}
+
+	// Only chain 1 has the address on storage, chain 2 writes it
+	if len(res1.Payload) >= len(res2.Payload) {
+		t.Fatalf("payload of chain 1 %s is not shorter than the one of chain 2 %s", res1.Payload, res2.Payload)
+	}
+
+	// With many chains the chain id is required
+	if code, _, serr := encode(0); code != http.StatusBadRequest || serr.Error == "" {
+		t.Fatalf("chain 0: status %d, expected 400", code)
+	}
+
+	if code, _, _ := encode(3); code != http.StatusBadRequest {
+		t.Fatalf("chain 3: status %d, expected 400", code)
+	}
+
+	// A failed refresh keeps the indexes of the last one
+	chains["chain-1"].setErr(errors.New("connection refused"))
+
+	if err := s.refresh(context.Background(), s.chains[1]); err == nil {
+		t.Fatalf("refresh of chain 1 didn't fail")
+	}
+
+	code, res, _ := encode(1)
+	if code != http.StatusOK || res.Block != 98 || res.Payload != res1.Payload {
+		t.Fatalf("chain 1 after a failed refresh: status %d, block %d and payload %s", code, res.Block, res.Payload)
+	}
+
+	r, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Body.Close()
+
+	var status []*chainStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status) != 2 || status[0].Error != "connection refused" || status[0].Block != 98 || status[1].Error != "" {
+		t.Fatalf("unexpected status %+v %+v", status[0], status[1])
+	}
+}
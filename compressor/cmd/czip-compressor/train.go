@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+var trainCmd = &cobra.Command{
+	Use:   "train",
+	Short: "Learn a selector table and the values worth seeding on storage from a corpus of calldata: <file or - for stdin>",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		trainer, err := readCorpus(args[0])
+		if err != nil {
+			fail(withCode(errIO, err))
+		}
+
+		if trainer.Samples() == 0 {
+			fail(withCode(errInvalidArguments, fmt.Errorf("the corpus is empty")))
+		}
+
+		limit, err := cmd.Flags().GetInt("seeds")
+		if err != nil {
+			fail(withCode(errInvalidOptions, err))
+		}
+
+		costModelName, err := cmd.Flags().GetString("cost-model")
+		if err != nil {
+			fail(withCode(errInvalidOptions, err))
+		}
+
+		costModel, ok := compressor.CostModelByName(costModelName)
+		if !ok {
+			fail(withCode(errInvalidOptions, fmt.Errorf("unknown cost model %s", costModelName)))
+		}
+
+		candidates, err := trainer.SeedCandidates(limit)
+		if err != nil {
+			fail(withCode(errEncode, err))
+		}
+
+		report, err := trainer.Report(candidates, costModel)
+		if err != nil {
+			fail(withCode(errEncode, err))
+		}
+
+		table := trainer.Bytes4Table()
+
+		if path, _ := cmd.Flags().GetString("bytes4-out"); path != "" {
+			if err := os.WriteFile(path, []byte(formatTrainedTable(trainer, table)), 0644); err != nil {
+				fail(withCode(errIO, err))
+			}
+		}
+
+		if path, _ := cmd.Flags().GetString("seeds-out"); path != "" {
+			if err := os.WriteFile(path, []byte(formatSeeds(candidates)), 0644); err != nil {
+				fail(withCode(errIO, err))
+			}
+		}
+
+		if output == outputJSON {
+			printJSON(newTrainOutput(report, table, candidates))
+		} else {
+			printTrainReport(report, table, candidates)
+		}
+	},
+}
+
+func init() {
+	trainCmd.Flags().Int("seeds", 1000, "Maximum number of values to seed on storage, 0 for no limit.")
+	trainCmd.Flags().String("bytes4-out", "", "Write the selector table to this file, it can be used with --bytes4-table.")
+	trainCmd.Flags().String("seeds-out", "", "Write the values to seed on storage to this file, one per line, in the order they should be seeded.")
+}
+
+// Each line of the corpus is either the calldata as hex, or a JSON object with "to" and "data"
+func readCorpus(path string) (*compressor.Trainer, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+		r = f
+	}
+
+	trainer := compressor.NewTrainer()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "{") {
+			trainer.Add(nil, common.FromHex(line))
+			continue
+		}
+
+		var call struct {
+			To   string `json:"to"`
+			Data string `json:"data"`
+		}
+
+		if err := json.Unmarshal([]byte(line), &call); err != nil {
+			return nil, fmt.Errorf("invalid corpus line %d: %w", n, err)
+		}
+
+		var to []byte
+		if call.To != "" {
+			to = common.HexToAddress(call.To).Bytes()
+		}
+
+		trainer.Add(to, common.FromHex(call.Data))
+	}
+
+	return trainer, scanner.Err()
+}
+
+type trainOutput struct {
+	Samples      int `json:"samples"`
+	Size         int `json:"size"`
+	Compressed   int `json:"compressed"`
+	TrainedTable int `json:"trainedTable"`
+	Trained      int `json:"trained"`
+	Selectors    int `json:"selectors"`
+	Seeds        int `json:"seeds"`
+	SeedSavings  int `json:"seedSavings"`
+}
+
+func newTrainOutput(report *compressor.TrainReport, table []byte, candidates []compressor.SeedCandidate) *trainOutput {
+	out := &trainOutput{
+		Samples:      report.Samples,
+		Size:         report.Size,
+		Compressed:   report.Compressed,
+		TrainedTable: report.TrainedTable,
+		Trained:      report.Trained,
+		Selectors:    len(table)/4 - 1,
+		Seeds:        len(candidates),
+	}
+
+	for _, c := range candidates {
+		out.SeedSavings += c.Savings
+	}
+
+	return out
+}
+
+func printTrainReport(report *compressor.TrainReport, table []byte, candidates []compressor.SeedCandidate) {
+	ratio := func(size int) string {
+		return fmt.Sprintf("%d bytes (%.2f%%)", size, 100*float64(size)/float64(report.Size))
+	}
+
+	savings := 0
+	for _, c := range candidates {
+		savings += c.Savings
+	}
+
+	fmt.Printf("samples: %d\n", report.Samples)
+	fmt.Printf("calldata: %d bytes\n", report.Size)
+	fmt.Printf("compressed: %s\n", ratio(report.Compressed))
+	fmt.Printf("with trained table (%d selectors): %s\n", len(table)/4-1, ratio(report.TrainedTable))
+	fmt.Printf("with trained table and %d seeded values: %s\n", len(candidates), ratio(report.Trained))
+	fmt.Printf("projected seeding savings: %d bytes\n", savings)
+}
+
+// The table is written as a list of selectors, starting on index 1, see compressor.ParseBytes4Table
+func formatTrainedTable(trainer *compressor.Trainer, table []byte) string {
+	counts := make(map[string]uint)
+	for _, s := range trainer.Selectors() {
+		counts[string(s.Selector)] = s.Count
+	}
+
+	var b strings.Builder
+	b.WriteString("# selector table trained on the corpus, most used first\n")
+
+	// Empty entries are left out, the table is padded with zeros when it is loaded
+	for i := 4; i < len(table); i += 4 {
+		if bytes.Equal(table[i:i+4], make([]byte, 4)) {
+			break
+		}
+
+		fmt.Fprintf(&b, "0x%x # index %d, %d calls\n", table[i:i+4], i/4, counts[string(table[i:i+4])])
+	}
+
+	return b.String()
+}
+
+func formatSeeds(candidates []compressor.SeedCandidate) string {
+	var b strings.Builder
+	b.WriteString("# values to seed on storage, in order\n")
+
+	for _, c := range candidates {
+		kind := "bytes32"
+		if c.Flag == compressor.FLAG_SAVE_ADDRESS {
+			kind = "address"
+		}
+
+		fmt.Fprintf(&b, "0x%x # %s index %d, %d uses, saves %d bytes\n", c.Value, kind, c.Index, c.Count, c.Savings)
+	}
+
+	return b.String()
+}
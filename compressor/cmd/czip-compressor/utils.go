@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
 
 	encoder "github.com/0xsequence/czip/compressor"
+	"github.com/0xsequence/ethkit/go-ethereum/accounts/abi"
 )
 
 func ensureDir(path string) error {
@@ -27,43 +29,39 @@ func ensureDir(path string) error {
 	return nil
 }
 
-func FindOpcodesForFlag(flag string) []uint {
-	res := make([]uint, 0)
+// Reads a JSON ABI, it can also be a build artifact with an "abi" field
+func loadABI(path string) (*abi.ABI, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read abi: %s, error: %v", path, err)
+	}
 
-	for val, op := range encoder.FlagNames() {
-		// Fuzzy match
-		if strings.Contains(strings.ToLower(val), strings.ToLower(flag)) {
-			res = append(res, op)
-		}
+	var artifact struct {
+		ABI json.RawMessage `json:"abi"`
 	}
 
-	if len(res) == 0 {
-		fmt.Println("Error: Invalid opcode flag", flag)
-		os.Exit(1)
+	if err := json.Unmarshal(data, &artifact); err == nil && len(artifact.ABI) != 0 {
+		data = artifact.ABI
 	}
 
-	return res
-}
+	contractABI, err := abi.JSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid abi: %s, error: %v", path, err)
+	}
 
-func ParseAllowOpcodes(allow []string, disable []string) *encoder.AllowOpcodes {
-	var res encoder.AllowOpcodes
-	res.List = make(map[uint]bool)
-	var val []string
+	return &contractABI, nil
+}
 
-	if len(allow) != 0 {
-		val = allow
-		res.Default = false
-	} else {
-		val = disable
-		res.Default = true
+// Stats go to stderr, so stdout only contains the payload
+func printStats(stats *encoder.Stats) {
+	if stats == nil {
+		return
 	}
 
-	for _, part := range val {
-		opcodes := FindOpcodesForFlag(part)
-		for _, opcode := range opcodes {
-			res.List[opcode] = true
-		}
+	out, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		fail(err)
 	}
 
-	return &res
+	fmt.Fprintln(os.Stderr, string(out))
 }
@@ -1,3 +1,268 @@
 package compressor
 
+import (
+	"fmt"
+
+	"github.com/0xsequence/ethkit/go-ethereum/accounts/abi"
+	"github.com/0xsequence/go-sequence"
+)
+
 var VERSION = "dev"
+
+// Provides the indexes used to encode each payload, they must not be modified
+// while a payload is being encoded. Ledger implements it with the pending writes.
+type IndexSource interface {
+	Indexes() *Indexes
+}
+
+type staticIndexes struct {
+	indexes *Indexes
+}
+
+func (s *staticIndexes) Indexes() *Indexes {
+	return s.indexes
+}
+
+// Uses the same indexes for every payload
+func StaticIndexes(indexes *Indexes) IndexSource {
+	return &staticIndexes{indexes: indexes}
+}
+
+type CompressorOptions struct {
+	// Indexes of the decompressor, if nil every payload uses empty
+	// indexes with the default selector table
+	Indexes IndexSource
+
+	// Only these opcodes are used, or all but the disallowed ones, only one of them can be set
+	AllowOpcodes    []uint
+	DisallowOpcodes []uint
+
+	// Read and write values on the storage of the decompressor
+	UseStorage bool
+
+	// Used to choose between encodings, if nil only the size is taken into account
+	CostModel CostModel
+
+	// If set, calldata of the methods of this ABI is encoded using the types of the arguments
+	ABI *abi.ABI
+
+	// Records the flags of each payload, and reports them on EncodeResult.Stats
+	Stats bool
+}
+
+// Encodes payloads for a decompressor, each payload uses a new Buffer
+// so a Compressor can be used from many goroutines at the same time.
+type Compressor struct {
+	indexes      IndexSource
+	allowOpcodes *AllowOpcodes
+	useStorage   bool
+	costModel    CostModel
+	abi          *abi.ABI
+	stats        bool
+}
+
+type EncodeResult struct {
+	Payload []byte
+	Method  uint
+
+	// The highest priority encoding used by the payload, WriteStorage if it saves any value
+	EncodeType EncodeType
+
+	// Size of the calldata before and after compression
+	Size           int
+	CompressedSize int
+
+	// Values saved on storage by the payload, in the order they are executed
+	Writes []StorageWrite
+
+	// Version of the indexes used, if they come from a VersionedIndexSource
+	IndexesVersion uint64
+
+	// Flags used by the payload, only if CompressorOptions.Stats is set
+	Stats *Stats
+}
+
+func NewCompressor(opts *CompressorOptions) (*Compressor, error) {
+	if opts == nil {
+		opts = &CompressorOptions{}
+	}
+
+	if len(opts.AllowOpcodes) != 0 && len(opts.DisallowOpcodes) != 0 {
+		return nil, fmt.Errorf("allowed and disallowed opcodes can't be used together")
+	}
+
+	c := &Compressor{
+		indexes:    opts.Indexes,
+		useStorage: opts.UseStorage,
+		costModel:  opts.CostModel,
+		abi:        opts.ABI,
+		stats:      opts.Stats,
+	}
+
+	if len(opts.AllowOpcodes) != 0 {
+		c.allowOpcodes = &AllowOpcodes{Default: false, List: make(map[uint]bool)}
+		for _, op := range opts.AllowOpcodes {
+			c.allowOpcodes.List[op] = true
+		}
+	} else if len(opts.DisallowOpcodes) != 0 {
+		c.allowOpcodes = &AllowOpcodes{Default: true, List: make(map[uint]bool)}
+		for _, op := range opts.DisallowOpcodes {
+			c.allowOpcodes.List[op] = true
+		}
+	}
+
+	return c, nil
+}
+
+// Returns a buffer with the options of the compressor, to encode payloads that have no
+// method of their own. The current indexes are pinned when the buffer is created.
+func (c *Compressor) NewBuffer(method uint) *Buffer {
+	var indexes *Indexes
+	var version uint64
+
+	if versioned, ok := c.indexes.(VersionedIndexSource); ok {
+		indexes, version = versioned.Snapshot()
+	} else if c.indexes != nil {
+		indexes = c.indexes.Indexes()
+	}
+
+	if indexes == nil {
+		indexes = &Indexes{
+			AddressIndexes: make(map[string]uint),
+			Bytes32Indexes: make(map[string]uint),
+			Bytes4Indexes:  LoadBytes4(),
+		}
+	}
+
+	buf := NewBuffer(method, indexes, c.allowOpcodes, c.useStorage)
+	buf.Refs.CostModel = c.costModel
+	buf.Refs.ABI = c.abi
+	buf.Refs.IndexesVersion = version
+	buf.Refs.RecordStats = c.stats
+
+	return buf
+}
+
+func checkMethod(method uint, allowed ...uint) error {
+	for _, m := range allowed {
+		if method == m {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid method %d", method)
+}
+
+// Result of the payload written so far, t is the EncodeType returned by the writes
+// and size the size of the data they encode, used by the payloads written on a Buffer
+func (cb *Buffer) Result(t EncodeType, size int) *EncodeResult {
+	var stats *Stats
+	if cb.Refs.RecordStats {
+		stats = cb.Stats(size)
+	}
+
+	return &EncodeResult{
+		Payload:        cb.Commited,
+		Method:         uint(cb.Commited[0]),
+		EncodeType:     t,
+		Size:           size,
+		CompressedSize: cb.Len(),
+		Writes:         cb.StorageWrites(),
+		IndexesVersion: cb.Refs.IndexesVersion,
+		Stats:          stats,
+	}
+}
+
+// Compresses any data, the decompressor returns it as is
+func (c *Compressor) EncodeAny(data []byte) (*EncodeResult, error) {
+	buf := c.NewBuffer(METHOD_DECODE_ANY)
+
+	t, err := buf.WriteBytesOptimized(data, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Result(t, len(data)), nil
+}
+
+// Compresses a call, method is METHOD_DECODE_CALL, METHOD_EXECUTE_CALL or METHOD_EXECUTE_CALL_RETURN
+func (c *Compressor) EncodeCall(method uint, to []byte, data []byte) (*EncodeResult, error) {
+	if err := checkMethod(method, METHOD_DECODE_CALL, METHOD_EXECUTE_CALL, METHOD_EXECUTE_CALL_RETURN); err != nil {
+		return nil, err
+	}
+
+	if len(to) != 20 {
+		return nil, fmt.Errorf("invalid address length")
+	}
+
+	buf := c.NewBuffer(method)
+
+	t, err := buf.WriteCall(to, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Result(t, len(data)), nil
+}
+
+// Compresses many calls into one payload, method is METHOD_DECODE_N_CALLS or METHOD_EXECUTE_N_CALLS
+func (c *Compressor) EncodeCalls(method uint, tos [][]byte, datas [][]byte) (*EncodeResult, error) {
+	if err := checkMethod(method, METHOD_DECODE_N_CALLS, METHOD_EXECUTE_N_CALLS); err != nil {
+		return nil, err
+	}
+
+	size := 0
+	for i, to := range tos {
+		if len(to) != 20 {
+			return nil, fmt.Errorf("invalid address length on call %d", i)
+		}
+
+		if i < len(datas) {
+			size += len(datas[i])
+		}
+	}
+
+	buf := c.NewBuffer(method)
+
+	t, err := buf.WriteCalls(tos, datas)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Result(t, size), nil
+}
+
+// Compresses a Sequence wallet transaction, data is the calldata of the execute method of the wallet.
+// Method is METHOD_DECODE_SEQUENCE_TX or METHOD_EXECUTE_SEQUENCE_TX.
+func (c *Compressor) EncodeSequenceTx(method uint, wallet []byte, data []byte) (*EncodeResult, error) {
+	if err := checkMethod(method, METHOD_DECODE_SEQUENCE_TX, METHOD_EXECUTE_SEQUENCE_TX); err != nil {
+		return nil, err
+	}
+
+	if len(wallet) != 20 {
+		return nil, fmt.Errorf("invalid address length")
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("invalid data length")
+	}
+
+	txs, nonce, sig, err := sequence.DecodeExecdata(data)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := c.NewBuffer(method)
+
+	t, err := buf.WriteSequenceExecute(wallet, &sequence.Transaction{
+		Nonce:        nonce,
+		Transactions: txs,
+		Signature:    sig,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Result(t, len(data)), nil
+}
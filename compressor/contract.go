@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"math/big"
 
-	"github.com/0xsequence/ethkit/ethrpc"
 	"github.com/0xsequence/ethkit/go-ethereum"
 	"github.com/0xsequence/ethkit/go-ethereum/common"
+	"github.com/0xsequence/ethkit/go-ethereum/crypto"
 )
 
 func AddressIndex(i uint) []byte {
@@ -23,24 +23,59 @@ func Bytes32Index(i uint) []byte {
 	return padded32
 }
 
-func GetTotals(ctx context.Context, provider *ethrpc.Provider, contract common.Address, skipBlocks uint) (uint, uint, error) {
-	// Get the last block
+// Returns the latest block with at least the given number of confirmations, indexes
+// written after it may still be reorged out, so they are not loaded.
+func ConfirmedBlock(ctx context.Context, provider StateReader, confirmations uint) (uint64, error) {
 	block, err := provider.BlockNumber(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if block < uint64(confirmations) {
+		return 0, fmt.Errorf("block %d has less than %d confirmations", block, confirmations)
+	}
+
+	return block - uint64(confirmations), nil
+}
+
+// Returns the hash of the code of the decompressor on the given block, it identifies
+// the deployment, as a redeployed contract starts with empty storage.
+func ContractCodeHash(ctx context.Context, provider StateReader, contract common.Address, block uint64) (common.Hash, error) {
+	code, err := provider.CodeAt(ctx, contract, new(big.Int).SetUint64(block))
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	if len(code) == 0 {
+		return common.Hash{}, fmt.Errorf("no contract at %s on block %d", contract.Hex(), block)
+	}
+
+	return crypto.Keccak256Hash(code), nil
+}
+
+func GetTotals(ctx context.Context, provider StateReader, contract common.Address, skipBlocks uint) (uint, uint, error) {
+	block, err := ConfirmedBlock(ctx, provider, skipBlocks)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	block -= uint64(skipBlocks)
+	return GetTotalsAt(ctx, provider, contract, block)
+}
 
+func GetTotalsAt(ctx context.Context, provider StateReader, contract common.Address, block uint64) (uint, uint, error) {
 	res, err := provider.CallContract(ctx, ethereum.CallMsg{
 		To:   &contract,
 		Data: []byte{byte(METHOD_READ_SIZES)},
-	}, big.NewInt(int64(block)))
+	}, new(big.Int).SetUint64(block))
 
 	if err != nil {
 		return 0, 0, err
 	}
 
+	if len(res) < 32 {
+		return 0, 0, fmt.Errorf("invalid sizes length")
+	}
+
 	// First 16 bytes are the total number of addresses
 	// Next 16 bytes are the total number of bytes32
 
@@ -51,13 +86,34 @@ func GetTotals(ctx context.Context, provider *ethrpc.Provider, contract common.A
 	return asize, bsize, nil
 }
 
-func LoadState(ctx context.Context, provider *ethrpc.Provider, contract common.Address, batchSize uint, skipa uint, skipb uint, skipBlocks uint) (uint, map[string]uint, uint, map[string]uint, error) {
-	ah, addresses, err := LoadAddresses(ctx, provider, contract, batchSize, skipa, skipBlocks)
+// Loads the addresses and bytes32 that have at least skipBlocks confirmations,
+// the sizes and the values are all read on the same block.
+func LoadState(ctx context.Context, provider StateReader, contract common.Address, opts *LoadOptions, skipa uint, skipb uint, skipBlocks uint) (uint, map[string]uint, uint, map[string]uint, error) {
+	block, err := ConfirmedBlock(ctx, provider, skipBlocks)
+	if err != nil {
+		return 0, nil, 0, nil, err
+	}
+
+	return LoadStateAt(ctx, provider, contract, block, opts, skipa, skipb)
+}
+
+func LoadStateAt(ctx context.Context, provider StateReader, contract common.Address, block uint64, opts *LoadOptions, skipa uint, skipb uint) (uint, map[string]uint, uint, map[string]uint, error) {
+	asize, bsize, err := GetTotalsAt(ctx, provider, contract, block)
+	if err != nil {
+		return 0, nil, 0, nil, err
+	}
+
+	ah, addresses, err := LoadStorage(ctx, provider, contract, block, opts, skipa, asize, AddressIndex)
 	if err != nil {
 		return 0, nil, 0, nil, err
 	}
 
-	bh, bytes32, err := LoadBytes32(ctx, provider, contract, batchSize, skipb, skipBlocks)
+	// Always skip index 0 for bytes32, see LoadBytes32
+	if skipb == 0 {
+		skipb = 1
+	}
+
+	bh, bytes32, err := LoadStorage(ctx, provider, contract, block, opts, skipb, bsize, Bytes32Index)
 	if err != nil {
 		return 0, nil, 0, nil, err
 	}
@@ -65,17 +121,22 @@ func LoadState(ctx context.Context, provider *ethrpc.Provider, contract common.A
 	return ah, addresses, bh, bytes32, nil
 }
 
-func LoadAddresses(ctx context.Context, provider *ethrpc.Provider, contract common.Address, batchSize uint, skip uint, skipBlocks uint) (uint, map[string]uint, error) {
+func LoadAddresses(ctx context.Context, provider StateReader, contract common.Address, opts *LoadOptions, skip uint, skipBlocks uint) (uint, map[string]uint, error) {
+	block, err := ConfirmedBlock(ctx, provider, skipBlocks)
+	if err != nil {
+		return 0, nil, err
+	}
+
 	// Load total number of addresses
-	asize, _, err := GetTotals(ctx, provider, contract, skipBlocks)
+	asize, _, err := GetTotalsAt(ctx, provider, contract, block)
 	if err != nil {
 		return 0, nil, err
 	}
 
-	return LoadStorage(ctx, provider, contract, batchSize, skip, asize, AddressIndex)
+	return LoadStorage(ctx, provider, contract, block, opts, skip, asize, AddressIndex)
 }
 
-func LoadBytes32(ctx context.Context, provider *ethrpc.Provider, contract common.Address, batchSize uint, skip uint, skipBlocks uint) (uint, map[string]uint, error) {
+func LoadBytes32(ctx context.Context, provider StateReader, contract common.Address, opts *LoadOptions, skip uint, skipBlocks uint) (uint, map[string]uint, error) {
 	// Always skip index 0 for bytes32, it maps to the size slot
 	// it technically can be used, but it is not write-once, so
 	// it will lead to decompression errors
@@ -83,44 +144,121 @@ func LoadBytes32(ctx context.Context, provider *ethrpc.Provider, contract common
 		skip = 1
 	}
 
+	block, err := ConfirmedBlock(ctx, provider, skipBlocks)
+	if err != nil {
+		return 0, nil, err
+	}
+
 	// Load total number of bytes32
-	_, bsize, err := GetTotals(ctx, provider, contract, skipBlocks)
+	_, bsize, err := GetTotalsAt(ctx, provider, contract, block)
 	if err != nil {
 		return 0, nil, err
 	}
 
-	return LoadStorage(ctx, provider, contract, batchSize, skip, bsize, Bytes32Index)
+	return LoadStorage(ctx, provider, contract, block, opts, skip, bsize, Bytes32Index)
 }
 
-func LoadStorage(ctx context.Context, provider *ethrpc.Provider, contract common.Address, batchSize uint, skip uint, total uint, itemplate func(uint) []byte) (uint, map[string]uint, error) {
-	out := make(map[string]uint)
+// Loads the values of the indexes between skip and total, as they were on the given block
+func LoadStorage(ctx context.Context, provider StateReader, contract common.Address, block uint64, opts *LoadOptions, skip uint, total uint, itemplate func(uint) []byte) (uint, map[string]uint, error) {
+	out, err := newStorageLoader(provider, contract, block, itemplate, opts).load(ctx, skip, total)
+	if err != nil {
+		return 0, nil, err
+	}
 
-	for i := skip; i < total; i += batchSize {
-		batch := GenBatch(i, total, batchSize, itemplate)
+	return total, out, nil
+}
 
-		res, err := provider.CallContract(ctx, ethereum.CallMsg{
-			To:   &contract,
-			Data: append([]byte{byte(METHOD_READ_STORAGE_SLOTS)}, batch...),
-		}, nil)
+// Checks the indexes against the contract state on the given block, and removes the values
+// that no longer match it, as the transactions that wrote them were reorged out. Only the
+// last indexes are read, going back while they don't match. Returns the number of evicted values.
+func VerifyIndexes(ctx context.Context, provider StateReader, contract common.Address, block uint64, opts *LoadOptions, indexes *Indexes) (int, error) {
+	asize, bsize, err := GetTotalsAt(ctx, provider, contract, block)
+	if err != nil {
+		return 0, err
+	}
 
-		if err != nil {
-			return 0, nil, err
+	ea, err := verifyStorage(ctx, provider, contract, block, opts, indexes.AddressIndexes, asize, AddressIndex)
+	if err != nil {
+		return 0, err
+	}
+
+	eb, err := verifyStorage(ctx, provider, contract, block, opts, indexes.Bytes32Indexes, bsize, Bytes32Index)
+	if err != nil {
+		return 0, err
+	}
+
+	return ea + eb, nil
+}
+
+func verifyStorage(ctx context.Context, provider StateReader, contract common.Address, block uint64, opts *LoadOptions, cached map[string]uint, total uint, itemplate func(uint) []byte) (int, error) {
+	evicted := 0
+
+	// Indexes past the total were written after the block
+	values := make(map[uint]string, len(cached))
+	top := uint(0)
+
+	for k, v := range cached {
+		if v >= total {
+			delete(cached, k)
+			evicted++
+			continue
+		}
+
+		values[v] = k
+		if v > top {
+			top = v
+		}
+	}
+
+	if len(values) == 0 {
+		return evicted, nil
+	}
+
+	loader := newStorageLoader(provider, contract, block, itemplate, opts)
+
+	for to := top + 1; to > 0; {
+		from := uint(0)
+		if size := loader.currentBatchSize(); to > size {
+			from = to - size
 		}
 
-		err = ParseBatchResult(out, res, i)
+		res, err := loader.read(ctx, from, to)
 		if err != nil {
-			return 0, nil, err
+			if isLimitError(err) && to-from > 1 {
+				loader.shrink(to - from)
+				continue
+			}
+
+			return 0, err
+		}
+
+		mismatches := 0
+		for i := from; i < to; i++ {
+			value, ok := values[i]
+			if ok && value != string(res[(i-from)*32:(i-from)*32+32]) {
+				delete(cached, value)
+				mismatches++
+			}
 		}
+
+		// The reorg may go further back, only
+		// stop once all the values match
+		evicted += mismatches
+		if mismatches == 0 {
+			break
+		}
+
+		to = from
 	}
 
-	return total, out, nil
+	return evicted, nil
 }
 
 func GenBatch(from uint, to uint, max uint, itemplate func(uint) []byte) []byte {
 	var end uint
 
-	if to < max {
-		end = to
+	if to-from < max {
+		end = to - from
 	} else {
 		end = max
 	}
@@ -0,0 +1,190 @@
+package compressor
+
+// Prices the different parts of a compressed payload, the encoder
+// uses it to pick the encoding with the lowest total fee. All costs
+// must use the same unit, usually the gas of the chain.
+type CostModel interface {
+	// Cost of including the data on the calldata of the transaction
+	CalldataCost(data []byte) uint64
+	// Gas used by the decompressor to execute the flag, without storage access
+	FlagCost(flag uint) uint64
+	// Cost of reading an index from storage
+	SloadCost() uint64
+	// Cost of writing a new index to storage
+	SstoreCost() uint64
+	// Number of times a saved value is expected to be read again,
+	// a storage write is only used if it pays for itself within these reads
+	ExpectedReads() uint64
+}
+
+// A cost model with fixed prices, used by all the presets
+type GasCostModel struct {
+	ZeroByte    uint64
+	NonZeroByte uint64
+
+	// Calldata prices are multiplied by this value, it can be used
+	// to express the L1 data fee of rollups in L2 gas
+	CalldataMultiplier uint64
+
+	Sload  uint64
+	Sstore uint64
+	Reads  uint64
+
+	// Gas per flag, flags not on the map use DefaultFlag
+	Flags       map[uint]uint64
+	DefaultFlag uint64
+}
+
+func (m *GasCostModel) CalldataCost(data []byte) uint64 {
+	var cost uint64
+	for _, b := range data {
+		if b == 0 {
+			cost += m.ZeroByte
+		} else {
+			cost += m.NonZeroByte
+		}
+	}
+
+	if m.CalldataMultiplier != 0 {
+		cost *= m.CalldataMultiplier
+	}
+
+	return cost
+}
+
+func (m *GasCostModel) FlagCost(flag uint) uint64 {
+	if cost, ok := m.Flags[flag]; ok {
+		return cost
+	}
+
+	return m.DefaultFlag
+}
+
+func (m *GasCostModel) SloadCost() uint64 {
+	return m.Sload
+}
+
+func (m *GasCostModel) SstoreCost() uint64 {
+	return m.Sstore
+}
+
+func (m *GasCostModel) ExpectedReads() uint64 {
+	return m.Reads
+}
+
+// Gas used by decompressor.huff on each flag. These are not measured, they are
+// estimated from the opcodes of the macro of each flag (around 3 gas per opcode)
+// plus the dispatch of the flag table, ignoring memory expansion and the nested
+// flags they read. They only need to be right relative to each other and to the
+// calldata prices, the calldata of a payload dominates its cost on every preset.
+// Storage access is priced separately using SLOAD and SSTORE.
+//
+// To measure them run the flag tests with `forge test --gas-report`, a custom
+// GasCostModel can be used with the measured values.
+func flagGasTable() map[uint]uint64 {
+	flags := map[uint]uint64{
+		FLAG_NO_OP:             20,
+		FLAG_READ_N_BYTES:      60,
+		FLAG_WRITE_ZEROS:       40,
+		FLAG_NESTED_N_FLAGS_S:  40,
+		FLAG_NESTED_N_FLAGS_L:  40,
+		FLAG_POW_2:             40,
+		FLAG_POW_2_MINUS_1:     45,
+		FLAG_POW_10:            100,
+		FLAG_POW_10_MANTISSA_S: 130,
+		FLAG_POW_10_MANTISSA_L: 130,
+		FLAG_READ_DYNAMIC_ABI:  120,
+		FLAG_MIRROR_FLAG_S:     50,
+		FLAG_MIRROR_FLAG_L:     50,
+		FLAG_READ_STORE_FLAG_S: 60,
+		FLAG_READ_STORE_FLAG_L: 60,
+		FLAG_COPY_CALLDATA_S:   50,
+		FLAG_COPY_CALLDATA_L:   50,
+		FLAG_COPY_CALLDATA_XL:  60,
+
+		// Saves also update the counter of indexes
+		FLAG_SAVE_ADDRESS: 5000,
+		FLAG_SAVE_BYTES32: 5000,
+	}
+
+	for i := FLAG_ABI_0_PARAM; i <= FLAG_ABI_6_PARAMS; i++ {
+		flags[i] = 60
+	}
+
+	return flags
+}
+
+// Only takes into account the size of the payload, storage is free
+// but it is only used when it saves bytes on the first read.
+func SizeCostModel() CostModel {
+	return &GasCostModel{
+		ZeroByte:    1,
+		NonZeroByte: 1,
+		Reads:       1,
+	}
+}
+
+// Ethereum mainnet (and any chain with the same pricing), calldata
+// is 4 gas per zero byte and 16 per non-zero byte, storage writes
+// of new slots cost 22100 (20000 + cold access).
+func L1CostModel() CostModel {
+	return &GasCostModel{
+		ZeroByte:    4,
+		NonZeroByte: 16,
+		Sload:       2100,
+		Sstore:      22100,
+		Reads:       10,
+		Flags:       flagGasTable(),
+		DefaultFlag: 30,
+	}
+}
+
+// Arbitrum charges the calldata as L1 data, priced in L2 gas. We assume
+// the L1 gas price is around 100 times the L2 gas price, so calldata
+// dominates the fee and storage writes pay for themselves quickly.
+func ArbitrumCostModel() CostModel {
+	return &GasCostModel{
+		ZeroByte:           4,
+		NonZeroByte:        16,
+		CalldataMultiplier: 100,
+		Sload:              2100,
+		Sstore:             22100,
+		Reads:              10,
+		Flags:              flagGasTable(),
+		DefaultFlag:        30,
+	}
+}
+
+// OP-stack chains charge the calldata as L1 data too. The model prices it
+// like L1 calldata (4 and 16 gas per byte) with a smaller multiplier than
+// Arbitrum, we assume the L1 data fee is around 10 times the L2 gas price.
+// Chains that post their data on blobs charge less per byte, but the fee
+// still grows with the bytes of the payload, so only the multiplier changes.
+func OptimismCostModel() CostModel {
+	return &GasCostModel{
+		ZeroByte:           4,
+		NonZeroByte:        16,
+		CalldataMultiplier: 10,
+		Sload:              2100,
+		Sstore:             22100,
+		Reads:              10,
+		Flags:              flagGasTable(),
+		DefaultFlag:        30,
+	}
+}
+
+// Returns the cost model for the given name, used by the CLI
+func CostModelByName(name string) (CostModel, bool) {
+	switch name {
+	case "size":
+		return SizeCostModel(), true
+	case "l1":
+		return L1CostModel(), true
+	case "arbitrum":
+		return ArbitrumCostModel(), true
+	case "op", "optimism":
+		return OptimismCostModel(), true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,1093 @@
+package decompressor
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+	"github.com/0xsequence/go-sequence"
+)
+
+// The EVM stack is limited to 1024 items, and every nested flag uses
+// a few of them, so the contract can never nest deeper than this.
+const maxNestingDepth = 1024
+
+// Sizes read from the payload are bounded, otherwise a malformed payload
+// could make us allocate huge amounts of memory. The contract would
+// run out of gas long before reaching this size.
+const maxReadSize = 1 << 24
+
+// Mirror flags can point to flags that contain more mirrors, so a small payload
+// could expand exponentially. The contract is bounded by gas, here the bytes
+// written and the flags read are bounded instead.
+const (
+	maxOutputSize = 1 << 26
+	maxFlagsRead  = 1 << 22
+)
+
+type Call struct {
+	To   common.Address
+	Data []byte
+}
+
+type SequenceTx struct {
+	Wallet      common.Address
+	Execdata    []byte
+	Transaction *sequence.Transaction
+}
+
+type StorageWrite struct {
+	Flag  uint
+	Index uint
+	Value []byte
+}
+
+type Result struct {
+	Method uint
+
+	// Data is what the decompressor contract returns, it is only
+	// populated for the METHOD_DECODE_* methods.
+	Data []byte
+
+	Calls       []*Call
+	SequenceTxs []*SequenceTx
+
+	Writes []*StorageWrite
+}
+
+type Decompressor struct {
+	data []byte
+	mem  []byte
+
+	bytes4 []byte
+
+	addresses    map[uint][]byte
+	bytes32      map[uint][]byte
+	addressesNum uint
+	bytes32Num   uint
+
+	writes []*StorageWrite
+	depth  int
+
+	// Bytes written and flags read so far, see maxOutputSize
+	written uint
+	read    uint
+
+	// Only used by Disassemble, see disasm.go
+	tracing bool
+	stack   []*Instruction
+	roots   []*Instruction
+}
+
+// Addresses and bytes32 are the totals of the contract as returned by compressor.GetTotals,
+// the number of values saved plus one. Values saved by the payload are stored after them,
+// so they must be read on the same block as the indexes.
+func NewDecompressor(payload []byte, indexes *compressor.Indexes, addresses uint, bytes32 uint) *Decompressor {
+	d := &Decompressor{
+		data:      payload,
+		mem:       make([]byte, 0),
+		bytes4:    common.Hex2Bytes(compressor.BYTES4_TABLE),
+		addresses: make(map[uint][]byte),
+		bytes32:   make(map[uint][]byte),
+	}
+
+	if addresses > 0 {
+		d.addressesNum = addresses - 1
+	}
+
+	if bytes32 > 0 {
+		d.bytes32Num = bytes32 - 1
+	}
+
+	// The indexes map values to indexes, but here we need the opposite
+	if indexes != nil {
+		for k, v := range indexes.AddressIndexes {
+			d.addresses[v] = []byte(k)
+		}
+
+		// Custom selector tables are passed as indexes too
+		if len(indexes.Bytes4Indexes) != 0 {
+			d.bytes4 = compressor.Bytes4TableFromIndexes(indexes.Bytes4Indexes)
+		}
+
+		for k, v := range indexes.Bytes32Indexes {
+			d.bytes32[v] = []byte(k)
+		}
+	}
+
+	return d
+}
+
+// Decompresses a payload the same way the decompressor contract would,
+// storage reads are resolved using the provided indexes. See NewDecompressor for the totals.
+func Decompress(payload []byte, indexes *compressor.Indexes, addresses uint, bytes32 uint) (*Result, error) {
+	return NewDecompressor(payload, indexes, addresses, bytes32).Decompress()
+}
+
+func (d *Decompressor) Decompress() (*Result, error) {
+	if len(d.data) == 0 {
+		return nil, fmt.Errorf("payload is empty")
+	}
+
+	method := uint(d.data[0])
+	res := &Result{Method: method}
+
+	var args string
+	switch method {
+	case compressor.METHOD_EXECUTE_SEQUENCE_N_TXS, compressor.METHOD_DECODE_SEQUENCE_N_TXS, compressor.METHOD_EXECUTE_N_CALLS, compressor.METHOD_DECODE_N_CALLS:
+		args = fmt.Sprintf("n=%d", d.loadUint(1, 1))
+	}
+
+	ins := d.enter(0, methodName(method), args)
+
+	// The first byte is the method, everything else are flags
+	rindex := uint(1)
+
+	var err error
+
+	switch method {
+	case compressor.METHOD_EXECUTE_SEQUENCE_TX:
+		_, err = d.performExecute(res, rindex)
+
+	case compressor.METHOD_EXECUTE_SEQUENCE_N_TXS:
+		_, err = d.repeat(rindex, func(rindex uint) (uint, error) {
+			return d.performExecute(res, rindex)
+		})
+
+	case compressor.METHOD_DECODE_SEQUENCE_TX:
+		var windex uint
+		windex, _, err = d.decodeExecute(res, 0, rindex)
+		res.Data = d.mem[:windex]
+
+	case compressor.METHOD_DECODE_SEQUENCE_N_TXS:
+		windex := uint(0)
+		_, err = d.repeat(rindex, func(rindex uint) (uint, error) {
+			var err error
+			windex, rindex, err = d.decodeExecute(res, windex, rindex)
+			return rindex, err
+		})
+		res.Data = d.mem[:windex]
+
+	case compressor.METHOD_EXECUTE_CALL, compressor.METHOD_EXECUTE_CALL_RETURN:
+		_, err = d.performCall(res, rindex)
+
+	case compressor.METHOD_EXECUTE_N_CALLS:
+		_, err = d.repeat(rindex, func(rindex uint) (uint, error) {
+			return d.performCall(res, rindex)
+		})
+
+	case compressor.METHOD_DECODE_CALL:
+		var windex uint
+		windex, _, err = d.decodeCall(res, 0, rindex)
+		res.Data = d.mem[:windex]
+
+	case compressor.METHOD_DECODE_N_CALLS:
+		windex := uint(0)
+		_, err = d.repeat(rindex, func(rindex uint) (uint, error) {
+			var err error
+			windex, rindex, err = d.decodeCall(res, windex, rindex)
+			return rindex, err
+		})
+		res.Data = d.mem[:windex]
+
+	case compressor.METHOD_DECODE_ANY:
+		var windex uint
+		windex, _, err = d.readFlag(0, rindex)
+		res.Data = d.mem[:windex]
+
+	default:
+		return nil, fmt.Errorf("method %d does not carry a compressed payload", method)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ins != nil {
+		ins.Output = res.Data
+	}
+
+	res.Writes = d.writes
+	return res, nil
+}
+
+// Reads the number of iterations (1 byte) and calls fn that many times. Like on the contract
+// the loop is a do-while, so fn is always called at least once.
+func (d *Decompressor) repeat(rindex uint, fn func(rindex uint) (uint, error)) (uint, error) {
+	size := d.loadUint(rindex, 1)
+	rindex += 1
+
+	var err error
+	for i := uint(0); i == 0 || i < size; i++ {
+		rindex, err = fn(rindex)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return rindex, nil
+}
+
+func (d *Decompressor) performExecute(res *Result, rindex uint) (uint, error) {
+	ins := d.enter(rindex, "SEQUENCE_TX", "")
+
+	windex, rindex, err := d.readExecute(0, rindex)
+	if err != nil {
+		return 0, err
+	}
+
+	execdata := d.copyMem(0, windex)
+
+	windex, rindex, err = d.readFlag(windex, rindex)
+	if err != nil {
+		return 0, err
+	}
+
+	d.leave(ins, 0, windex)
+
+	word, _, err := d.backread(windex)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := parseSequenceTx(common.BytesToAddress(word), execdata)
+	if err != nil {
+		return 0, err
+	}
+
+	res.SequenceTxs = append(res.SequenceTxs, tx)
+	return rindex, nil
+}
+
+func (d *Decompressor) decodeExecute(res *Result, windex uint, rindex uint) (uint, uint, error) {
+	ins := d.enter(rindex, "SEQUENCE_TX", "")
+	start := windex
+
+	windex, rindex, err := d.readExecute(windex, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end := windex
+
+	windex, rindex, err = d.readFlag(windex, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	d.leave(ins, start, windex)
+
+	tx, err := parseSequenceTx(common.BytesToAddress(d.mem[end:windex]), d.copyMem(start, end))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	res.SequenceTxs = append(res.SequenceTxs, tx)
+	return windex, rindex, nil
+}
+
+func (d *Decompressor) performCall(res *Result, rindex uint) (uint, error) {
+	ins := d.enter(rindex, "CALL", "")
+
+	windex, rindex, err := d.readFlag(0, rindex)
+	if err != nil {
+		return 0, err
+	}
+
+	data := d.copyMem(0, windex)
+
+	windex, rindex, err = d.readFlag(windex, rindex)
+	if err != nil {
+		return 0, err
+	}
+
+	d.leave(ins, 0, windex)
+
+	word, _, err := d.backread(windex)
+	if err != nil {
+		return 0, err
+	}
+
+	res.Calls = append(res.Calls, &Call{To: common.BytesToAddress(word), Data: data})
+	return rindex, nil
+}
+
+func (d *Decompressor) decodeCall(res *Result, windex uint, rindex uint) (uint, uint, error) {
+	ins := d.enter(rindex, "CALL", "")
+	start := windex
+
+	windex, rindex, err := d.readFlag(windex, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end := windex
+
+	windex, rindex, err = d.readFlag(windex, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	d.leave(ins, start, windex)
+
+	res.Calls = append(res.Calls, &Call{
+		To:   common.BytesToAddress(d.mem[end:windex]),
+		Data: d.copyMem(start, end),
+	})
+
+	return windex, rindex, nil
+}
+
+func parseSequenceTx(wallet common.Address, execdata []byte) (*SequenceTx, error) {
+	txs, nonce, sig, err := sequence.DecodeExecdata(execdata)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sequence execdata: %w", err)
+	}
+
+	return &SequenceTx{
+		Wallet:   wallet,
+		Execdata: execdata,
+		Transaction: &sequence.Transaction{
+			Nonce:        nonce,
+			Transactions: txs,
+			Signature:    sig,
+		},
+	}, nil
+}
+
+// Reads a single flag from rindex and writes its expanded value to windex,
+// it returns the next windex and rindex.
+func (d *Decompressor) readFlag(windex uint, rindex uint) (uint, uint, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+
+	if d.depth > maxNestingDepth {
+		return 0, 0, fmt.Errorf("max nesting depth exceeded at %d", rindex)
+	}
+
+	d.read++
+	if d.read > maxFlagsRead {
+		return 0, 0, fmt.Errorf("max number of flags read exceeded at %d", rindex)
+	}
+
+	if d.written > maxOutputSize {
+		return 0, 0, fmt.Errorf("max output size exceeded at %d", rindex)
+	}
+
+	flag := d.loadUint(rindex, 1)
+
+	ins := d.enter(rindex, flagName(flag), d.describe(flag, rindex+1))
+	nwindex, nrindex, err := d.execFlag(flag, windex, rindex+1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	d.leave(ins, windex, nwindex)
+	return nwindex, nrindex, nil
+}
+
+func (d *Decompressor) execFlag(flag uint, windex uint, rindex uint) (uint, uint, error) {
+	switch {
+	case flag == compressor.FLAG_NO_OP:
+		return windex, rindex, nil
+
+	case flag >= compressor.FLAG_READ_WORD_1 && flag <= compressor.FLAG_READ_WORD_32:
+		n := flag - compressor.FLAG_READ_WORD_1 + 1
+		d.mstore(windex, d.load(rindex, n))
+		return windex + 32, rindex + n, nil
+
+	case flag == compressor.FLAG_READ_WORD_INV:
+		// The instruction defines how many bytes we are going to read,
+		// they are written to the left of the word
+		n := d.loadUint(rindex, 1)
+		rindex += 1
+		d.mstore(windex, nil)
+		d.mwrite(windex, d.load(rindex, n))
+		return windex + 32, rindex + n, nil
+
+	case flag == compressor.FLAG_READ_N_BYTES:
+		return d.readNBytes(windex, rindex)
+
+	case flag == compressor.FLAG_WRITE_ZEROS:
+		size := d.loadUint(rindex, 1)
+		d.mwrite(windex, make([]byte, size))
+		return windex + size, rindex + 1, nil
+
+	case flag == compressor.FLAG_NESTED_N_FLAGS_S:
+		return d.readNestedFlags(windex, rindex+1, d.loadUint(rindex, 1))
+
+	case flag == compressor.FLAG_NESTED_N_FLAGS_L:
+		return d.readNestedFlags(windex, rindex+2, d.loadUint(rindex, 2))
+
+	case flag == compressor.FLAG_SAVE_ADDRESS:
+		word := padLeft32(d.load(rindex, 20))
+		d.mstore(windex, word)
+		d.addressesNum++
+		d.addresses[d.addressesNum] = word
+		d.writes = append(d.writes, &StorageWrite{Flag: flag, Index: d.addressesNum, Value: word})
+		return windex + 32, rindex + 20, nil
+
+	case flag >= compressor.FLAG_READ_ADDRESS_2 && flag <= compressor.FLAG_READ_ADDRESS_4:
+		n := flag - compressor.FLAG_READ_ADDRESS_2 + 2
+		index := d.loadUint(rindex, n)
+		word, ok := d.addresses[index]
+		if !ok {
+			return 0, 0, fmt.Errorf("unknown address index %d at %d", index, rindex)
+		}
+		d.mstore(windex, word)
+		return windex + 32, rindex + n, nil
+
+	case flag == compressor.FLAG_SAVE_BYTES32:
+		word := d.load(rindex, 32)
+		d.mstore(windex, word)
+		d.bytes32Num++
+		d.bytes32[d.bytes32Num] = word
+		d.writes = append(d.writes, &StorageWrite{Flag: flag, Index: d.bytes32Num, Value: word})
+		return windex + 32, rindex + 32, nil
+
+	case flag >= compressor.FLAG_READ_BYTES32_2 && flag <= compressor.FLAG_READ_BYTES32_4:
+		n := flag - compressor.FLAG_READ_BYTES32_2 + 2
+		index := d.loadUint(rindex, n)
+		word, ok := d.bytes32[index]
+		if !ok {
+			return 0, 0, fmt.Errorf("unknown bytes32 index %d at %d", index, rindex)
+		}
+		d.mstore(windex, word)
+		return windex + 32, rindex + n, nil
+
+	case flag == compressor.FLAG_READ_STORE_FLAG_S, flag == compressor.FLAG_READ_STORE_FLAG_L:
+		n := flag - compressor.FLAG_READ_STORE_FLAG_S + 2
+		trindex := d.loadUint(rindex, n)
+
+		// The pointer leads to a save flag, the value is right after it
+		// and it is either an address or a bytes32
+		if d.loadUint(trindex, 1) == compressor.FLAG_SAVE_ADDRESS {
+			d.mstore(windex, d.load(trindex+1, 20))
+		} else {
+			d.mstore(windex, d.load(trindex+1, 32))
+		}
+		return windex + 32, rindex + n, nil
+
+	case flag == compressor.FLAG_POW_2:
+		exp := d.loadUint(rindex, 1)
+		d.mstore(windex, toWord(new(big.Int).Lsh(big.NewInt(1), exp)))
+		return windex + 32, rindex + 1, nil
+
+	case flag == compressor.FLAG_POW_2_MINUS_1:
+		// The exponent always has an extra 1, or else 2 ** 256 - 1 can't be represented
+		exp := d.loadUint(rindex, 1) + 1
+		val := new(big.Int).Lsh(big.NewInt(1), exp)
+		d.mstore(windex, toWord(val.Sub(val, big.NewInt(1))))
+		return windex + 32, rindex + 1, nil
+
+	case flag == compressor.FLAG_POW_10:
+		exp := d.loadUint(rindex, 1)
+		val, err := pow10(exp)
+		if err != nil {
+			return 0, 0, err
+		}
+		d.mstore(windex, toWord(val))
+		return windex + 32, rindex + 1, nil
+
+	case flag == compressor.FLAG_POW_10_MANTISSA_S:
+		// 5 bits for the exponent and 11 bits for the mantissa
+		packed := d.loadUint(rindex, 2)
+		val, err := pow10(packed >> 11)
+		if err != nil {
+			return 0, 0, err
+		}
+		d.mstore(windex, toWord(val.Mul(val, new(big.Int).SetUint64(uint64(packed&0x7ff)))))
+		return windex + 32, rindex + 2, nil
+
+	case flag == compressor.FLAG_POW_10_MANTISSA_L:
+		// 6 bits for the exponent and 18 bits for the mantissa
+		packed := d.loadUint(rindex, 3)
+		val, err := pow10(packed >> 18)
+		if err != nil {
+			return 0, 0, err
+		}
+		d.mstore(windex, toWord(val.Mul(val, new(big.Int).SetUint64(uint64(packed&0x3ffff)))))
+		return windex + 32, rindex + 3, nil
+
+	case flag >= compressor.FLAG_ABI_0_PARAM && flag <= compressor.FLAG_ABI_6_PARAMS:
+		windex, rindex = d.readABI4Bytes(windex, rindex)
+
+		var err error
+		for i := uint(0); i < flag-compressor.FLAG_ABI_0_PARAM; i++ {
+			windex, rindex, err = d.readFlag(windex, rindex)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		return windex, rindex, nil
+
+	case flag == compressor.FLAG_READ_DYNAMIC_ABI:
+		return d.readDynamicABI(windex, rindex)
+
+	case flag == compressor.FLAG_MIRROR_FLAG_S, flag == compressor.FLAG_MIRROR_FLAG_L:
+		// Mirror flags re-read another flag, but the rindex
+		// continues right after the pointer
+		n := flag - compressor.FLAG_MIRROR_FLAG_S + 2
+		windex, _, err := d.readFlag(windex, d.loadUint(rindex, n))
+		if err != nil {
+			return 0, 0, err
+		}
+		return windex, rindex + n, nil
+
+	case flag >= compressor.FLAG_COPY_CALLDATA_S && flag <= compressor.FLAG_COPY_CALLDATA_XL:
+		var location, size uint
+		switch flag {
+		case compressor.FLAG_COPY_CALLDATA_S:
+			location, size = d.loadUint(rindex, 2), d.loadUint(rindex+2, 1)
+			rindex += 3
+		case compressor.FLAG_COPY_CALLDATA_L:
+			location, size = d.loadUint(rindex, 3), d.loadUint(rindex+3, 1)
+			rindex += 4
+		default:
+			location, size = d.loadUint(rindex, 3), d.loadUint(rindex+3, 2)
+			rindex += 5
+		}
+		d.mwrite(windex, d.load(location, size))
+		return windex + size, rindex, nil
+
+	case flag == compressor.FLAG_SEQUENCE_EXECUTE:
+		return d.readExecute(windex, rindex)
+
+	case flag == compressor.FLAG_SEQUENCE_SELF_EXECUTE:
+		// The SELF execution function signature of Sequence is 0x61c2926c
+		d.mstoreRight(windex, common.Hex2Bytes("61c2926c"))
+		windex += 4
+
+		// The list of transactions always starts at 0x20
+		d.mstore(windex, []byte{0x20})
+		return d.readTransactions(windex+32, rindex)
+
+	case flag == compressor.FLAG_SEQUENCE_SIGNATURE_W0:
+		return d.readSignature(windex, rindex+1, d.loadUint(rindex, 1))
+
+	case flag > compressor.FLAG_SEQUENCE_SIGNATURE_W0 && flag <= compressor.FLAG_SEQUENCE_SIGNATURE_W4:
+		return d.readSignature(windex, rindex, flag-compressor.FLAG_SEQUENCE_SIGNATURE_W0)
+
+	case flag == compressor.FLAG_SEQUENCE_ADDRESS_W0:
+		return d.readSignatureAddress(windex, rindex+1, d.loadUint(rindex, 1))
+
+	case flag > compressor.FLAG_SEQUENCE_ADDRESS_W0 && flag <= compressor.FLAG_SEQUENCE_ADDRESS_W4:
+		return d.readSignatureAddress(windex, rindex, flag-compressor.FLAG_SEQUENCE_ADDRESS_W0)
+
+	case flag == compressor.FLAG_SEQUENCE_NODE:
+		d.mstore8(windex, 0x03)
+		return d.readFlag(windex+1, rindex)
+
+	case flag == compressor.FLAG_SEQUENCE_BRANCH:
+		d.mstore8(windex, 0x04)
+		return d.readSized(windex+1, rindex, 0)
+
+	case flag == compressor.FLAG_SEQUENCE_SUBDIGEST:
+		d.mstore8(windex, 0x05)
+		return d.readFlag(windex+1, rindex)
+
+	case flag == compressor.FLAG_SEQUENCE_NESTED:
+		// The threshold is only provided using 1 byte
+		// but it is always written using 2 bytes
+		d.mstore8(windex, 0x06)
+		d.mstore8(windex+1, byte(d.loadUint(rindex, 1)))
+		d.mstoreRight(windex+2, []byte{0x00, byte(d.loadUint(rindex+1, 1))})
+		return d.readSized(windex+4, rindex+2, 0)
+
+	case flag == compressor.FLAG_SEQUENCE_DYNAMIC_SIGNATURE:
+		return d.readDynamicSignature(windex, rindex)
+
+	case flag >= compressor.FLAG_SEQUENCE_SIG_NO_CHAIN && flag <= compressor.FLAG_SEQUENCE_L_SIG:
+		// NO_CHAIN signatures use the 0x02 type, the others use 0x01
+		sigType := byte(0x01)
+		if flag == compressor.FLAG_SEQUENCE_SIG_NO_CHAIN || flag == compressor.FLAG_SEQUENCE_L_SIG_NO_CHAIN {
+			sigType = 0x02
+		}
+
+		thresholdSize := uint(1)
+		if flag == compressor.FLAG_SEQUENCE_L_SIG_NO_CHAIN || flag == compressor.FLAG_SEQUENCE_L_SIG {
+			thresholdSize = 2
+		}
+
+		return d.readSequenceSignature(windex, rindex, sigType, thresholdSize)
+
+	case flag == compressor.FLAG_SEQUENCE_READ_CHAINED_S, flag == compressor.FLAG_SEQUENCE_READ_CHAINED_L:
+		n := flag - compressor.FLAG_SEQUENCE_READ_CHAINED_S + 1
+		d.mstore8(windex, 0x03)
+		return d.readChained(windex+1, rindex+n, d.loadUint(rindex, n))
+
+	default:
+		// Anything above the highest flag is a literal
+		d.mstore(windex, []byte{byte(flag - compressor.LITERAL_ZERO)})
+		return windex + 32, rindex, nil
+	}
+}
+
+func (d *Decompressor) readNBytes(windex uint, rindex uint) (uint, uint, error) {
+	// The size is another flag, the bytes are written on top of it
+	windex, rindex, err := d.readFlag(windex, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	word, windex, err := d.backread(windex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size, err := wordToSize(word)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	d.mwrite(windex, d.load(rindex, size))
+	return windex + size, rindex + size, nil
+}
+
+func (d *Decompressor) readNestedFlags(windex uint, rindex uint, n uint) (uint, uint, error) {
+	var err error
+
+	// Notice that at least one flag is always read, even if n is 0
+	for i := uint(0); i == 0 || i < n; i++ {
+		windex, rindex, err = d.readFlag(windex, rindex)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return windex, rindex, nil
+}
+
+func (d *Decompressor) readABI4Bytes(windex uint, rindex uint) (uint, uint) {
+	// Index 0 means that the 4 bytes are provided as-is
+	// any other index points to the common 4 bytes table
+	index := d.loadUint(rindex, 1)
+	rindex += 1
+
+	if index == 0 {
+		d.mwrite(windex, d.load(rindex, 4))
+		rindex += 4
+	} else {
+		d.mwrite(windex, d.bytes4[index*4:index*4+4])
+	}
+
+	return windex + 4, rindex
+}
+
+func (d *Decompressor) readDynamicABI(windex uint, rindex uint) (uint, uint, error) {
+	windex, rindex = d.readABI4Bytes(windex, rindex)
+
+	size := d.loadUint(rindex, 1)
+	bitmap := d.loadUint(rindex+1, 1)
+	rindex += 2
+
+	// Static values (and pointers) are written on windex, while the
+	// dynamic values are written after all of them, on bwindex
+	swindex := windex
+	bwindex := windex + size*32
+
+	var err error
+
+	for i := uint(0); i == 0 || i < size; i++ {
+		if i >= 8 || bitmap&(1<<i) == 0 {
+			windex, rindex, err = d.readFlag(windex, rindex)
+			if err != nil {
+				return 0, 0, err
+			}
+			continue
+		}
+
+		d.mstore(windex, uintToWord(bwindex-swindex))
+		windex += 32
+
+		// The size of the dynamic value goes first, we only know it
+		// after reading the value itself
+		sizePointer := bwindex
+		bwindex, rindex, err = d.readFlag(bwindex+32, rindex)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		bsize := bwindex - sizePointer - 32
+		d.mstore(sizePointer, uintToWord(bsize))
+
+		// Pad the value to 32 bytes
+		d.mstore(bwindex, nil)
+		bwindex += padding(bsize)
+	}
+
+	return bwindex, rindex, nil
+}
+
+func (d *Decompressor) readExecute(windex uint, rindex uint) (uint, uint, error) {
+	// The execution function signature of Sequence is 0x7a9a1628
+	d.mstoreRight(windex, common.Hex2Bytes("7a9a1628"))
+	windex += 4
+
+	// The list of transactions is always the first dynamic value
+	// so it always starts at 0x60
+	d.mstore(windex, []byte{0x60})
+	windex += 32
+
+	// The nonce is read as two values, the space and the nonce itself
+	windex, rindex, err := d.readFlag(windex, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	space, windex, err := d.backread(windex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	windex, rindex, err = d.readFlag(windex, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	nonce, windex, err := d.backread(windex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	val := new(big.Int).Lsh(new(big.Int).SetBytes(space), 96)
+	d.mstore(windex, toWord(val.Or(val, new(big.Int).SetBytes(nonce))))
+	windex += 32
+
+	// We only know where the signature starts after reading the transactions
+	sigPointer := windex
+	windex, rindex, err = d.readTransactions(windex+32, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	d.mstore(sigPointer, uintToWord(windex-sigPointer+0x40))
+
+	// The signature is just another flag, prefixed by its size and padded
+	start := windex + 32
+	windex, rindex, err = d.readFlag(start, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size := windex - start
+	d.mstore(start-32, uintToWord(size))
+	d.mstore(windex, nil)
+
+	return windex + padding(size), rindex, nil
+}
+
+func (d *Decompressor) readTransactions(windex uint, rindex uint) (uint, uint, error) {
+	txNum := d.loadUint(rindex, 1)
+	rindex += 1
+
+	// The contract only stops when the counter matches the number of transactions
+	// with 0 transactions it would never stop
+	if txNum == 0 {
+		return 0, 0, fmt.Errorf("transactions are empty at %d", rindex-1)
+	}
+
+	d.mstore(windex, uintToWord(txNum))
+	windex += 32
+
+	// Reserve one pointer per transaction, they are relative
+	// to the start of the list of pointers
+	tsIndex := windex
+	pos := txNum * 32
+	windex += pos
+
+	var err error
+
+	for i := uint(0); i < txNum; i++ {
+		d.mstore(tsIndex, uintToWord(pos))
+		tsIndex += 32
+
+		prev := windex
+		ins := d.enter(rindex, "TRANSACTION", describeTransactionFlag(d.loadUint(rindex, 1)))
+		windex, rindex, err = d.readTransaction(windex, rindex)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		d.leave(ins, prev, windex)
+
+		pos += windex - prev
+	}
+
+	return windex, rindex, nil
+}
+
+func (d *Decompressor) readTransaction(windex uint, rindex uint) (uint, uint, error) {
+	// The first byte is the flag of the transaction, see WriteSequenceTransaction
+	tflag := d.loadUint(rindex, 1)
+	rindex += 1
+
+	d.mstore(windex, uintToWord(tflag>>7))
+	d.mstore(windex+32, uintToWord((tflag>>6)&1))
+	windex += 64
+
+	var err error
+
+	if (tflag>>5)&1 == 1 {
+		windex, rindex, err = d.readFlag(windex, rindex)
+		if err != nil {
+			return 0, 0, err
+		}
+	} else {
+		d.mstore(windex, nil)
+		windex += 32
+	}
+
+	// All transactions must define an address
+	windex, rindex, err = d.readFlag(windex, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if (tflag>>4)&1 == 1 {
+		windex, rindex, err = d.readFlag(windex, rindex)
+		if err != nil {
+			return 0, 0, err
+		}
+	} else {
+		d.mstore(windex, nil)
+		windex += 32
+	}
+
+	// All transactions have the same number of parameters
+	// so the data always starts at 0xc0
+	d.mstore(windex, []byte{0xc0})
+	windex += 32
+
+	if tflag&1 == 0 {
+		d.mstore(windex, nil)
+		return windex + 32, rindex, nil
+	}
+
+	start := windex + 32
+	windex, rindex, err = d.readFlag(start, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size := windex - start
+	d.mstore(start-32, uintToWord(size))
+	d.mstore(windex, nil)
+
+	return windex + padding(size), rindex, nil
+}
+
+func (d *Decompressor) readSignature(windex uint, rindex uint, weight uint) (uint, uint, error) {
+	// EOA signatures are always 66 bytes long
+	d.mstore8(windex, 0x00)
+	d.mstore8(windex+1, byte(weight))
+	d.mwrite(windex+2, d.load(rindex, 66))
+	return windex + 68, rindex + 66, nil
+}
+
+func (d *Decompressor) readSignatureAddress(windex uint, rindex uint, weight uint) (uint, uint, error) {
+	d.mstore8(windex, 0x01)
+	d.mstore8(windex+1, byte(weight))
+	return d.readAddress(windex+2, rindex)
+}
+
+// Reads a flag and writes it back as a 20 bytes address,
+// as the address may come from storage or a pointer
+func (d *Decompressor) readAddress(windex uint, rindex uint) (uint, uint, error) {
+	windex, rindex, err := d.readFlag(windex, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	word, windex, err := d.backread(windex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	d.mstoreRight(windex, word[12:])
+	return windex + 20, rindex, nil
+}
+
+func (d *Decompressor) readDynamicSignature(windex uint, rindex uint) (uint, uint, error) {
+	d.mstore8(windex, 0x02)
+	d.mstore8(windex+1, byte(d.loadUint(rindex, 1)))
+
+	windex, rindex, err := d.readAddress(windex+2, rindex+1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The size includes the suffix 0x03 used for EIP1271
+	windex, rindex, err = d.readSized(windex, rindex, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	d.mstore8(windex, 0x03)
+	return windex + 1, rindex, nil
+}
+
+func (d *Decompressor) readSequenceSignature(windex uint, rindex uint, sigType byte, thresholdSize uint) (uint, uint, error) {
+	d.mstore8(windex, sigType)
+
+	// The threshold may be provided using 8 or 16 bits
+	// but it is always written using 16 bits
+	threshold := d.loadUint(rindex, thresholdSize)
+	d.mstoreRight(windex+1, []byte{byte(threshold >> 8), byte(threshold)})
+	windex += 3
+	rindex += thresholdSize
+
+	// The checkpoint always uses 4 bytes
+	windex, rindex, err := d.readFlag(windex, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	word, windex, err := d.backread(windex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	d.mstoreRight(windex, word[28:])
+	windex += 4
+
+	// The rest is the signature tree
+	return d.readFlag(windex, rindex)
+}
+
+func (d *Decompressor) readChained(windex uint, rindex uint, n uint) (uint, uint, error) {
+	var err error
+
+	for i := uint(0); i == 0 || i < n; i++ {
+		windex, rindex, err = d.readSized(windex, rindex, 0)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return windex, rindex, nil
+}
+
+// Reads a flag prefixing it with its size using 3 bytes,
+// extra is added to the size for values that get a suffix.
+func (d *Decompressor) readSized(windex uint, rindex uint, extra uint) (uint, uint, error) {
+	d.mstore(windex, nil)
+
+	start := windex + 3
+	windex, rindex, err := d.readFlag(start, rindex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size := windex - start + extra
+	d.mwrite(start-3, []byte{byte(size >> 16), byte(size >> 8), byte(size)})
+
+	return windex, rindex, nil
+}
+
+// Reads n bytes of calldata, anything out of bounds is read as 0s
+func (d *Decompressor) load(rindex uint, n uint) []byte {
+	res := make([]byte, n)
+	if rindex < uint(len(d.data)) {
+		copy(res, d.data[rindex:])
+	}
+	return res
+}
+
+func (d *Decompressor) loadUint(rindex uint, n uint) uint {
+	var val uint
+	for _, b := range d.load(rindex, n) {
+		val = val<<8 | uint(b)
+	}
+	return val
+}
+
+func (d *Decompressor) grow(size uint) {
+	if size > uint(len(d.mem)) {
+		d.mem = append(d.mem, make([]byte, size-uint(len(d.mem)))...)
+	}
+}
+
+// Writes a 32 bytes word, the value is left padded
+func (d *Decompressor) mstore(windex uint, val []byte) {
+	d.mwrite(windex, padLeft32(val))
+}
+
+// Writes a 32 bytes word, but the value is right padded
+// this mimics mstore of a value shifted to the left
+func (d *Decompressor) mstoreRight(windex uint, val []byte) {
+	d.mwrite(windex, padRight32(val))
+}
+
+func (d *Decompressor) mstore8(windex uint, b byte) {
+	d.mwrite(windex, []byte{b})
+}
+
+func (d *Decompressor) mwrite(windex uint, val []byte) {
+	d.written += uint(len(val))
+	d.grow(windex + uint(len(val)))
+	copy(d.mem[windex:], val)
+}
+
+func (d *Decompressor) copyMem(from uint, to uint) []byte {
+	res := make([]byte, to-from)
+	copy(res, d.mem[from:to])
+	return res
+}
+
+// Reads the last written word, and returns the windex pointing to it
+func (d *Decompressor) backread(windex uint) ([]byte, uint, error) {
+	if windex < 32 {
+		return nil, 0, fmt.Errorf("expected a word, but only %d bytes were written", windex)
+	}
+
+	return d.copyMem(windex-32, windex), windex - 32, nil
+}
+
+func pow10(exp uint) (*big.Int, error) {
+	// The contract only has a table up to 10 ** 77
+	if exp > 77 {
+		return nil, fmt.Errorf("pow10 exponent %d out of range", exp)
+	}
+
+	return new(big.Int).Exp(big.NewInt(10), new(big.Int).SetUint64(uint64(exp)), nil), nil
+}
+
+func wordToSize(word []byte) (uint, error) {
+	size := new(big.Int).SetBytes(word)
+	if size.Cmp(big.NewInt(maxReadSize)) > 0 {
+		return 0, fmt.Errorf("size %s exceeds max size", size.String())
+	}
+
+	return uint(size.Uint64()), nil
+}
+
+func padding(size uint) uint {
+	return (32 - size%32) % 32
+}
+
+func toWord(val *big.Int) []byte {
+	// Values wrap around 2 ** 256, like on the EVM
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	return val.And(val, mask).FillBytes(make([]byte, 32))
+}
+
+func uintToWord(val uint) []byte {
+	return toWord(new(big.Int).SetUint64(uint64(val)))
+}
+
+func padLeft32(b []byte) []byte {
+	res := make([]byte, 32)
+	if len(b) > 32 {
+		b = b[len(b)-32:]
+	}
+	copy(res[32-len(b):], b)
+	return res
+}
+
+func padRight32(b []byte) []byte {
+	res := make([]byte, 32)
+	copy(res, b)
+	return res
+}
@@ -0,0 +1,593 @@
+package decompressor
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/0xsequence/czip/compressor"
+	"github.com/0xsequence/ethkit/go-ethereum/accounts/abi"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+	"github.com/0xsequence/go-sequence"
+)
+
+var (
+	testAddress = common.HexToAddress("0x8ba1f109551bd432803012645ac136ddd64dba72").Bytes()
+	testToken   = common.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7").Bytes()
+	testWallet  = common.HexToAddress("0x1111111111111111111111111111111111111111").Bytes()
+	testBytes32 = common.FromHex("0x9e4d5f2a3b1c7d8e6f5a4b3c2d1e0f9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b3a")
+)
+
+func padded(b []byte) []byte {
+	return common.LeftPadBytes(b, 32)
+}
+
+func word(v *big.Int) []byte {
+	return v.FillBytes(make([]byte, 32))
+}
+
+func pow(base int64, exp int64) *big.Int {
+	return new(big.Int).Exp(big.NewInt(base), big.NewInt(exp), nil)
+}
+
+func transfer(to []byte, amount *big.Int) []byte {
+	return append(common.FromHex("0xa9059cbb"), append(padded(to), word(amount)...)...)
+}
+
+// Indexes with the test address on 1 and the test bytes32 on 2
+func testIndexes() *compressor.Indexes {
+	return &compressor.Indexes{
+		AddressIndexes: map[string]uint{string(padded(testToken)): 1},
+		Bytes32Indexes: map[string]uint{string(testBytes32): 2},
+		Bytes4Indexes:  compressor.LoadBytes4(),
+	}
+}
+
+func testSignature() []byte {
+	sig := []byte{0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x07}
+	sig = append(sig, 0x01, 0x01)
+	sig = append(sig, testAddress...)
+	sig = append(sig, 0x00, 0x01)
+	sig = append(sig, bytes.Repeat([]byte{0xab}, 64)...)
+	sig = append(sig, 0x1b, 0x02)
+	sig = append(sig, 0x03)
+	return append(sig, testBytes32...)
+}
+
+func testTransaction(t *testing.T) (*sequence.Transaction, []byte) {
+	tx := &sequence.Transaction{
+		Nonce: big.NewInt(7),
+		Transactions: sequence.Transactions{
+			{RevertOnError: true, GasLimit: big.NewInt(100000), To: common.BytesToAddress(testToken), Value: big.NewInt(0), Data: transfer(testAddress, pow(10, 18))},
+			{DelegateCall: true, GasLimit: big.NewInt(0), To: common.BytesToAddress(testAddress), Value: big.NewInt(1), Data: []byte{}},
+		},
+		Signature: testSignature(),
+	}
+
+	execdata, err := tx.Execdata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tx, execdata
+}
+
+// Names of all the flags used by the payload
+func flagsUsed(t *testing.T, payload []byte, indexes *compressor.Indexes) map[string]bool {
+	instructions, err := Disassemble(payload, indexes, 1, 1)
+	if err != nil {
+		t.Fatalf("disassemble: %v", err)
+	}
+
+	res := make(map[string]bool)
+
+	var walk func([]*Instruction)
+	walk = func(instructions []*Instruction) {
+		for _, ins := range instructions {
+			res[ins.Name] = true
+			walk(ins.Children)
+		}
+	}
+
+	walk(instructions)
+	return res
+}
+
+func TestRoundTripMethods(t *testing.T) {
+	tx, execdata := testTransaction(t)
+	data := transfer(testAddress, pow(10, 18))
+
+	tests := []struct {
+		name   string
+		method uint
+		write  func(buf *compressor.Buffer) (compressor.EncodeType, error)
+		check  func(t *testing.T, res *Result)
+	}{
+		{
+			name:   "decode any",
+			method: compressor.METHOD_DECODE_ANY,
+			write: func(buf *compressor.Buffer) (compressor.EncodeType, error) {
+				return buf.WriteBytesOptimized(data, true)
+			},
+			check: func(t *testing.T, res *Result) {
+				if !bytes.Equal(res.Data, data) {
+					t.Fatalf("data %x, expected %x", res.Data, data)
+				}
+			},
+		},
+		{
+			name:   "decode call",
+			method: compressor.METHOD_DECODE_CALL,
+			write: func(buf *compressor.Buffer) (compressor.EncodeType, error) {
+				return buf.WriteCall(testToken, data)
+			},
+			check: func(t *testing.T, res *Result) {
+				checkCalls(t, res, [][]byte{testToken}, [][]byte{data})
+
+				expected := append(append([]byte{}, data...), padded(testToken)...)
+				if !bytes.Equal(res.Data, expected) {
+					t.Fatalf("data %x, expected %x", res.Data, expected)
+				}
+			},
+		},
+		{
+			name:   "execute call",
+			method: compressor.METHOD_EXECUTE_CALL,
+			write: func(buf *compressor.Buffer) (compressor.EncodeType, error) {
+				return buf.WriteCall(testToken, data)
+			},
+			check: func(t *testing.T, res *Result) {
+				checkCalls(t, res, [][]byte{testToken}, [][]byte{data})
+			},
+		},
+		{
+			name:   "execute call return",
+			method: compressor.METHOD_EXECUTE_CALL_RETURN,
+			write: func(buf *compressor.Buffer) (compressor.EncodeType, error) {
+				return buf.WriteCall(testToken, data)
+			},
+			check: func(t *testing.T, res *Result) {
+				checkCalls(t, res, [][]byte{testToken}, [][]byte{data})
+			},
+		},
+		{
+			name:   "decode n calls",
+			method: compressor.METHOD_DECODE_N_CALLS,
+			write: func(buf *compressor.Buffer) (compressor.EncodeType, error) {
+				return buf.WriteCalls([][]byte{testToken, testAddress}, [][]byte{data, {0x01, 0x02}})
+			},
+			check: func(t *testing.T, res *Result) {
+				checkCalls(t, res, [][]byte{testToken, testAddress}, [][]byte{data, {0x01, 0x02}})
+			},
+		},
+		{
+			name:   "execute n calls",
+			method: compressor.METHOD_EXECUTE_N_CALLS,
+			write: func(buf *compressor.Buffer) (compressor.EncodeType, error) {
+				return buf.WriteCalls([][]byte{testToken, testToken}, [][]byte{data, data})
+			},
+			check: func(t *testing.T, res *Result) {
+				checkCalls(t, res, [][]byte{testToken, testToken}, [][]byte{data, data})
+			},
+		},
+		{
+			name:   "decode sequence tx",
+			method: compressor.METHOD_DECODE_SEQUENCE_TX,
+			write: func(buf *compressor.Buffer) (compressor.EncodeType, error) {
+				return buf.WriteSequenceExecute(testWallet, tx)
+			},
+			check: func(t *testing.T, res *Result) {
+				checkSequenceTxs(t, res, [][]byte{testWallet}, [][]byte{execdata})
+
+				expected := append(append([]byte{}, execdata...), padded(testWallet)...)
+				if !bytes.Equal(res.Data, expected) {
+					t.Fatalf("data %x, expected %x", res.Data, expected)
+				}
+			},
+		},
+		{
+			name:   "execute sequence tx",
+			method: compressor.METHOD_EXECUTE_SEQUENCE_TX,
+			write: func(buf *compressor.Buffer) (compressor.EncodeType, error) {
+				return buf.WriteSequenceExecute(testWallet, tx)
+			},
+			check: func(t *testing.T, res *Result) {
+				checkSequenceTxs(t, res, [][]byte{testWallet}, [][]byte{execdata})
+			},
+		},
+		{
+			name:   "decode sequence n txs",
+			method: compressor.METHOD_DECODE_SEQUENCE_N_TXS,
+			write: func(buf *compressor.Buffer) (compressor.EncodeType, error) {
+				return buf.WriteSequenceExecutes([][]byte{testWallet, testAddress}, []*sequence.Transaction{tx, tx})
+			},
+			check: func(t *testing.T, res *Result) {
+				checkSequenceTxs(t, res, [][]byte{testWallet, testAddress}, [][]byte{execdata, execdata})
+			},
+		},
+		{
+			name:   "execute sequence n txs",
+			method: compressor.METHOD_EXECUTE_SEQUENCE_N_TXS,
+			write: func(buf *compressor.Buffer) (compressor.EncodeType, error) {
+				return buf.WriteSequenceExecutes([][]byte{testWallet, testAddress}, []*sequence.Transaction{tx, tx})
+			},
+			check: func(t *testing.T, res *Result) {
+				checkSequenceTxs(t, res, [][]byte{testWallet, testAddress}, [][]byte{execdata, execdata})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, useStorage := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s storage=%v", tt.name, useStorage), func(t *testing.T) {
+				buf := compressor.NewBuffer(tt.method, testIndexes(), nil, useStorage)
+				if _, err := tt.write(buf); err != nil {
+					t.Fatalf("encode: %v", err)
+				}
+
+				res, err := Decompress(buf.Commited, testIndexes(), 2, 3)
+				if err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+
+				if res.Method != tt.method {
+					t.Fatalf("method %d, expected %d", res.Method, tt.method)
+				}
+
+				tt.check(t, res)
+				checkWrites(t, buf, res)
+			})
+		}
+	}
+}
+
+func checkCalls(t *testing.T, res *Result, tos [][]byte, datas [][]byte) {
+	t.Helper()
+
+	if len(res.Calls) != len(tos) {
+		t.Fatalf("%d calls, expected %d", len(res.Calls), len(tos))
+	}
+
+	for i, call := range res.Calls {
+		if !bytes.Equal(call.To.Bytes(), tos[i]) || !bytes.Equal(call.Data, datas[i]) {
+			t.Fatalf("call %d is %s %x, expected %x %x", i, call.To.Hex(), call.Data, tos[i], datas[i])
+		}
+	}
+}
+
+func checkSequenceTxs(t *testing.T, res *Result, wallets [][]byte, execdatas [][]byte) {
+	t.Helper()
+
+	if len(res.SequenceTxs) != len(wallets) {
+		t.Fatalf("%d transactions, expected %d", len(res.SequenceTxs), len(wallets))
+	}
+
+	for i, tx := range res.SequenceTxs {
+		if !bytes.Equal(tx.Wallet.Bytes(), wallets[i]) || !bytes.Equal(tx.Execdata, execdatas[i]) {
+			t.Fatalf("transaction %d is %s %x, expected %x %x", i, tx.Wallet.Hex(), tx.Execdata, wallets[i], execdatas[i])
+		}
+	}
+}
+
+// The decompressor must save the same values the compressor expects, in the same order
+func checkWrites(t *testing.T, buf *compressor.Buffer, res *Result) {
+	t.Helper()
+
+	writes := buf.StorageWrites()
+	if len(writes) != len(res.Writes) {
+		t.Fatalf("%d writes, expected %d", len(res.Writes), len(writes))
+	}
+
+	for i, w := range writes {
+		if w.Flag != res.Writes[i].Flag || !bytes.Equal(padded(w.Value), padded(res.Writes[i].Value)) {
+			t.Fatalf("write %d is %d %x, expected %d %x", i, res.Writes[i].Flag, res.Writes[i].Value, w.Flag, w.Value)
+		}
+	}
+}
+
+func TestRoundTripFlags(t *testing.T) {
+	random := common.FromHex("0x5a1c9e0b7d3f2a4c6e8b1d3f5a7c9e0b2d4f6a8c1e3b5d7f9a0c2e4b6d8f1a3c")
+	blob := common.FromHex("0x0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f2021222324252627")
+
+	tests := []struct {
+		name       string
+		data       []byte
+		useStorage bool
+
+		// At least one of the flags must be used
+		flags []string
+	}{
+		{name: "literals", data: word(big.NewInt(5)), flags: []string{"LITERAL_ZERO"}},
+		{name: "words", data: random, flags: []string{"FLAG_READ_WORD_32"}},
+		{name: "short words", data: word(big.NewInt(0x123456)), flags: []string{"FLAG_READ_WORD_3"}},
+		{name: "zeros", data: make([]byte, 40), flags: []string{"FLAG_WRITE_ZEROS"}},
+		{name: "pow2", data: word(pow(2, 200)), flags: []string{"FLAG_POW_2"}},
+		{name: "pow2 minus 1", data: word(new(big.Int).Sub(pow(2, 200), big.NewInt(1))), flags: []string{"FLAG_POW_2_MINUS_1"}},
+		{name: "pow10", data: word(pow(10, 30)), flags: []string{"FLAG_POW_10"}},
+		{name: "pow10 mantissa", data: word(new(big.Int).Mul(big.NewInt(1234), pow(10, 20))), flags: []string{"FLAG_POW_10_MANTISSA_S", "FLAG_POW_10_MANTISSA_L"}},
+		{name: "nested", data: append(append(random, word(pow(10, 30))...), random...), flags: []string{"FLAG_NESTED_N_FLAGS_S"}},
+		{name: "mirror", data: append(append(random, word(big.NewInt(5))...), random...), flags: []string{"FLAG_MIRROR_FLAG_S"}},
+		{name: "raw bytes", data: blob[:7], flags: []string{"FLAG_READ_N_BYTES"}},
+		{name: "abi", data: transfer(testAddress, pow(10, 18)), flags: []string{"FLAG_ABI_2_PARAMS"}},
+		{name: "dynamic abi", data: dynamicCall(), flags: []string{"FLAG_READ_DYNAMIC_ABI"}},
+		{name: "save address", data: transfer(testAddress, big.NewInt(1)), useStorage: true, flags: []string{"FLAG_SAVE_ADDRESS"}},
+		{name: "save bytes32", data: append(append([]byte{}, random...), random...), useStorage: true, flags: []string{"FLAG_SAVE_BYTES32"}},
+		{name: "read address", data: transfer(testToken, big.NewInt(1)), useStorage: true, flags: []string{"FLAG_READ_ADDRESS_2"}},
+		{name: "read bytes32", data: testBytes32, useStorage: true, flags: []string{"FLAG_READ_BYTES32_2"}},
+		{name: "read store flag", data: append(append(padded(testAddress), word(big.NewInt(5))...), padded(testAddress)...), useStorage: true, flags: []string{"FLAG_READ_STORE_FLAG_S"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := compressor.NewBuffer(compressor.METHOD_DECODE_ANY, testIndexes(), nil, tt.useStorage)
+			if _, err := buf.WriteBytesOptimized(tt.data, true); err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+
+			res, err := Decompress(buf.Commited, testIndexes(), 2, 3)
+			if err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+
+			if !bytes.Equal(res.Data, tt.data) {
+				t.Fatalf("data %x, expected %x", res.Data, tt.data)
+			}
+
+			checkWrites(t, buf, res)
+
+			used := flagsUsed(t, buf.Commited, testIndexes())
+			found := false
+			for _, flag := range tt.flags {
+				found = found || used[flag]
+			}
+
+			if !found {
+				t.Fatalf("payload %x uses none of %s", buf.Commited, strings.Join(tt.flags, ", "))
+			}
+		})
+	}
+}
+
+// A call with a bytes argument, f(uint256,bytes), long enough to not be sent as plain words
+func dynamicCall() []byte {
+	arg := make([]byte, 45)
+	for i := range arg {
+		arg[i] = byte(i*37 + 11)
+	}
+
+	data := common.FromHex("0x12345678")
+	data = append(data, word(big.NewInt(1))...)
+	data = append(data, word(big.NewInt(0x40))...)
+	data = append(data, word(big.NewInt(int64(len(arg))))...)
+	return append(data, common.RightPadBytes(arg, 64)...)
+}
+
+// Bytes are only copied from the payload already written, so the data must repeat on another call
+func TestRoundTripCopyCalldata(t *testing.T) {
+	data := dynamicCall()[4+64+32:][:45]
+	datas := [][]byte{data, append(append([]byte{}, data...), 1, 2, 3)}
+
+	buf := compressor.NewBuffer(compressor.METHOD_DECODE_N_CALLS, testIndexes(), nil, false)
+	if _, err := buf.WriteCalls([][]byte{testAddress, testAddress}, datas); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	res, err := Decompress(buf.Commited, testIndexes(), 2, 3)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+
+	checkCalls(t, res, [][]byte{testAddress, testAddress}, datas)
+
+	if used := flagsUsed(t, buf.Commited, testIndexes()); !used["FLAG_COPY_CALLDATA_S"] {
+		t.Fatalf("payload %x does not use FLAG_COPY_CALLDATA_S", buf.Commited)
+	}
+}
+
+func TestRoundTripSequenceFlags(t *testing.T) {
+	tx, execdata := testTransaction(t)
+
+	// The signature flags read the weights of the contract storage
+	buf := compressor.NewBuffer(compressor.METHOD_DECODE_SEQUENCE_TX, testIndexes(), nil, true)
+	if _, err := buf.WriteSequenceExecute(testWallet, tx); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	res, err := Decompress(buf.Commited, testIndexes(), 2, 3)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+
+	checkSequenceTxs(t, res, [][]byte{testWallet}, [][]byte{execdata})
+
+	used := flagsUsed(t, buf.Commited, testIndexes())
+	for _, flag := range []string{"FLAG_SEQUENCE_SIG", "FLAG_SEQUENCE_ADDRESS_W1", "FLAG_SEQUENCE_SIGNATURE_W1", "FLAG_SEQUENCE_NODE"} {
+		if !used[flag] {
+			t.Errorf("payload %x does not use %s", buf.Commited, flag)
+		}
+	}
+}
+
+// Empty blobs are a single flag that writes nothing
+func TestRoundTripEmptySegmented(t *testing.T) {
+	for _, allow := range []*compressor.AllowOpcodes{nil, {Default: true, List: map[uint]bool{compressor.FLAG_NO_OP: true}}} {
+		buf := compressor.NewBuffer(compressor.METHOD_DECODE_ANY, testIndexes(), allow, false)
+		if _, err := buf.WriteBytesSegmented(nil, false); err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+
+		res, err := Decompress(buf.Commited, testIndexes(), 2, 3)
+		if err != nil {
+			t.Fatalf("decompress %x: %v", buf.Commited, err)
+		}
+
+		if len(res.Data) != 0 {
+			t.Fatalf("decompressed %x, expected nothing", res.Data)
+		}
+	}
+}
+
+// Disallowing the flag of a weight falls back to the W0 flag, that has the weight on its own byte
+func TestRoundTripSequenceWeightFlags(t *testing.T) {
+	tx, execdata := testTransaction(t)
+
+	disallowed := &compressor.AllowOpcodes{Default: true, List: map[uint]bool{
+		compressor.FLAG_SEQUENCE_ADDRESS_W1:   true,
+		compressor.FLAG_SEQUENCE_SIGNATURE_W1: true,
+	}}
+
+	buf := compressor.NewBuffer(compressor.METHOD_DECODE_SEQUENCE_TX, testIndexes(), disallowed, true)
+	if _, err := buf.WriteSequenceExecute(testWallet, tx); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	res, err := Decompress(buf.Commited, testIndexes(), 2, 3)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+
+	checkSequenceTxs(t, res, [][]byte{testWallet}, [][]byte{execdata})
+
+	used := flagsUsed(t, buf.Commited, testIndexes())
+	for flag, expected := range map[string]bool{
+		"FLAG_SEQUENCE_ADDRESS_W0":   true,
+		"FLAG_SEQUENCE_SIGNATURE_W0": true,
+		"FLAG_SEQUENCE_ADDRESS_W1":   false,
+		"FLAG_SEQUENCE_SIGNATURE_W1": false,
+	} {
+		if used[flag] != expected {
+			t.Errorf("payload %x uses %s: %v, expected %v", buf.Commited, flag, used[flag], expected)
+		}
+	}
+}
+
+// Values saved by the payload go after the totals of the contract, even
+// if the indexes only have some of the values saved on it
+func TestSaveIndexesUseTotals(t *testing.T) {
+	data := transfer(testAddress, big.NewInt(1))
+
+	buf := compressor.NewBuffer(compressor.METHOD_DECODE_ANY, testIndexes(), nil, true)
+	if _, err := buf.WriteBytesOptimized(data, true); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	tests := []struct {
+		addresses uint
+		index     uint
+	}{
+		{addresses: 1, index: 1},
+		{addresses: 2, index: 2},
+		{addresses: 100, index: 100},
+	}
+
+	for _, tt := range tests {
+		res, err := Decompress(buf.Commited, testIndexes(), tt.addresses, 1)
+		if err != nil {
+			t.Fatalf("decompress: %v", err)
+		}
+
+		if len(res.Writes) != 1 || res.Writes[0].Index != tt.index {
+			t.Fatalf("with %d addresses the write is on %v, expected %d", tt.addresses, res.Writes, tt.index)
+		}
+	}
+}
+
+// Each level mirrors the next one 255 times, without a budget it would write 255 ** 4 words
+func TestMirrorExpansionIsBounded(t *testing.T) {
+	const levels = 4
+	const levelSize = 2 + 255*3
+
+	payload := []byte{byte(compressor.METHOD_DECODE_ANY)}
+	for i := 0; i < levels; i++ {
+		next := 1 + levelSize*(i+1)
+
+		payload = append(payload, byte(compressor.FLAG_NESTED_N_FLAGS_S), 255)
+		for j := 0; j < 255; j++ {
+			payload = append(payload, byte(compressor.FLAG_MIRROR_FLAG_S), byte(next>>8), byte(next))
+		}
+	}
+
+	payload = append(payload, byte(compressor.LITERAL_ZERO+1))
+
+	_, err := Decompress(payload, nil, 1, 1)
+	if err == nil || !strings.Contains(err.Error(), "max") {
+		t.Fatalf("expected the expansion to be bounded, got %v", err)
+	}
+}
+
+const testABI = `[
+	{"type":"function","name":"bytesArg","inputs":[{"name":"a","type":"uint256"},{"name":"b","type":"bytes"}]},
+	{"type":"function","name":"slice","inputs":[{"name":"a","type":"uint256[]"},{"name":"b","type":"address"}]},
+	{"type":"function","name":"nestedSlice","inputs":[{"name":"a","type":"bytes[][]"},{"name":"b","type":"string"}]},
+	{"type":"function","name":"fixedArray","inputs":[{"name":"a","type":"string[2]"},{"name":"b","type":"uint256[3]"}]},
+	{"type":"function","name":"tuple","inputs":[{"name":"a","type":"tuple","components":[{"name":"to","type":"address"},{"name":"amounts","type":"uint256[]"},{"name":"data","type":"bytes"}]}]},
+	{"type":"function","name":"tupleSlice","inputs":[{"name":"a","type":"tuple[]","components":[{"name":"to","type":"address"},{"name":"data","type":"bytes"}]},{"name":"b","type":"bool"}]}
+]`
+
+type testCall struct {
+	To   common.Address
+	Data []byte
+}
+
+type testTransfer struct {
+	To      common.Address
+	Amounts []*big.Int
+	Data    []byte
+}
+
+func TestRoundTripCalldataABI(t *testing.T) {
+	contract, err := abi.JSON(strings.NewReader(testABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := common.BytesToAddress(testAddress)
+	long := bytes.Repeat([]byte{0x5a, 0x00, 0x17}, 30)
+
+	tests := []struct {
+		method string
+		args   []interface{}
+	}{
+		{method: "bytesArg", args: []interface{}{big.NewInt(1), long}},
+		{method: "bytesArg", args: []interface{}{big.NewInt(0), []byte{}}},
+		{method: "slice", args: []interface{}{[]*big.Int{big.NewInt(1), pow(10, 18), big.NewInt(0)}, to}},
+		{method: "slice", args: []interface{}{[]*big.Int{}, to}},
+		{method: "nestedSlice", args: []interface{}{[][][]byte{{{1, 2}, long}, {}, {{}}}, "hello"}},
+		{method: "fixedArray", args: []interface{}{[2]string{"a", string(long)}, [3]*big.Int{big.NewInt(1), big.NewInt(2), pow(2, 200)}}},
+		{method: "tuple", args: []interface{}{testTransfer{To: to, Amounts: []*big.Int{big.NewInt(5)}, Data: long}}},
+		{method: "tupleSlice", args: []interface{}{[]testCall{{To: to, Data: long}, {To: common.BytesToAddress(testToken), Data: nil}}, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			method := contract.Methods[tt.method]
+
+			args, err := method.Inputs.Pack(tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data := append(append([]byte{}, method.ID...), args...)
+
+			for _, useStorage := range []bool{false, true} {
+				buf := compressor.NewBuffer(compressor.METHOD_DECODE_ANY, testIndexes(), nil, useStorage)
+				if _, err := buf.WriteCalldataABI(data, &contract, true); err != nil {
+					t.Fatalf("encode: %v", err)
+				}
+
+				res, err := Decompress(buf.Commited, testIndexes(), 2, 3)
+				if err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+
+				if !bytes.Equal(res.Data, data) {
+					t.Fatalf("data %x, expected %x", res.Data, data)
+				}
+
+				checkWrites(t, buf, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package decompressor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/0xsequence/czip/compressor"
+)
+
+// A single node of the disassembled payload, flags that read other flags
+// (nested, ABI, Sequence, mirrors) have them as children.
+type Instruction struct {
+	Offset   uint
+	Name     string
+	Args     string
+	Output   []byte
+	Children []*Instruction
+}
+
+var flagNames = func() map[uint]string {
+	names := make(map[uint]string)
+	for name, flag := range compressor.FlagNames() {
+		names[flag] = name
+	}
+	return names
+}()
+
+var methodNames = map[uint]string{
+	compressor.METHOD_EXECUTE_SEQUENCE_TX:    "METHOD_EXECUTE_SEQUENCE_TX",
+	compressor.METHOD_EXECUTE_SEQUENCE_N_TXS: "METHOD_EXECUTE_SEQUENCE_N_TXS",
+	compressor.METHOD_READ_ADDRESS:           "METHOD_READ_ADDRESS",
+	compressor.METHOD_READ_BYTES32:           "METHOD_READ_BYTES32",
+	compressor.METHOD_READ_SIZES:             "METHOD_READ_SIZES",
+	compressor.METHOD_READ_STORAGE_SLOTS:     "METHOD_READ_STORAGE_SLOTS",
+	compressor.METHOD_DECODE_SEQUENCE_TX:     "METHOD_DECODE_SEQUENCE_TX",
+	compressor.METHOD_DECODE_SEQUENCE_N_TXS:  "METHOD_DECODE_SEQUENCE_N_TXS",
+	compressor.METHOD_EXECUTE_CALL:           "METHOD_EXECUTE_CALL",
+	compressor.METHOD_EXECUTE_CALL_RETURN:    "METHOD_EXECUTE_CALL_RETURN",
+	compressor.METHOD_EXECUTE_N_CALLS:        "METHOD_EXECUTE_N_CALLS",
+	compressor.METHOD_DECODE_CALL:            "METHOD_DECODE_CALL",
+	compressor.METHOD_DECODE_N_CALLS:         "METHOD_DECODE_N_CALLS",
+	compressor.METHOD_DECODE_ANY:             "METHOD_DECODE_ANY",
+}
+
+// Walks a compressed payload and returns the tree of flags it contains,
+// if the payload fails to decompress the instructions read so far are
+// returned together with the error.
+func Disassemble(payload []byte, indexes *compressor.Indexes, addresses uint, bytes32 uint) ([]*Instruction, error) {
+	d := NewDecompressor(payload, indexes, addresses, bytes32)
+	d.tracing = true
+
+	_, err := d.Decompress()
+	return d.roots, err
+}
+
+// Renders the instructions one per line, using the offset of each
+// flag and indenting the children under their parent.
+func FormatInstructions(instructions []*Instruction) string {
+	var sb strings.Builder
+	formatInstructions(&sb, instructions, 0)
+	return sb.String()
+}
+
+func formatInstructions(sb *strings.Builder, instructions []*Instruction, depth int) {
+	for _, ins := range instructions {
+		fmt.Fprintf(sb, "%06x %s%s", ins.Offset, strings.Repeat("  ", depth), ins.Name)
+
+		if ins.Args != "" {
+			fmt.Fprintf(sb, " %s", ins.Args)
+		}
+
+		if ins.Output != nil {
+			fmt.Fprintf(sb, " -> 0x%x", ins.Output)
+		}
+
+		sb.WriteString("\n")
+		formatInstructions(sb, ins.Children, depth+1)
+	}
+}
+
+func flagName(flag uint) string {
+	if flag >= compressor.LITERAL_ZERO {
+		return "LITERAL_ZERO"
+	}
+
+	return flagNames[flag]
+}
+
+func methodName(method uint) string {
+	if name, ok := methodNames[method]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("METHOD_UNKNOWN_%d", method)
+}
+
+// Adds a new instruction under the one currently being read,
+// it returns nil if the decompressor is not tracing.
+func (d *Decompressor) enter(offset uint, name string, args string) *Instruction {
+	if !d.tracing {
+		return nil
+	}
+
+	ins := &Instruction{Offset: offset, Name: name, Args: args}
+	if len(d.stack) == 0 {
+		d.roots = append(d.roots, ins)
+	} else {
+		parent := d.stack[len(d.stack)-1]
+		parent.Children = append(parent.Children, ins)
+	}
+
+	d.stack = append(d.stack, ins)
+	return ins
+}
+
+// Closes the instruction, recording the bytes it wrote between from and to.
+func (d *Decompressor) leave(ins *Instruction, from uint, to uint) {
+	if ins == nil {
+		return
+	}
+
+	if to > from {
+		ins.Output = d.copyMem(from, to)
+	} else {
+		ins.Output = []byte{}
+	}
+
+	d.stack = d.stack[:len(d.stack)-1]
+}
+
+// Describes the arguments of a flag, rindex points right after the flag.
+// It must not have side effects, as it runs before the flag is executed.
+func (d *Decompressor) describe(flag uint, rindex uint) string {
+	if !d.tracing {
+		return ""
+	}
+
+	switch {
+	case flag >= compressor.FLAG_READ_WORD_1 && flag <= compressor.FLAG_READ_WORD_32:
+		return fmt.Sprintf("size=%d", flag-compressor.FLAG_READ_WORD_1+1)
+
+	case flag == compressor.FLAG_READ_WORD_INV:
+		return fmt.Sprintf("size=%d", d.loadUint(rindex, 1))
+
+	case flag == compressor.FLAG_WRITE_ZEROS:
+		return fmt.Sprintf("size=%d", d.loadUint(rindex, 1))
+
+	case flag == compressor.FLAG_NESTED_N_FLAGS_S:
+		return fmt.Sprintf("n=%d", d.loadUint(rindex, 1))
+
+	case flag == compressor.FLAG_NESTED_N_FLAGS_L:
+		return fmt.Sprintf("n=%d", d.loadUint(rindex, 2))
+
+	case flag == compressor.FLAG_SAVE_ADDRESS:
+		return fmt.Sprintf("index=%d value=0x%x", d.addressesNum+1, d.load(rindex, 20))
+
+	case flag == compressor.FLAG_SAVE_BYTES32:
+		return fmt.Sprintf("index=%d value=0x%x", d.bytes32Num+1, d.load(rindex, 32))
+
+	case flag >= compressor.FLAG_READ_ADDRESS_2 && flag <= compressor.FLAG_READ_ADDRESS_4:
+		return fmt.Sprintf("index=%d", d.loadUint(rindex, flag-compressor.FLAG_READ_ADDRESS_2+2))
+
+	case flag >= compressor.FLAG_READ_BYTES32_2 && flag <= compressor.FLAG_READ_BYTES32_4:
+		return fmt.Sprintf("index=%d", d.loadUint(rindex, flag-compressor.FLAG_READ_BYTES32_2+2))
+
+	case flag == compressor.FLAG_READ_STORE_FLAG_S, flag == compressor.FLAG_READ_STORE_FLAG_L:
+		return fmt.Sprintf("pointer=%d", d.loadUint(rindex, flag-compressor.FLAG_READ_STORE_FLAG_S+2))
+
+	case flag == compressor.FLAG_MIRROR_FLAG_S, flag == compressor.FLAG_MIRROR_FLAG_L:
+		return fmt.Sprintf("pointer=%d", d.loadUint(rindex, flag-compressor.FLAG_MIRROR_FLAG_S+2))
+
+	case flag == compressor.FLAG_POW_2, flag == compressor.FLAG_POW_10:
+		return fmt.Sprintf("exp=%d", d.loadUint(rindex, 1))
+
+	case flag == compressor.FLAG_POW_2_MINUS_1:
+		return fmt.Sprintf("exp=%d", d.loadUint(rindex, 1)+1)
+
+	case flag == compressor.FLAG_POW_10_MANTISSA_S:
+		packed := d.loadUint(rindex, 2)
+		return fmt.Sprintf("exp=%d mantissa=%d", packed>>11, packed&0x7ff)
+
+	case flag == compressor.FLAG_POW_10_MANTISSA_L:
+		packed := d.loadUint(rindex, 3)
+		return fmt.Sprintf("exp=%d mantissa=%d", packed>>18, packed&0x3ffff)
+
+	case flag >= compressor.FLAG_ABI_0_PARAM && flag <= compressor.FLAG_ABI_6_PARAMS:
+		return fmt.Sprintf("%s params=%d", d.describeSelector(rindex), flag-compressor.FLAG_ABI_0_PARAM)
+
+	case flag == compressor.FLAG_READ_DYNAMIC_ABI:
+		// The selector is followed by the number of params and the dynamic bitmap
+		offset := uint(1)
+		if d.loadUint(rindex, 1) == 0 {
+			offset += 4
+		}
+		return fmt.Sprintf(
+			"%s params=%d dynamic=%08b",
+			d.describeSelector(rindex),
+			d.loadUint(rindex+offset, 1),
+			d.loadUint(rindex+offset+1, 1),
+		)
+
+	case flag == compressor.FLAG_COPY_CALLDATA_S:
+		return fmt.Sprintf("location=%d size=%d", d.loadUint(rindex, 2), d.loadUint(rindex+2, 1))
+
+	case flag == compressor.FLAG_COPY_CALLDATA_L:
+		return fmt.Sprintf("location=%d size=%d", d.loadUint(rindex, 3), d.loadUint(rindex+3, 1))
+
+	case flag == compressor.FLAG_COPY_CALLDATA_XL:
+		return fmt.Sprintf("location=%d size=%d", d.loadUint(rindex, 3), d.loadUint(rindex+3, 2))
+
+	case flag == compressor.FLAG_SEQUENCE_SELF_EXECUTE:
+		return fmt.Sprintf("txs=%d", d.loadUint(rindex, 1))
+
+	case flag == compressor.FLAG_SEQUENCE_SIGNATURE_W0, flag == compressor.FLAG_SEQUENCE_ADDRESS_W0:
+		return fmt.Sprintf("weight=%d", d.loadUint(rindex, 1))
+
+	case flag > compressor.FLAG_SEQUENCE_SIGNATURE_W0 && flag <= compressor.FLAG_SEQUENCE_SIGNATURE_W4:
+		return fmt.Sprintf("weight=%d", flag-compressor.FLAG_SEQUENCE_SIGNATURE_W0)
+
+	case flag > compressor.FLAG_SEQUENCE_ADDRESS_W0 && flag <= compressor.FLAG_SEQUENCE_ADDRESS_W4:
+		return fmt.Sprintf("weight=%d", flag-compressor.FLAG_SEQUENCE_ADDRESS_W0)
+
+	case flag == compressor.FLAG_SEQUENCE_NESTED:
+		return fmt.Sprintf("weight=%d threshold=%d", d.loadUint(rindex, 1), d.loadUint(rindex+1, 1))
+
+	case flag == compressor.FLAG_SEQUENCE_DYNAMIC_SIGNATURE:
+		return fmt.Sprintf("weight=%d", d.loadUint(rindex, 1))
+
+	case flag == compressor.FLAG_SEQUENCE_SIG_NO_CHAIN, flag == compressor.FLAG_SEQUENCE_SIG:
+		return fmt.Sprintf("threshold=%d", d.loadUint(rindex, 1))
+
+	case flag == compressor.FLAG_SEQUENCE_L_SIG_NO_CHAIN, flag == compressor.FLAG_SEQUENCE_L_SIG:
+		return fmt.Sprintf("threshold=%d", d.loadUint(rindex, 2))
+
+	case flag == compressor.FLAG_SEQUENCE_READ_CHAINED_S:
+		return fmt.Sprintf("n=%d", d.loadUint(rindex, 1))
+
+	case flag == compressor.FLAG_SEQUENCE_READ_CHAINED_L:
+		return fmt.Sprintf("n=%d", d.loadUint(rindex, 2))
+
+	case flag >= compressor.LITERAL_ZERO:
+		return fmt.Sprintf("value=%d", flag-compressor.LITERAL_ZERO)
+	}
+
+	return ""
+}
+
+func (d *Decompressor) describeSelector(rindex uint) string {
+	index := d.loadUint(rindex, 1)
+	if index == 0 {
+		return fmt.Sprintf("selector=0x%x", d.load(rindex+1, 4))
+	}
+
+	return fmt.Sprintf("selector=0x%x table=%d", d.bytes4[index*4:index*4+4], index)
+}
+
+// See WriteSequenceTransaction for the meaning of each bit
+func describeTransactionFlag(tflag uint) string {
+	return fmt.Sprintf(
+		"delegateCall=%t revertOnError=%t gasLimit=%t value=%t data=%t",
+		tflag>>7 == 1,
+		(tflag>>6)&1 == 1,
+		(tflag>>5)&1 == 1,
+		(tflag>>4)&1 == 1,
+		tflag&1 == 1,
+	)
+}
@@ -8,10 +8,108 @@ import (
 	"github.com/0xsequence/go-sequence"
 )
 
+// A possible encoding of a word, only used while
+// choosing the cheapest one
+type wordCandidate struct {
+	encoded []byte
+	t       EncodeType
+}
+
+// Returns the total cost of an encoded word, the first byte is always the flag
+func (buf *Buffer) wordCost(encoded []byte, t EncodeType) uint64 {
+	model := buf.costModel()
+	cost := model.CalldataCost(encoded) + model.FlagCost(uint(encoded[0]))
+
+	switch t {
+	case ReadStorage:
+		cost += model.SloadCost()
+	case WriteStorage:
+		cost += model.SstoreCost()
+	}
+
+	return cost
+}
+
 // Encodes a 32 bytes word, trying to optimize it as much as possible
+// every possible encoding is listed and the cheapest one is used
 func (buf *Buffer) EncodeWordOptimized(word []byte, saveWord bool) ([]byte, EncodeType, error) {
+	best, _, err := buf.encodeWordOptimized(word, saveWord)
+	if err != nil {
+		return nil, Stateless, err
+	}
+
+	return best.encoded, best.t, nil
+}
+
+// Returns the cheapest encoding of the word, and its cost. Storage writes are
+// priced as the encoding they replace, as they pay for themselves later.
+func (buf *Buffer) encodeWordOptimized(word []byte, saveWord bool) (*wordCandidate, uint64, error) {
 	if len(word) > 32 {
-		return nil, Stateless, fmt.Errorf("word exceeds 32 bytes")
+		return nil, 0, fmt.Errorf("word exceeds 32 bytes")
+	}
+
+	candidates, saves := buf.wordCandidates(word, saveWord)
+
+	// Ties are resolved in favor of the first candidate, so the candidates
+	// that don't depend on previous data are preferred
+	var best *wordCandidate
+	for _, c := range candidates {
+		if best == nil || buf.wordCost(c.encoded, c.t) < buf.wordCost(best.encoded, best.t) {
+			best = c
+		}
+	}
+
+	if len(saves) != 0 {
+		if best == nil {
+			return saves[0], buf.wordCost(saves[0].encoded, saves[0].t), nil
+		}
+
+		if buf.savePaysOff(saves[0], best) {
+			return saves[0], buf.wordCost(best.encoded, best.t), nil
+		}
+	}
+
+	if best == nil {
+		return nil, 0, fmt.Errorf("no allowed encoding for word 0x%x", word)
+	}
+
+	return best, buf.wordCost(best.encoded, best.t), nil
+}
+
+// Saving a word costs more than encoding it, but it can be read back later using
+// an index. It is only worth it if the extra cost is recovered by the expected reads.
+func (buf *Buffer) savePaysOff(save *wordCandidate, best *wordCandidate) bool {
+	// The next index is used to price the reads, the first byte of the save is the flag
+	var read []byte
+	if uint(save.encoded[0]) == FLAG_SAVE_ADDRESS {
+		read = encodeStorageRead(FLAG_READ_ADDRESS_2, uint(len(buf.Refs.Indexes.AddressIndexes))+1)
+	} else {
+		read = encodeStorageRead(FLAG_READ_BYTES32_2, uint(len(buf.Refs.Indexes.Bytes32Indexes))+1)
+	}
+
+	if read == nil {
+		return false
+	}
+
+	saveCost := buf.wordCost(save.encoded, save.t)
+	bestCost := buf.wordCost(best.encoded, best.t)
+	readCost := buf.wordCost(read, ReadStorage)
+
+	if bestCost <= readCost {
+		return false
+	}
+
+	return saveCost <= bestCost+buf.costModel().ExpectedReads()*(bestCost-readCost)
+}
+
+// Lists all the possible encodings of a word, writes to storage are returned
+// separately as they are chosen using different rules.
+func (buf *Buffer) wordCandidates(word []byte, saveWord bool) ([]*wordCandidate, []*wordCandidate) {
+	var candidates []*wordCandidate
+	var saves []*wordCandidate
+
+	add := func(encoded []byte, t EncodeType) {
+		candidates = append(candidates, &wordCandidate{encoded, t})
 	}
 
 	trimmed := bytes.TrimLeft(word, "\x00")
@@ -21,60 +119,57 @@ func (buf *Buffer) EncodeWordOptimized(word []byte, saveWord bool) ([]byte, Enco
 	copy(padded32[32-len(word):], word)
 	trimmedRight := bytes.TrimRight(padded32, "\x00")
 
-	// If empty then it can be encoded as literal zero
+	// Literals use a single byte, zero is a literal too
 	if buf.Allows(LITERAL_ZERO) && len(trimmed) == 0 {
-		return []byte{byte(LITERAL_ZERO)}, Stateless, nil
+		add([]byte{byte(LITERAL_ZERO)}, Stateless)
 	}
 
-	// Literals are the cheapest encoding
-	if buf.Allows(LITERAL_ZERO) && len(trimmed) <= 1 && trimmed[0] <= byte(MAX_LITERAL) {
-		return []byte{trimmed[0] + byte(LITERAL_ZERO)}, Stateless, nil
+	if buf.Allows(LITERAL_ZERO) && len(trimmed) == 1 && trimmed[0] <= byte(MAX_LITERAL) {
+		add([]byte{trimmed[0] + byte(LITERAL_ZERO)}, Stateless)
 	}
 
-	// If it only has 1 byte, then we encode it as a word
-	// all other methods use 2 bytes anyway
-	if buf.Allows(FLAG_READ_WORD_1) && len(trimmed) == 1 {
-		return buf.EncodeWordBytes32(trimmed)
-	}
-
-	// If the word is a power of 2 or 10, we can encode it using 1 byte
-	pow2 := isPow2(trimmed)
-	if buf.Allows(FLAG_POW_2) && pow2 != -1 {
-		return []byte{byte(FLAG_POW_2), byte(pow2)}, Stateless, nil
-	}
+	// Powers of 2 and 10, and 2 ** n - 1 use 1 byte for the exponent
+	if len(trimmed) != 0 {
+		pow2 := isPow2(trimmed)
+		if buf.Allows(FLAG_POW_2) && pow2 != -1 {
+			add([]byte{byte(FLAG_POW_2), byte(pow2)}, Stateless)
+		}
 
-	// Pow 10 can be encoded as 10 ** N, this uses 1 byte
-	pow10 := isPow10(trimmed)
-	if buf.Allows(FLAG_POW_10) && pow10 != -1 && pow10 != 0 && pow10 <= 77 {
-		return []byte{byte(FLAG_POW_10), byte(pow10)}, Stateless, nil
-	}
+		pow10 := isPow10(trimmed)
+		if buf.Allows(FLAG_POW_10) && pow10 != -1 && pow10 != 0 && pow10 <= 77 {
+			add([]byte{byte(FLAG_POW_10), byte(pow10)}, Stateless)
+		}
 
-	// 2 ** n - 1 can be represented by 1 byte
-	// we need to subtract 1 from the value, or else we can't represent 2 ** 256 - 1
-	pow2minus1 := isPow2minus1(trimmed)
-	if buf.Allows(FLAG_POW_2_MINUS_1) && pow2minus1 != -1 {
-		// The opcode adds an extra 1 to the value, so we need to subtract 1
-		return []byte{byte(FLAG_POW_2_MINUS_1), byte(pow2minus1 - 1)}, Stateless, nil
+		// The opcode adds an extra 1 to the exponent, or else we can't represent 2 ** 256 - 1
+		pow2minus1 := isPow2minus1(trimmed)
+		if buf.Allows(FLAG_POW_2_MINUS_1) && pow2minus1 != -1 {
+			add([]byte{byte(FLAG_POW_2_MINUS_1), byte(pow2minus1 - 1)}, Stateless)
+		}
 	}
 
-	// Now we can store words of 2 bytes, we have exhausted all the 1 byte options
-	if buf.Allows(FLAG_READ_WORD_1) && len(trimmed) <= 2 {
-		return buf.EncodeWordBytes32(trimmed)
+	// The word as-is, zero needs at least 1 byte if literals are not allowed
+	asIs := trimmed
+	if len(asIs) == 0 {
+		asIs = padded32[31:]
 	}
 
-	// Trimmed right inv uses 1 extra byte (so 2 bytes overhead)
-	if buf.Allows(FLAG_READ_WORD_INV) && len(trimmedRight) == 1 {
-		return buf.EncodeWordBytes32Inv(trimmedRight)
+	if encoded, t, err := buf.EncodeWordBytes32(asIs); err == nil {
+		add(encoded, t)
 	}
 
-	// We can also use (10 ** N) * X, this uses 2 bytes
-	// it uses 5 bits for the exponent and 11 bits for the mantissa
+	// (10 ** N) * X, uses 5 bits for the exponent and 11 bits for the mantissa
 	pow10fn, pow10fm := isPow10Mantissa(trimmed, 32, 2048)
 	if buf.Allows(FLAG_POW_10_MANTISSA_S) && pow10fn != -1 && pow10fn != 0 && pow10fm != -1 {
-		return []byte{byte(FLAG_POW_10_MANTISSA_S), byte(pow10fn<<3) | byte(pow10fm>>8), byte(pow10fm)}, Stateless, nil
+		add([]byte{byte(FLAG_POW_10_MANTISSA_S), byte(pow10fn<<3) | byte(pow10fm>>8), byte(pow10fm)}, Stateless)
 	}
 
-	// Mirror flag uses 2 bytes, it lets us point to another flag that we had already used before
+	// The word padded to the right, it uses 1 extra byte for the size
+	if buf.Allows(FLAG_READ_WORD_INV) && len(trimmedRight) != 0 {
+		encoded, t, _ := buf.EncodeWordBytes32Inv(trimmedRight)
+		add(encoded, t)
+	}
+
+	// Mirror flag lets us point to another flag that we had already used before
 	// but we need to find a flag that mirrors the data with the padding included!
 	padded32str := string(padded32)
 
@@ -86,7 +181,7 @@ func (buf *Buffer) EncodeWordOptimized(word []byte, saveWord bool) ([]byte, Enco
 		// if it exceeds this value, then we can't mirror it
 		// NOR it can be this pointer itself
 		if usedFlag <= 0xffff && usedFlag != buf.Len() {
-			return []byte{byte(FLAG_MIRROR_FLAG_S), byte(usedFlag >> 8), byte(usedFlag)}, Mirror, nil
+			add([]byte{byte(FLAG_MIRROR_FLAG_S), byte(usedFlag >> 8), byte(usedFlag)}, Mirror)
 		}
 	}
 
@@ -97,27 +192,14 @@ func (buf *Buffer) EncodeWordOptimized(word []byte, saveWord bool) ([]byte, Enco
 	if buf.Allows(FLAG_READ_STORE_FLAG_S) && usedStorageFlag != 0 && usedStorageFlag <= 0xffffff {
 		usedStorageFlag -= 1
 
-		// We can only encode 16 bits for the mirror flag
-		// if it exceeds this value, then we can't mirror it
 		if usedStorageFlag <= 0xffff {
-			return []byte{byte(FLAG_READ_STORE_FLAG_S), byte(usedStorageFlag >> 8), byte(usedStorageFlag)}, Mirror, nil
+			add([]byte{byte(FLAG_READ_STORE_FLAG_S), byte(usedStorageFlag >> 8), byte(usedStorageFlag)}, Mirror)
 		} else {
-			return []byte{byte(FLAG_READ_STORE_FLAG_L), byte(usedStorageFlag >> 16), byte(usedStorageFlag >> 8), byte(usedStorageFlag)}, Mirror, nil
+			add([]byte{byte(FLAG_READ_STORE_FLAG_L), byte(usedStorageFlag >> 16), byte(usedStorageFlag >> 8), byte(usedStorageFlag)}, Mirror)
 		}
 	}
 
-	// Now any 3 byte word can be encoded as-is, all the other
-	// methods use more than 3 bytes
-	if buf.Allows(FLAG_READ_WORD_1) && len(trimmed) <= 3 {
-		return buf.EncodeWordBytes32(trimmed)
-	}
-
-	// We can do the same for any 2 byte word that is padded right
-	if buf.Allows(FLAG_READ_WORD_INV) && len(trimmedRight) <= 2 {
-		return buf.EncodeWordBytes32Inv(trimmedRight)
-	}
-
-	// With 3 bytes we can encode 10 ** N * X (with a mantissa of 18 bits and an exp of 6 bits)
+	// (10 ** N) * X with a mantissa of 18 bits and an exp of 6 bits
 	pow10fn, pow10fm = isPow10Mantissa(trimmed, 63, 262143)
 	if buf.Allows(FLAG_POW_10_MANTISSA_L) && pow10fn != -1 && pow10fn != 0 && pow10fm != -1 {
 		// The first byte is 6 bits of exp and 2 bits of mantissa
@@ -126,113 +208,83 @@ func (buf *Buffer) EncodeWordOptimized(word []byte, saveWord bool) ([]byte, Enco
 		b2 := byte(pow10fm >> 8)
 		b3 := byte(pow10fm)
 
-		return []byte{byte(FLAG_POW_10_MANTISSA_L), byte(b1), byte(b2), byte(b3)}, Stateless, nil
+		add([]byte{byte(FLAG_POW_10_MANTISSA_L), byte(b1), byte(b2), byte(b3)}, Stateless)
 	}
 
-	// With 3 bytes we can also copy any other word from the calldata
+	// We can also copy any other word from the calldata
 	// this can be anything but notice: we must copy the value already padded
 	copyIndex := buf.FindPastData(padded32)
-	if buf.Allows(FLAG_COPY_CALLDATA_S) && copyIndex != -1 && copyIndex <= 0xffffff {
-		if copyIndex <= 0xffff {
-			return []byte{byte(FLAG_COPY_CALLDATA_S), byte(copyIndex >> 8), byte(copyIndex), byte(0x20)}, Stateless, nil
-		} else {
-			return []byte{byte(FLAG_COPY_CALLDATA_L), byte(copyIndex >> 16), byte(copyIndex >> 8), byte(copyIndex), byte(0x20)}, Stateless, nil
+	if copyIndex != -1 && copyIndex <= 0xffffff {
+		if buf.Allows(FLAG_COPY_CALLDATA_S) && copyIndex <= 0xffff {
+			add([]byte{byte(FLAG_COPY_CALLDATA_S), byte(copyIndex >> 8), byte(copyIndex), byte(0x20)}, Stateless)
+		} else if buf.Allows(FLAG_COPY_CALLDATA_L) {
+			add([]byte{byte(FLAG_COPY_CALLDATA_L), byte(copyIndex >> 16), byte(copyIndex >> 8), byte(copyIndex), byte(0x20)}, Stateless)
 		}
 	}
 
 	// Contract storage is only enabled on some networks
 	// in most of L1s is cheaper to provide the data from calldata
 	// rather than reading it from storage, let alone writing it
-	if buf.Refs.useContractStorage {
-		// If the data is already on storage, we can look it up
-		// on the addresses or bytes32 repositories
-		addressIndex := buf.Refs.Indexes.AddressIndexes[padded32str]
-		if addressIndex != 0 {
-			// There are 4 different flags for reading addresses,
-			// depending if the index fits on 2, 3, or 4 bytes
-			bytesNeeded := minBytesToRepresent(addressIndex)
-			switch bytesNeeded {
-			case 1, 2:
-				return []byte{
-					byte(FLAG_READ_ADDRESS_2),
-					byte(addressIndex >> 8),
-					byte(addressIndex),
-				}, ReadStorage, nil
-			case 3:
-				return []byte{
-					byte(FLAG_READ_ADDRESS_3),
-					byte(addressIndex >> 16),
-					byte(addressIndex >> 8),
-					byte(addressIndex),
-				}, ReadStorage, nil
-			case 4:
-				return []byte{
-					byte(FLAG_READ_ADDRESS_4),
-					byte(addressIndex >> 24),
-					byte(addressIndex >> 16),
-					byte(addressIndex >> 8),
-					byte(addressIndex),
-				}, ReadStorage, nil
-			}
+	if !buf.Refs.useContractStorage {
+		return candidates, saves
+	}
+
+	// If the data is already on storage, we can look it up
+	// on the addresses or bytes32 repositories, there are 3 different
+	// flags for each, depending if the index fits on 2, 3, or 4 bytes
+	addressIndex := buf.Refs.Indexes.AddressIndexes[padded32str]
+	if addressIndex != 0 {
+		if encoded := encodeStorageRead(FLAG_READ_ADDRESS_2, addressIndex); encoded != nil && buf.Allows(uint(encoded[0])) {
+			add(encoded, ReadStorage)
 		}
+	}
 
-		bytes32Index := buf.Refs.Indexes.Bytes32Indexes[padded32str]
-		if bytes32Index != 0 {
-			// There are 4 different flags for reading bytes32,
-			// depending if the index fits on 2, 3, or 4 bytes
-			bytesNeeded := minBytesToRepresent(bytes32Index)
-			switch bytesNeeded {
-			case 1, 2:
-				return []byte{
-					byte(FLAG_READ_BYTES32_2),
-					byte(bytes32Index >> 8),
-					byte(bytes32Index),
-				}, ReadStorage, nil
-			case 3:
-				return []byte{
-					byte(FLAG_READ_BYTES32_3),
-					byte(bytes32Index >> 16),
-					byte(bytes32Index >> 8),
-					byte(bytes32Index),
-				}, ReadStorage, nil
-			case 4:
-				return []byte{
-					byte(FLAG_READ_BYTES32_4),
-					byte(bytes32Index >> 24),
-					byte(bytes32Index >> 16),
-					byte(bytes32Index >> 8),
-					byte(bytes32Index),
-				}, ReadStorage, nil
-			}
+	bytes32Index := buf.Refs.Indexes.Bytes32Indexes[padded32str]
+	if bytes32Index != 0 {
+		if encoded := encodeStorageRead(FLAG_READ_BYTES32_2, bytes32Index); encoded != nil && buf.Allows(uint(encoded[0])) {
+			add(encoded, ReadStorage)
 		}
+	}
 
-		if saveWord {
-			// Any value smaller than 20 bytes can be saved as an address
-			// ALL saved values must be padded to either 20 bytes or 32 bytes
-			// For both cases skip values that are too short already
-			if buf.Allows(FLAG_SAVE_ADDRESS) && len(trimmed) <= 20 && len(trimmed) >= 15 {
-				padded20 := make([]byte, 20)
-				copy(padded20[20-len(trimmed):], trimmed)
-				encoded := []byte{byte(FLAG_SAVE_ADDRESS)}
-				encoded = append(encoded, padded20...)
-				return encoded, WriteStorage, nil
-
-			} else if buf.Allows(FLAG_SAVE_BYTES32) && len(trimmed) >= 27 {
-				encoded := []byte{byte(FLAG_SAVE_BYTES32)}
-				encoded = append(encoded, padded32...)
-				return encoded, WriteStorage, nil
-			}
+	if saveWord {
+		// Any value smaller than 20 bytes can be saved as an address
+		// ALL saved values must be padded to either 20 bytes or 32 bytes
+		// For both cases skip values that are too short already
+		if buf.Allows(FLAG_SAVE_ADDRESS) && len(trimmed) <= 20 && len(trimmed) >= 15 {
+			padded20 := make([]byte, 20)
+			copy(padded20[20-len(trimmed):], trimmed)
+			encoded := []byte{byte(FLAG_SAVE_ADDRESS)}
+			encoded = append(encoded, padded20...)
+			saves = append(saves, &wordCandidate{encoded, WriteStorage})
+
+		} else if buf.Allows(FLAG_SAVE_BYTES32) && len(trimmed) >= 27 {
+			encoded := []byte{byte(FLAG_SAVE_BYTES32)}
+			encoded = append(encoded, padded32...)
+			saves = append(saves, &wordCandidate{encoded, WriteStorage})
 		}
 	}
 
-	// If the right padding is shorter than the left padding, then we can use the
-	// inverse padding flag, this uses an extra byte, so we need to account for that
-	if buf.Allows(FLAG_READ_WORD_INV) && len(trimmedRight) < len(trimmed)-1 {
-		return buf.EncodeWordBytes32Inv(trimmedRight)
+	return candidates, saves
+}
+
+// Encodes a read of an address or bytes32 index, flag must be the _2 variant,
+// the _3 and _4 variants follow it. Returns nil if the index needs more than 4 bytes.
+func encodeStorageRead(flag uint, index uint) []byte {
+	size := minBytesToRepresent(index)
+	if size > 4 {
+		return nil
 	}
 
-	// We are out of options now, we need to encode the word as-is
-	return buf.EncodeWordBytes32(trimmed)
+	if size < 2 {
+		size = 2
+	}
+
+	encoded := []byte{byte(flag + size - 2)}
+	for i := int(size) - 1; i >= 0; i-- {
+		encoded = append(encoded, byte(index>>(8*uint(i))))
+	}
+
+	return encoded
 }
 
 // Encodes a 32 word, without any optimizations
@@ -245,13 +297,21 @@ func (buf *Buffer) EncodeWordBytes32(word []byte) ([]byte, EncodeType, error) {
 		return nil, Stateless, fmt.Errorf("word is empty")
 	}
 
-	if !buf.Allows(FLAG_READ_WORD_1) {
-		return nil, Stateless, fmt.Errorf("bytes32 encoding is not allowed")
+	// Each size has its own flag, if the one of the word is not allowed
+	// it is padded with zeros to the next size that is
+	for size := len(word); size <= 32; size++ {
+		flag := FLAG_READ_WORD_1 + uint(size) - 1
+		if !buf.Allows(flag) {
+			continue
+		}
+
+		encodedWord := []byte{byte(flag)}
+		encodedWord = append(encodedWord, make([]byte, size-len(word))...)
+		encodedWord = append(encodedWord, word...)
+		return encodedWord, Stateless, nil
 	}
 
-	encodedWord := []byte{byte(FLAG_READ_WORD_1 + uint(len(word)) - 1)}
-	encodedWord = append(encodedWord, word...)
-	return encodedWord, Stateless, nil
+	return nil, Stateless, fmt.Errorf("bytes32 encoding is not allowed for %d bytes", len(word))
 }
 
 func (buf *Buffer) EncodeWordBytes32Inv(word []byte) ([]byte, EncodeType, error) {
@@ -284,10 +344,10 @@ func (buf *Buffer) WriteNWords(words []byte) (EncodeType, error) {
 	}
 
 	if count <= 255 {
-		buf.commitUint(FLAG_NESTED_N_FLAGS_S)
+		buf.commitFlag(FLAG_NESTED_N_FLAGS_S)
 		buf.commitByte(byte(count))
 	} else if count <= 65535 {
-		buf.commitUint(FLAG_NESTED_N_FLAGS_L)
+		buf.commitFlag(FLAG_NESTED_N_FLAGS_L)
 		buf.commitByte(byte(count >> 8))
 		buf.commitByte(byte(count))
 	} else {
@@ -311,14 +371,23 @@ func (buf *Buffer) WriteNWords(words []byte) (EncodeType, error) {
 
 // Encodes and writes a word to the buffer
 func (buf *Buffer) WriteWord(word []byte, useStorage bool) (EncodeType, error) {
-	encoded, t, err := buf.EncodeWordOptimized(word, useStorage)
+	best, cost, err := buf.encodeWordOptimized(word, useStorage)
 	if err != nil {
 		return Stateless, err
 	}
 
+	encoded, t := best.encoded, best.t
+
+	// Saves cost more than they are priced, the difference
+	// is recovered later and it is not counted when comparing blobs
+	if total := buf.wordCost(encoded, t); total > cost {
+		buf.Refs.saveCredit += total - cost
+	}
+
 	paddedWord := make([]byte, 32)
 	copy(paddedWord[32-len(word):], word)
 
+	buf.Refs.gas += buf.wordCost(encoded, t) - buf.costModel().CalldataCost(encoded)
 	buf.commitBytes(encoded)
 	buf.end(paddedWord, t)
 
@@ -331,7 +400,8 @@ func (buf *Buffer) WriteNBytesRaw(bytes []byte) (EncodeType, error) {
 		return Stateless, fmt.Errorf("n bytes encoding is not allowed")
 	}
 
-	buf.commitUint(FLAG_READ_N_BYTES)
+	mark := buf.statsMark()
+	buf.commitFlag(FLAG_READ_N_BYTES)
 	buf.end(bytes, Stateless)
 
 	t, err := buf.WriteWord(uintToBytes(uint64(len(bytes))), false)
@@ -339,11 +409,17 @@ func (buf *Buffer) WriteNBytesRaw(bytes []byte) (EncodeType, error) {
 		return Stateless, err
 	}
 
+	// The size is read by the flag, and the data blob is part of the flag
+	buf.consumedStats(mark + 1)
+	if mark < buf.statsMark() {
+		buf.Refs.stats[mark].Out += len(bytes)
+	}
+
 	buf.commitBytes(bytes)
 
 	// end this last write without creating a flag pointer
 	// this is a data blob, not a flag
-	buf.end([]byte{}, t)
+	buf.endData(t)
 
 	return t, nil
 }
@@ -352,9 +428,12 @@ func (buf *Buffer) Encode4Bytes(bytes []byte) []byte {
 	// If Bytes4Indexes has this value, then we can just use the index
 	index := buf.Refs.Indexes.Bytes4Indexes[string(bytes)]
 	if index != 0 {
+		buf.Refs.selectorHits++
 		return []byte{byte(index)}
 	}
 
+	buf.Refs.selectorMisses++
+
 	// If don't then we need to provide it as-is, but it has to be prefixed with 0x00
 	return append([]byte{0x00}, bytes...)
 }
@@ -393,7 +472,7 @@ func (buf *Buffer) WriteSequenceTransactions(txs sequence.Transactions) (EncodeT
 
 	// The first byte is the number of transactions
 	buf.commitUint(uint(len(txs)))
-	buf.end([]byte{}, Stateless)
+	buf.endData(Stateless)
 
 	encodeType := Stateless
 
@@ -446,7 +525,7 @@ func (buf *Buffer) WriteSequenceTransaction(tx *sequence.Transaction) (EncodeTyp
 	}
 
 	buf.commitByte(flag)
-	buf.end([]byte{}, Stateless)
+	buf.endData(Stateless)
 
 	encodeType := Stateless
 
@@ -499,13 +578,13 @@ func (buf *Buffer) WriteSequenceTransaction(tx *sequence.Transaction) (EncodeTyp
 }
 
 func (buf *Buffer) WriteSequenceExecuteFlag(transaction *sequence.Transaction) (EncodeType, error) {
-	buf.commitUint(FLAG_SEQUENCE_EXECUTE)
+	buf.commitFlag(FLAG_SEQUENCE_EXECUTE)
 	buf.end([]byte{}, Stateless)
 	return buf.WriteSequenceExecute(nil, transaction)
 }
 
 func (buf *Buffer) WriteSequenceSelfExecuteFlag(transaction *sequence.Transaction) (EncodeType, error) {
-	buf.commitUint(FLAG_SEQUENCE_SELF_EXECUTE)
+	buf.commitFlag(FLAG_SEQUENCE_SELF_EXECUTE)
 	buf.end([]byte{}, Stateless)
 	return buf.WriteSequenceTransactions(transaction.Transactions)
 }
@@ -540,6 +619,39 @@ func (buf *Buffer) WriteSequenceExecute(to []byte, transaction *sequence.Transac
 	return maxPriority(t, tt), nil
 }
 
+// Writes many Sequence transactions, each one for its own wallet, used by
+// METHOD_EXECUTE_SEQUENCE_N_TXS and METHOD_DECODE_SEQUENCE_N_TXS
+func (buf *Buffer) WriteSequenceExecutes(wallets [][]byte, transactions []*sequence.Transaction) (EncodeType, error) {
+	if len(wallets) == 0 {
+		return Stateless, fmt.Errorf("transactions are empty")
+	}
+
+	if len(wallets) > 255 {
+		return Stateless, fmt.Errorf("transactions exceeds 255")
+	}
+
+	if len(wallets) != len(transactions) {
+		return Stateless, fmt.Errorf("wallets and transactions have different lengths")
+	}
+
+	// The first byte is the number of transactions
+	buf.commitUint(uint(len(wallets)))
+	buf.endData(Stateless)
+
+	encodeType := Stateless
+
+	for i, wallet := range wallets {
+		t, err := buf.WriteSequenceExecute(wallet, transactions[i])
+		if err != nil {
+			return Stateless, err
+		}
+
+		encodeType = maxPriority(encodeType, t)
+	}
+
+	return encodeType, nil
+}
+
 func (buf *Buffer) WriteSequenceSignature(signature []byte, mayUseBytes bool) (EncodeType, error) {
 	// First byte determines the signature type
 	if mayUseBytes && (len(signature) == 0 || !buf.Refs.useContractStorage) {
@@ -547,6 +659,10 @@ func (buf *Buffer) WriteSequenceSignature(signature []byte, mayUseBytes bool) (E
 		return buf.WriteBytesOptimized(signature, false)
 	}
 
+	if len(signature) == 0 {
+		return Stateless, fmt.Errorf("signature is empty")
+	}
+
 	typeByte := signature[0]
 
 	switch typeByte {
@@ -587,7 +703,7 @@ func (buf *Buffer) WriteSequenceSignatureBody(noChain bool, body []byte) (Encode
 		tflag = FLAG_SEQUENCE_L_SIG_NO_CHAIN
 	}
 
-	buf.commitUint(tflag)
+	buf.commitFlag(tflag)
 
 	// On long threshold we use 2 bytes for the threshold
 	if longThreshold {
@@ -596,6 +712,8 @@ func (buf *Buffer) WriteSequenceSignatureBody(noChain bool, body []byte) (Encode
 		buf.commitByte(body[1])
 	}
 
+	start := buf.Len()
+	mark := buf.statsMark()
 	buf.end(body, Stateless)
 
 	// Next 4 bytes is the checkpoint
@@ -606,7 +724,10 @@ func (buf *Buffer) WriteSequenceSignatureBody(noChain bool, body []byte) (Encode
 	checkpoint := body[2:6]
 	buf.WriteWord(checkpoint, false)
 
-	return buf.WriteSequenceSignatureTree(body[6:])
+	t, err := buf.WriteSequenceSignatureTree(body[6:])
+	buf.forgetStorageWrites(body, start, t)
+	buf.nestedStats(mark)
+	return t, err
 }
 
 func (buf *Buffer) WriteSequenceSignatureTree(tree []byte) (EncodeType, error) {
@@ -619,6 +740,14 @@ func (buf *Buffer) WriteSequenceSignatureTree(tree []byte) (EncodeType, error) {
 	totalParts := 0
 	pointer := uint(0)
 
+	// The size of some parts is encoded using 3 bytes
+	readLength := func(p uint) (uint, error) {
+		if p+3 > uint(len(tree)) {
+			return 0, fmt.Errorf("signature part length out of bounds")
+		}
+		return uint(tree[p])<<16 | uint(tree[p+1])<<8 | uint(tree[p+2]), nil
+	}
+
 	for pointer < uint(len(tree)) {
 		partType := tree[pointer]
 		pointer += 1
@@ -631,20 +760,29 @@ func (buf *Buffer) WriteSequenceSignatureTree(tree []byte) (EncodeType, error) {
 		case 0x02: // Dynamic
 			pointer += (1 + 20)
 			// 3 bytes after address and weight are the length
-			length := uint(tree[pointer])<<16 | uint(tree[pointer+1])<<8 | uint(tree[pointer+2])
+			length, err := readLength(pointer)
+			if err != nil {
+				return Stateless, err
+			}
 			pointer += (3 + length)
 		case 0x03: // Node
 			pointer += 32
 		case 0x04: // Branch
 			// 3 bytes of length
-			length := uint(tree[pointer])<<16 | uint(tree[pointer+1])<<8 | uint(tree[pointer+2])
+			length, err := readLength(pointer)
+			if err != nil {
+				return Stateless, err
+			}
 			pointer += (3 + length)
 		case 0x05: // Subdigest
 			pointer += 32
 		case 0x06: // Nested
 			pointer += 3
 			// 3 bytes of length
-			length := uint(tree[pointer])<<16 | uint(tree[pointer+1])<<8 | uint(tree[pointer+2])
+			length, err := readLength(pointer)
+			if err != nil {
+				return Stateless, err
+			}
 			pointer += (3 + length)
 		default:
 			return Stateless, fmt.Errorf("invalid signature part type %d", partType)
@@ -653,13 +791,17 @@ func (buf *Buffer) WriteSequenceSignatureTree(tree []byte) (EncodeType, error) {
 		totalParts += 1
 	}
 
+	if pointer != uint(len(tree)) {
+		return Stateless, fmt.Errorf("signature part exceeds the signature tree")
+	}
+
 	if totalParts > 1 {
 		if totalParts > 255 {
-			buf.commitUint(FLAG_NESTED_N_FLAGS_L)
+			buf.commitFlag(FLAG_NESTED_N_FLAGS_L)
 			buf.commitByte(byte(totalParts >> 8))
 			buf.commitByte(byte(totalParts))
 		} else {
-			buf.commitUint(FLAG_NESTED_N_FLAGS_S)
+			buf.commitFlag(FLAG_NESTED_N_FLAGS_S)
 			buf.commitByte(byte(totalParts))
 		}
 	}
@@ -749,7 +891,7 @@ func (buf *Buffer) WriteSequenceNestedSignaturePart(weight uint, threshold uint,
 		return Stateless, fmt.Errorf("threshold exceeds 255")
 	}
 
-	buf.commitUint(FLAG_SEQUENCE_NESTED)
+	buf.commitFlag(FLAG_SEQUENCE_NESTED)
 	buf.commitUint(weight)
 	buf.commitUint(threshold)
 	buf.end([]byte{}, Stateless)
@@ -762,7 +904,7 @@ func (buf *Buffer) WriteSequenceBranchSignaturePart(branch []byte) (EncodeType,
 		return Stateless, fmt.Errorf("branch is empty")
 	}
 
-	buf.commitUint(FLAG_SEQUENCE_BRANCH)
+	buf.commitFlag(FLAG_SEQUENCE_BRANCH)
 	buf.end([]byte{}, Stateless)
 
 	return buf.WriteSequenceSignatureTree(branch)
@@ -773,13 +915,13 @@ func (buf *Buffer) WriteSequenceDynamicSignaturePart(address []byte, weight uint
 		return Stateless, fmt.Errorf("weight exceeds 255")
 	}
 
-	if signature[len(signature)-1] != 0x03 {
+	if len(signature) == 0 || signature[len(signature)-1] != 0x03 {
 		return Stateless, fmt.Errorf("signature is not a dynamic signature")
 	}
 
 	unsuffixed := signature[:len(signature)-1]
 
-	buf.commitUint(FLAG_SEQUENCE_DYNAMIC_SIGNATURE)
+	buf.commitFlag(FLAG_SEQUENCE_DYNAMIC_SIGNATURE)
 	buf.commitUint(weight)
 	buf.end([]byte{}, Stateless)
 
@@ -812,10 +954,18 @@ func (buf *Buffer) WriteSequenceChainedSignature(signature []byte) (EncodeType,
 	var parts [][]byte
 
 	for pointer < uint(len(signature)) {
+		if pointer+3 > uint(len(signature)) {
+			return Stateless, fmt.Errorf("chained signature length out of bounds")
+		}
+
 		length := uint(signature[pointer])<<16 | uint(signature[pointer+1])<<8 | uint(signature[pointer+2])
 		pointer += 3
 
 		npointer := pointer + length
+		if npointer > uint(len(signature)) || length == 0 {
+			return Stateless, fmt.Errorf("chained signature part exceeds the signature")
+		}
+
 		parts = append(parts, signature[pointer:npointer])
 		pointer = npointer
 	}
@@ -824,11 +974,11 @@ func (buf *Buffer) WriteSequenceChainedSignature(signature []byte) (EncodeType,
 	// depending on the number of parts
 	totalParts := uint(len(parts))
 	if totalParts > 255 {
-		buf.commitUint(FLAG_SEQUENCE_READ_CHAINED_L)
+		buf.commitFlag(FLAG_SEQUENCE_READ_CHAINED_L)
 		buf.commitByte(byte(totalParts >> 8))
 		buf.commitByte(byte(totalParts))
 	} else {
-		buf.commitUint(FLAG_SEQUENCE_READ_CHAINED_S)
+		buf.commitFlag(FLAG_SEQUENCE_READ_CHAINED_S)
 		buf.commitByte(byte(totalParts))
 	}
 
@@ -849,12 +999,26 @@ func (buf *Buffer) WriteSequenceChainedSignature(signature []byte) (EncodeType,
 	return encodeType, nil
 }
 
+// The flag of a Sequence signature part with the given size and type, weights 1 to 4
+// have their own flag, any other weight (or a disallowed flag) uses the W0 flag
+func (buf *Buffer) sequenceWeightFlag(w0 uint, data []byte, size int, kind byte) (uint, bool) {
+	if len(data) != size || data[0] != kind {
+		return 0, false
+	}
+
+	if weight := uint(data[1]); weight >= 1 && weight <= 4 && buf.Allows(w0+weight) {
+		return w0 + weight, true
+	}
+
+	return w0, buf.Allows(w0)
+}
+
 // Encode N bytes, as optimized as possible
 func (buf *Buffer) WriteBytesOptimized(bytes []byte, saveWord bool) (EncodeType, error) {
 	// Empty bytes can be represented with a no-op
 	// cost: 0
 	if buf.Allows(FLAG_NO_OP) && len(bytes) == 0 {
-		buf.commitUint(FLAG_NO_OP)
+		buf.commitFlag(FLAG_NO_OP)
 		buf.end(bytes, Stateless)
 		return Stateless, nil
 	}
@@ -867,188 +1031,309 @@ func (buf *Buffer) WriteBytesOptimized(bytes []byte, saveWord bool) (EncodeType,
 
 	// If all zeros it can be represented using the write-zeros flag
 	if buf.Allows(FLAG_WRITE_ZEROS) && len(bytes) <= 255 && bytesAreZero(bytes) {
-		buf.commitUint(FLAG_WRITE_ZEROS)
+		buf.commitFlag(FLAG_WRITE_ZEROS)
 		buf.commitByte(byte(len(bytes)))
 		buf.end(bytes, Stateless)
 		return Stateless, nil
 	}
 
+	// All other encodings are tried one by one, the cheapest one is kept
+	var candidates []func() (EncodeType, error)
+
 	// Now we can try to find a mirror flag for the bytes
 	// cost: 2 bytes
 	bytesStr := string(bytes)
 	usedFlag := buf.Refs.usedFlags[bytesStr]
-	if buf.Allows(FLAG_MIRROR_FLAG_S) && usedFlag != 0 {
-		usedFlag -= 1
-
-		// We can only encode 16 bits for the mirror flag
-		// if it exceeds this value, then we can't mirror it
-		if usedFlag <= 0xffff {
-			buf.commitUint(FLAG_MIRROR_FLAG_S)
-			buf.commitBytes([]byte{byte(usedFlag >> 8), byte(usedFlag)})
+	if buf.Allows(FLAG_MIRROR_FLAG_S) && usedFlag != 0 && usedFlag-1 <= 0xffff {
+		candidates = append(candidates, func() (EncodeType, error) {
+			buf.commitFlag(FLAG_MIRROR_FLAG_S)
+			buf.commitBytes([]byte{byte((usedFlag - 1) >> 8), byte(usedFlag - 1)})
 			// end without creating a second pointer
 			// otherwise we will be creating a pointer to a pointer
-			buf.end([]byte{}, Mirror)
+			buf.endWith([]byte{}, len(bytes), Mirror)
 			return Mirror, nil
-		}
+		})
 	}
 
 	// Another optimization is to copy the bytes from the calldata
 	// cost: 3 bytes
 	copyIndex := buf.FindPastData(bytes)
-	if buf.Allows(FLAG_COPY_CALLDATA_S) && copyIndex != -1 && copyIndex <= 0xffffff && len(bytes) <= 0xffff {
-		if len(bytes) <= 0xff {
-			if copyIndex <= 0xffff {
-				buf.commitUint(FLAG_COPY_CALLDATA_S)
-				buf.commitBytes([]byte{byte(copyIndex >> 8), byte(copyIndex), byte(len(bytes))})
-				buf.end([]byte{}, Stateless)
+	if copyIndex != -1 {
+		if encoded := encodeCopyCalldata(uint(copyIndex), uint(len(bytes))); encoded != nil && buf.Allows(uint(encoded[0])) {
+			candidates = append(candidates, func() (EncodeType, error) {
+				buf.commitFlag(uint(encoded[0]))
+				buf.commitBytes(encoded[1:])
+				buf.endWith([]byte{}, len(bytes), Stateless)
 				return Mirror, nil
-			} else {
-				buf.commitUint(FLAG_COPY_CALLDATA_L)
-				buf.commitBytes([]byte{byte(copyIndex >> 16), byte(copyIndex >> 8), byte(copyIndex), byte(len(bytes))})
-				buf.end([]byte{}, Stateless)
-				return Mirror, nil
-			}
-		} else {
-			buf.commitUint(FLAG_COPY_CALLDATA_XL)
-			buf.commitBytes([]byte{byte(copyIndex >> 16), byte(copyIndex >> 8), byte(copyIndex)})
-			buf.commitBytes([]byte{byte(uint(len(bytes)) >> 8), byte(uint(len(bytes)))})
-			buf.end([]byte{}, Stateless)
-			return Mirror, nil
+			})
 		}
 	}
 
 	// If the bytes are 33 bytes long, and the first byte is 0x03 it can be represented as a "node"
 	// cost: 0 bytes + word
 	if buf.Allows(FLAG_SEQUENCE_NODE) && len(bytes) == 33 && bytes[0] == 0x03 {
-		buf.commitUint(FLAG_SEQUENCE_NODE)
-		buf.end(bytes, Stateless)
+		candidates = append(candidates, func() (EncodeType, error) {
+			mark := buf.statsMark()
+			buf.commitFlag(FLAG_SEQUENCE_NODE)
+			buf.end(bytes, Stateless)
 
-		t, err := buf.WriteWord(bytes[1:], saveWord)
-		if err != nil {
-			return Stateless, err
-		}
-
-		return t, nil
+			t, err := buf.WriteWord(bytes[1:], saveWord)
+			buf.nestedStats(mark)
+			return t, err
+		})
 	}
 
 	// If the bytes are 33 bytes long and starts with 0x05 it can be represented as a "subdigest"
 	// cost: 0 bytes + word
 	if buf.Allows(FLAG_SEQUENCE_SUBDIGEST) && len(bytes) == 33 && bytes[0] == 0x05 {
-		buf.commitUint(FLAG_SEQUENCE_SUBDIGEST)
-		buf.end(bytes, Stateless)
-
-		t, err := buf.WriteWord(bytes[1:], saveWord)
-		if err != nil {
-			return Stateless, err
-		}
+		candidates = append(candidates, func() (EncodeType, error) {
+			mark := buf.statsMark()
+			buf.commitFlag(FLAG_SEQUENCE_SUBDIGEST)
+			buf.end(bytes, Stateless)
 
-		return t, nil
+			t, err := buf.WriteWord(bytes[1:], saveWord)
+			buf.nestedStats(mark)
+			return t, err
+		})
 	}
 
 	// If bytes has 22 bytes and starts with 0x01, then it is probably an address on a signature
 	// cost: 1 / 0 bytes + address word
-	if buf.Allows(FLAG_SEQUENCE_ADDRESS_W0) && len(bytes) == 22 && bytes[0] == 0x01 {
-		// If the firt byte (weight) is between 1 and 4, then there is a special flag
-		if bytes[1] >= 1 && bytes[1] <= 4 {
-			buf.commitUint(FLAG_SEQUENCE_ADDRESS_W0 + uint(bytes[1]))
-		} else {
-			// We need to use FLAG_ADDRES_W0 and 1 extra byte for the weight
-			buf.commitUint(FLAG_SEQUENCE_ADDRESS_W0)
-			buf.commitByte(bytes[1])
-		}
-
-		buf.end(bytes, Stateless)
+	if flag, ok := buf.sequenceWeightFlag(FLAG_SEQUENCE_ADDRESS_W0, bytes, 22, 0x01); ok {
+		candidates = append(candidates, func() (EncodeType, error) {
+			mark := buf.statsMark()
+
+			// FLAG_SEQUENCE_ADDRESS_W0 needs 1 extra byte for the weight
+			buf.commitFlag(flag)
+			if flag == FLAG_SEQUENCE_ADDRESS_W0 {
+				buf.commitByte(bytes[1])
+			}
 
-		t, err := buf.WriteWord(bytes[2:], saveWord)
-		if err != nil {
-			return Stateless, err
-		}
+			buf.end(bytes, Stateless)
 
-		return t, nil
+			t, err := buf.WriteWord(bytes[2:], saveWord)
+			buf.nestedStats(mark)
+			return t, err
+		})
 	}
 
 	// If the bytes are 68 bytes long and starts with 0x00, the it is probably a signature for a Sequence wallet
 	// cost: 66/67 bytes
-	if buf.Allows(FLAG_SEQUENCE_SIGNATURE_W0) && len(bytes) == 68 && bytes[0] == 0x00 {
-		// If the first byte (weight) is between 1 and 4, then there is a special flag
-		if bytes[1] >= 1 && bytes[1] <= 4 {
-			buf.commitUint(FLAG_SEQUENCE_SIGNATURE_W0 + uint(bytes[1]))
-		} else {
-			// We need to use FLAG_SEQUENCE_SIGNATURE_W0 and 1 extra byte for the weight
-			buf.commitUint(FLAG_SEQUENCE_SIGNATURE_W0)
-			buf.commitByte(bytes[1])
-		}
+	if flag, ok := buf.sequenceWeightFlag(FLAG_SEQUENCE_SIGNATURE_W0, bytes, 68, 0x00); ok {
+		candidates = append(candidates, func() (EncodeType, error) {
+			// FLAG_SEQUENCE_SIGNATURE_W0 needs 1 extra byte for the weight
+			buf.commitFlag(flag)
+			if flag == FLAG_SEQUENCE_SIGNATURE_W0 {
+				buf.commitByte(bytes[1])
+			}
 
-		buf.commitBytes(bytes[2:])
-		buf.end(bytes, Stateless)
-		return Stateless, nil
+			buf.commitBytes(bytes[2:])
+			buf.end(bytes, Stateless)
+			return Stateless, nil
+		})
 	}
 
 	// We can try encoding this as a signature, we don't know if it is a Sequence signature
-	// we generate a snapshot of the dest buffer and try to encode it as a signature, if it fails
-	// or if the result is bigger than the original bytes, we restore the snapshot and continue.
+	// if it fails the candidate is discarded.
 	// Notice: pass `false` to `mayUseBytes` or else this will be an infinite loop
 	// DO NOT use this method if storage is set to false
 	// it is never worth it if we need to use calldata
 	if buf.Refs.useContractStorage {
-		snapshot := buf.Snapshot()
-		t, err := buf.WriteSequenceSignature(bytes, false)
-		if err == nil && buf.Len() < len(bytes)+3+len(snapshot.Commited) {
-			return t, nil
+		candidates = append(candidates, func() (EncodeType, error) {
+			return buf.WriteSequenceSignature(bytes, false)
+		})
+	}
+
+	// If the bytes are calldata for a method of the ABI, the arguments
+	// can be encoded knowing their types
+	if buf.Refs.ABI != nil && len(bytes) >= 4 {
+		if _, err := buf.Refs.ABI.MethodById(bytes[:4]); err == nil {
+			candidates = append(candidates, func() (EncodeType, error) {
+				return buf.WriteCalldataABI(bytes, buf.Refs.ABI, saveWord)
+			})
 		}
-		buf.Restore(snapshot)
 	}
 
 	// If the bytes are a multiple of 32 + 4 bytes (max 6 * 32 + 4) then it
 	// can be encoded as an ABI call with 0 to 6 parameters
-	if buf.Allows(FLAG_ABI_0_PARAM) && len(bytes) <= 6*32+4 && (len(bytes)-4)%32 == 0 {
-		buf.commitUint(FLAG_ABI_0_PARAM + uint((len(bytes)-4)/32))
-		buf.commitBytes(buf.Encode4Bytes(bytes[:4]))
-		buf.end(bytes, Stateless)
+	if len(bytes) <= 6*32+4 && (len(bytes)-4)%32 == 0 && buf.Allows(FLAG_ABI_0_PARAM+uint((len(bytes)-4)/32)) {
+		candidates = append(candidates, func() (EncodeType, error) {
+			mark := buf.statsMark()
+			buf.commitFlag(FLAG_ABI_0_PARAM + uint((len(bytes)-4)/32))
+			buf.commitBytes(buf.Encode4Bytes(bytes[:4]))
+			buf.end(bytes, Stateless)
+
+			t, err := buf.writeWords(bytes[4:], saveWord)
+			buf.nestedStats(mark)
+			return t, err
+		})
+
+		// If the bytes are a multiple of 32 + 4 bytes (max 256 * 32 + 4) then it
+		// can be represented using dynamic encoded ABI
+	} else if buf.Allows(FLAG_READ_DYNAMIC_ABI) && len(bytes) <= 256*32+4 && (len(bytes)-4)%32 == 0 {
+		candidates = append(candidates, func() (EncodeType, error) {
+			mark := buf.statsMark()
+			buf.commitFlag(FLAG_READ_DYNAMIC_ABI)
+			buf.commitBytes(buf.Encode4Bytes(bytes[:4]))
+			buf.commitUint(uint((len(bytes) - 4) / 32)) // The number of ARGs
+			// This flag can be used to compress dynamic size arguments too
+			// but in this case, we just leave it as 0s so all arguments are 32 bytes
+			buf.commitUint(0)
+			buf.end(bytes, Stateless)
+
+			t, err := buf.writeWords(bytes[4:], saveWord)
+			buf.nestedStats(mark)
+			return t, err
+		})
+	}
+
+	// If the bytes are ABI encoded with bytes or string parameters, the bitmap of
+	// the dynamic ABI flag can be used to skip the pointers and the size words.
+	// cost: 3 bytes + words + each value compressed on its own
+	if buf.Allows(FLAG_READ_DYNAMIC_ABI) {
+		if params, bitmap, ok := parseDynamicABI(bytes); ok {
+			candidates = append(candidates, func() (EncodeType, error) {
+				return buf.writeDynamicABI(bytes, params, bitmap, saveWord)
+			})
+		}
+	}
 
-		encodeType := Stateless
+	// The bytes can always be encoded as-is
+	if buf.Allows(FLAG_READ_N_BYTES) {
+		candidates = append(candidates, func() (EncodeType, error) {
+			return buf.WriteNBytesRaw(bytes)
+		})
+	}
 
-		for i := 4; i < len(bytes); i += 32 {
-			t, err := buf.WriteWord(bytes[i:i+32], saveWord)
-			if err != nil {
-				return Stateless, err
-			}
+	// Or they can be split into many words, zero runs, copies and raw bytes
+	if buf.Allows(FLAG_NESTED_N_FLAGS_S) || buf.Allows(FLAG_NESTED_N_FLAGS_L) {
+		candidates = append(candidates, func() (EncodeType, error) {
+			return buf.WriteBytesSegmented(bytes, saveWord)
+		})
+	}
 
-			encodeType = maxPriority(encodeType, t)
+	if len(candidates) == 0 {
+		return Stateless, fmt.Errorf("no allowed encoding for %d bytes", len(bytes))
+	}
+
+	start := buf.Len()
+	t, err := buf.writeCheapest(candidates)
+	buf.forgetStorageWrites(bytes, start, t)
+	return t, err
+}
+
+// Writes the ABI encoded bytes using the parameters from parseDynamicABI
+func (buf *Buffer) writeDynamicABI(bytes []byte, params []abiParam, bitmap byte, saveWord bool) (EncodeType, error) {
+	mark := buf.statsMark()
+	buf.commitFlag(FLAG_READ_DYNAMIC_ABI)
+	buf.commitBytes(buf.Encode4Bytes(bytes[:4]))
+	buf.commitUint(uint(len(params)))
+	buf.commitByte(bitmap)
+	buf.end(bytes, Stateless)
+
+	t, err := buf.writeABIParams(params, saveWord)
+	buf.nestedStats(mark)
+	return t, err
+}
+
+func (buf *Buffer) writeABIParams(params []abiParam, saveWord bool) (EncodeType, error) {
+	encodeType := Stateless
+
+	for _, param := range params {
+		var t EncodeType
+		var err error
+
+		if param.dynamic {
+			t, err = buf.WriteBytesOptimized(param.data, saveWord)
+		} else {
+			t, err = buf.WriteWord(param.data, saveWord && abiSavesWord(param.typ))
+		}
+
+		if err != nil {
+			return Stateless, err
 		}
 
-		return encodeType, nil
+		encodeType = maxPriority(encodeType, t)
 	}
 
-	// If the bytes are a multiple of 32 + 4 bytes (max 256 * 32 + 4) then it
-	// can be represented using dynamic encoded ABI
-	if buf.Allows(FLAG_READ_DYNAMIC_ABI) && len(bytes) <= 256*32+4 && (len(bytes)-4)%32 == 0 {
-		buf.commitUint(FLAG_READ_DYNAMIC_ABI)
-		buf.commitBytes(buf.Encode4Bytes(bytes[:4]))
-		buf.commitUint(uint((len(bytes) - 4) / 32)) // The number of ARGs
-		// This flag can be used to compress dynamic size arguments too
-		// but in this case, we just leave it as 0s so all arguments are 32 bytes
-		buf.commitUint(0)
-		buf.end(bytes, Stateless)
+	return encodeType, nil
+}
 
-		encodeType := Stateless
+// Writes each candidate on top of the current buffer, and keeps the one that
+// results in the cheapest payload. Ties are resolved in favor of the first candidate.
+func (buf *Buffer) writeCheapest(candidates []func() (EncodeType, error)) (EncodeType, error) {
+	if len(candidates) == 1 {
+		return candidates[0]()
+	}
 
-		for i := 4; i < len(bytes); i += 32 {
-			t, err := buf.WriteWord(bytes[i:i+32], saveWord)
-			if err != nil {
-				return Stateless, err
+	start := buf.Snapshot()
+
+	var best *Snapshot
+	var bestType EncodeType
+	var bestCost uint64
+	var firstErr error
+
+	for _, candidate := range candidates {
+		buf.Restore(start.Copy())
+
+		t, err := candidate()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
 			}
+			continue
+		}
 
-			encodeType = maxPriority(encodeType, t)
+		cost := buf.costModel().CalldataCost(buf.Commited[len(start.Commited):]) + buf.Refs.gas - start.Refs.gas
+		cost -= buf.Refs.saveCredit - start.Refs.saveCredit
+		if best == nil || cost < bestCost {
+			best = &Snapshot{Commited: buf.Commited, Refs: buf.Refs}
+			bestType = t
+			bestCost = cost
+		}
+	}
+
+	if best == nil {
+		buf.Restore(start)
+		return Stateless, firstErr
+	}
+
+	buf.Restore(best)
+	return bestType, nil
+}
+
+// Encodes a copy of size bytes from the calldata, using the smallest flag
+// that fits both values. Returns nil if they are too big.
+func encodeCopyCalldata(index uint, size uint) []byte {
+	if index > 0xffffff || size > 0xffff {
+		return nil
+	}
+
+	if size <= 0xff && index <= 0xffff {
+		return []byte{byte(FLAG_COPY_CALLDATA_S), byte(index >> 8), byte(index), byte(size)}
+	}
+
+	if size <= 0xff {
+		return []byte{byte(FLAG_COPY_CALLDATA_L), byte(index >> 16), byte(index >> 8), byte(index), byte(size)}
+	}
+
+	return []byte{byte(FLAG_COPY_CALLDATA_XL), byte(index >> 16), byte(index >> 8), byte(index), byte(size >> 8), byte(size)}
+}
+
+// Writes a list of 32 bytes words
+func (buf *Buffer) writeWords(words []byte, saveWord bool) (EncodeType, error) {
+	encodeType := Stateless
+
+	for i := 0; i < len(words); i += 32 {
+		t, err := buf.WriteWord(words[i:i+32], saveWord)
+		if err != nil {
+			return Stateless, err
 		}
 
-		return encodeType, nil
+		encodeType = maxPriority(encodeType, t)
 	}
 
-	// If there are no other options, then we encode the bytes as-is
-	// we can try two different methods: bytes_n or splitting it in many words + an extra bytes
-	// for now we leave it as bytes_n for simplicity
-	return buf.WriteNBytesRaw(bytes)
+	return encodeType, nil
 }
 
 func (buf *Buffer) WriteCall(to []byte, data []byte) (EncodeType, error) {
@@ -1080,7 +1365,7 @@ func (buf *Buffer) WriteCalls(tos [][]byte, datas [][]byte) (EncodeType, error)
 
 	// The first byte is the number of calls
 	buf.commitUint(uint(len(tos)))
-	buf.end([]byte{}, Stateless)
+	buf.endData(Stateless)
 
 	encodeType := Stateless
 
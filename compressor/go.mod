@@ -4,7 +4,11 @@ go 1.18
 
 require github.com/0xsequence/ethkit v1.22.1
 
-require github.com/0xsequence/go-sequence v0.25.1
+require (
+	github.com/0xsequence/go-sequence v0.25.1
+	github.com/spf13/cobra v1.8.0
+	github.com/spf13/pflag v1.0.5
+)
 
 require (
 	github.com/0xsequence/go-ethauth v0.13.0 // indirect
@@ -29,8 +33,6 @@ require (
 	github.com/hashicorp/golang-lru/v2 v2.0.5 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/redis/go-redis/v9 v9.0.5 // indirect
-	github.com/spf13/cobra v1.8.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/tyler-smith/go-bip39 v1.1.0 // indirect
 	golang.org/x/crypto v0.14.0 // indirect
 	golang.org/x/exp v0.0.0-20230124195608-d38c7dcee874 // indirect
@@ -0,0 +1,219 @@
+package compressor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+)
+
+// A storage write of a payload that was sent but is not confirmed yet,
+// the index is predicted from the number of values on the contract.
+type PendingWrite struct {
+	Flag  uint
+	Index uint
+	Value []byte
+}
+
+type PendingPayload struct {
+	ID     string
+	Writes []PendingWrite
+}
+
+// Keeps track of the storage writes of the payloads that were sent but are not
+// confirmed yet. The next payloads can read the pending values using their predicted
+// indexes, instead of writing them again. Payloads must be executed in the same order
+// they are recorded, as the contract assigns the indexes in the order of the writes.
+type Ledger struct {
+	mutex sync.Mutex
+
+	// Confirmed indexes, and the number of values on the contract
+	indexes   *Indexes
+	addresses uint
+	bytes32   uint
+
+	pending []*PendingPayload
+}
+
+// Creates a ledger on top of the confirmed indexes, addresses and bytes32
+// are the number of values on the contract (GetTotals returns them plus one).
+func NewLedger(indexes *Indexes, addresses uint, bytes32 uint) *Ledger {
+	return &Ledger{
+		indexes:   copyIndexes(indexes),
+		addresses: addresses,
+		bytes32:   bytes32,
+	}
+}
+
+// Creates a ledger using the current number of values on the contract
+func LoadLedger(ctx context.Context, provider StateReader, contract common.Address, indexes *Indexes) (*Ledger, error) {
+	asize, bsize, err := GetTotals(ctx, provider, contract, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLedger(indexes, asize-1, bsize-1), nil
+}
+
+// Returns the confirmed and the pending indexes, to be used by the next buffer
+func (l *Ledger) Indexes() *Indexes {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	indexes := copyIndexes(l.indexes)
+	for _, payload := range l.pending {
+		addWrites(indexes, payload.Writes)
+	}
+
+	return indexes
+}
+
+// Returns the payloads that are not confirmed yet, in the order they were recorded
+func (l *Ledger) Pending() []*PendingPayload {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return append([]*PendingPayload{}, l.pending...)
+}
+
+// Records the storage writes of a payload that is about to be sent, see Buffer.StorageWrites.
+// The indexes are predicted assuming all the pending payloads are executed before it.
+func (l *Ledger) Record(id string, writes []StorageWrite) (*PendingPayload, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.find(id) != -1 {
+		return nil, fmt.Errorf("payload %s is already pending", id)
+	}
+
+	addresses, bytes32 := l.addresses, l.bytes32
+	for _, payload := range l.pending {
+		for _, write := range payload.Writes {
+			if write.Flag == FLAG_SAVE_ADDRESS {
+				addresses++
+			} else {
+				bytes32++
+			}
+		}
+	}
+
+	payload := &PendingPayload{ID: id}
+
+	for _, write := range writes {
+		var index uint
+
+		switch write.Flag {
+		case FLAG_SAVE_ADDRESS:
+			addresses++
+			index = addresses
+		case FLAG_SAVE_BYTES32:
+			bytes32++
+			index = bytes32
+		default:
+			return nil, fmt.Errorf("invalid storage write flag %d", write.Flag)
+		}
+
+		payload.Writes = append(payload.Writes, PendingWrite{
+			Flag:  write.Flag,
+			Index: index,
+			Value: write.Value,
+		})
+	}
+
+	l.pending = append(l.pending, payload)
+	return payload, nil
+}
+
+// Marks the oldest pending payload as executed, its writes become confirmed indexes
+func (l *Ledger) Commit(id string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	i := l.find(id)
+	if i == -1 {
+		return fmt.Errorf("payload %s is not pending", id)
+	}
+
+	if i != 0 {
+		return fmt.Errorf("payload %s can't be confirmed before %s", id, l.pending[0].ID)
+	}
+
+	payload := l.pending[0]
+	addWrites(l.indexes, payload.Writes)
+
+	for _, write := range payload.Writes {
+		if write.Flag == FLAG_SAVE_ADDRESS {
+			l.addresses++
+		} else {
+			l.bytes32++
+		}
+	}
+
+	l.pending = l.pending[1:]
+	return nil
+}
+
+// Discards a payload that was dropped or reorged. All the payloads recorded after it
+// are discarded too, as their indexes are no longer valid, and they are returned so
+// they can be encoded again.
+func (l *Ledger) Rollback(id string) ([]*PendingPayload, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	i := l.find(id)
+	if i == -1 {
+		return nil, fmt.Errorf("payload %s is not pending", id)
+	}
+
+	discarded := l.pending[i:]
+	l.pending = l.pending[:i:i]
+
+	return discarded, nil
+}
+
+func (l *Ledger) find(id string) int {
+	for i, payload := range l.pending {
+		if payload.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func addWrites(indexes *Indexes, writes []PendingWrite) {
+	for _, write := range writes {
+		if write.Flag == FLAG_SAVE_ADDRESS {
+			indexes.AddressIndexes[string(write.Value)] = write.Index
+		} else {
+			indexes.Bytes32Indexes[string(write.Value)] = write.Index
+		}
+	}
+}
+
+func copyIndexes(indexes *Indexes) *Indexes {
+	next := &Indexes{
+		AddressIndexes: make(map[string]uint),
+		Bytes32Indexes: make(map[string]uint),
+		Bytes4Indexes:  make(map[string]uint),
+	}
+
+	if indexes == nil {
+		return next
+	}
+
+	for k, v := range indexes.AddressIndexes {
+		next.AddressIndexes[k] = v
+	}
+
+	for k, v := range indexes.Bytes32Indexes {
+		next.Bytes32Indexes[k] = v
+	}
+
+	for k, v := range indexes.Bytes4Indexes {
+		next.Bytes4Indexes[k] = v
+	}
+
+	return next
+}
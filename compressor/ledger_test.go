@@ -0,0 +1,243 @@
+package compressor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+)
+
+func saveAddress(b byte) StorageWrite {
+	return StorageWrite{Flag: FLAG_SAVE_ADDRESS, Value: common.LeftPadBytes([]byte{b}, 20)}
+}
+
+func saveBytes32(b byte) StorageWrite {
+	return StorageWrite{Flag: FLAG_SAVE_BYTES32, Value: common.LeftPadBytes([]byte{b}, 32)}
+}
+
+// Steps applied to a ledger, with the indexes predicted for the recorded writes
+type ledgerStep struct {
+	record   string
+	writes   []StorageWrite
+	expected []uint
+
+	commit   string
+	rollback string
+
+	// The ids of the payloads discarded by the rollback
+	discarded []string
+
+	err bool
+}
+
+func TestLedger(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses uint
+		bytes32   uint
+		steps     []ledgerStep
+	}{
+		{
+			name: "empty contract",
+			steps: []ledgerStep{
+				{record: "a", writes: []StorageWrite{saveAddress(1), saveBytes32(1), saveAddress(2)}, expected: []uint{1, 1, 2}},
+			},
+		},
+		{
+			name:      "after the values of the contract",
+			addresses: 10,
+			bytes32:   3,
+			steps: []ledgerStep{
+				{record: "a", writes: []StorageWrite{saveBytes32(1), saveAddress(1)}, expected: []uint{4, 11}},
+			},
+		},
+		{
+			name:      "after the pending payloads",
+			addresses: 10,
+			bytes32:   3,
+			steps: []ledgerStep{
+				{record: "a", writes: []StorageWrite{saveAddress(1), saveAddress(2)}, expected: []uint{11, 12}},
+				{record: "b", writes: nil, expected: nil},
+				{record: "c", writes: []StorageWrite{saveAddress(3), saveBytes32(1)}, expected: []uint{13, 4}},
+			},
+		},
+		{
+			name:      "commits keep the predictions",
+			addresses: 1,
+			bytes32:   1,
+			steps: []ledgerStep{
+				{record: "a", writes: []StorageWrite{saveAddress(1)}, expected: []uint{2}},
+				{record: "b", writes: []StorageWrite{saveAddress(2)}, expected: []uint{3}},
+				{commit: "a"},
+				{record: "c", writes: []StorageWrite{saveAddress(3), saveBytes32(1)}, expected: []uint{4, 2}},
+				{commit: "b"},
+				{commit: "c"},
+				{record: "d", writes: []StorageWrite{saveAddress(4)}, expected: []uint{5}},
+			},
+		},
+		{
+			name: "commits must be in order",
+			steps: []ledgerStep{
+				{record: "a", writes: []StorageWrite{saveAddress(1)}, expected: []uint{1}},
+				{record: "b", writes: []StorageWrite{saveAddress(2)}, expected: []uint{2}},
+				{commit: "b", err: true},
+				{commit: "x", err: true},
+				{commit: "a"},
+				{commit: "a", err: true},
+			},
+		},
+		{
+			name:      "rollback discards the next payloads",
+			addresses: 5,
+			steps: []ledgerStep{
+				{record: "a", writes: []StorageWrite{saveAddress(1)}, expected: []uint{6}},
+				{record: "b", writes: []StorageWrite{saveAddress(2)}, expected: []uint{7}},
+				{record: "c", writes: []StorageWrite{saveAddress(3)}, expected: []uint{8}},
+				{rollback: "b", discarded: []string{"b", "c"}},
+				{record: "c", writes: []StorageWrite{saveAddress(3)}, expected: []uint{7}},
+				{rollback: "x", err: true},
+			},
+		},
+		{
+			name: "rollback of all the payloads",
+			steps: []ledgerStep{
+				{record: "a", writes: []StorageWrite{saveBytes32(1)}, expected: []uint{1}},
+				{rollback: "a", discarded: []string{"a"}},
+				{record: "b", writes: []StorageWrite{saveBytes32(2)}, expected: []uint{1}},
+			},
+		},
+		{
+			name: "invalid records",
+			steps: []ledgerStep{
+				{record: "a", writes: []StorageWrite{saveAddress(1)}, expected: []uint{1}},
+				{record: "a", writes: []StorageWrite{saveAddress(2)}, err: true},
+				{record: "b", writes: []StorageWrite{{Flag: FLAG_READ_WORD_1, Value: []byte{1}}}, err: true},
+				{record: "c", writes: []StorageWrite{saveAddress(2)}, expected: []uint{2}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLedger(nil, tt.addresses, tt.bytes32)
+
+			for i, step := range tt.steps {
+				var err error
+
+				switch {
+				case step.record != "":
+					var payload *PendingPayload
+					payload, err = l.Record(step.record, step.writes)
+					if err == nil {
+						checkPendingWrites(t, i, payload, step.writes, step.expected)
+					}
+
+				case step.commit != "":
+					err = l.Commit(step.commit)
+
+				case step.rollback != "":
+					var discarded []*PendingPayload
+					discarded, err = l.Rollback(step.rollback)
+					if err == nil {
+						var ids []string
+						for _, payload := range discarded {
+							ids = append(ids, payload.ID)
+						}
+
+						if fmt.Sprint(ids) != fmt.Sprint(step.discarded) {
+							t.Fatalf("step %d: discarded %v, expected %v", i, ids, step.discarded)
+						}
+					}
+				}
+
+				if (err != nil) != step.err {
+					t.Fatalf("step %d: error %v, expected error %v", i, err, step.err)
+				}
+			}
+		})
+	}
+}
+
+func checkPendingWrites(t *testing.T, step int, payload *PendingPayload, writes []StorageWrite, expected []uint) {
+	t.Helper()
+
+	if len(payload.Writes) != len(expected) {
+		t.Fatalf("step %d: %d writes, expected %d", step, len(payload.Writes), len(expected))
+	}
+
+	for i, w := range payload.Writes {
+		if w.Index != expected[i] || w.Flag != writes[i].Flag || string(w.Value) != string(writes[i].Value) {
+			t.Fatalf("step %d: write %d is on %d, expected %d", step, i, w.Index, expected[i])
+		}
+	}
+}
+
+func TestLedgerIndexes(t *testing.T) {
+	confirmed := &Indexes{
+		AddressIndexes: map[string]uint{string(saveAddress(9).Value): 1},
+		Bytes32Indexes: map[string]uint{},
+	}
+
+	l := NewLedger(confirmed, 1, 0)
+
+	if _, err := l.Record("a", []StorageWrite{saveAddress(1), saveBytes32(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Record("b", []StorageWrite{saveAddress(2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The confirmed indexes are copied, pending values are not added to them
+	if len(confirmed.AddressIndexes) != 1 {
+		t.Fatalf("the confirmed indexes were modified: %v", confirmed.AddressIndexes)
+	}
+
+	indexes := l.Indexes()
+	expected := map[string]uint{
+		string(saveAddress(9).Value): 1,
+		string(saveAddress(1).Value): 2,
+		string(saveAddress(2).Value): 3,
+	}
+
+	if fmt.Sprint(indexes.AddressIndexes) != fmt.Sprint(expected) {
+		t.Fatalf("address indexes %v, expected %v", indexes.AddressIndexes, expected)
+	}
+
+	if indexes.Bytes32Indexes[string(saveBytes32(1).Value)] != 1 {
+		t.Fatalf("bytes32 indexes %v", indexes.Bytes32Indexes)
+	}
+
+	// Rolled back values are no longer readable
+	if _, err := l.Rollback("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := l.Indexes().AddressIndexes[string(saveAddress(2).Value)]; ok {
+		t.Fatalf("rolled back value is still indexed")
+	}
+
+	if err := l.Commit("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(l.Pending()) != 0 || l.Indexes().AddressIndexes[string(saveAddress(1).Value)] != 2 {
+		t.Fatalf("committed value is not indexed: %v", l.Indexes().AddressIndexes)
+	}
+}
+
+// GetTotals returns the number of values plus one, the ledger must not count it twice
+func TestLoadLedger(t *testing.T) {
+	l, err := LoadLedger(context.Background(), newStubState(7, 0), common.Address{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := l.Record("a", []StorageWrite{saveAddress(1), saveBytes32(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkPendingWrites(t, 0, payload, []StorageWrite{saveAddress(1), saveBytes32(1)}, []uint{8, 1})
+}
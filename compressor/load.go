@@ -0,0 +1,294 @@
+package compressor
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xsequence/ethkit/go-ethereum"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+)
+
+// The calls used to read the state of the decompressor contract,
+// *ethrpc.Provider implements it, and any stub can be used instead.
+type StateReader interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	CodeAt(ctx context.Context, account common.Address, blockNum *big.Int) ([]byte, error)
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNum *big.Int) ([]byte, error)
+}
+
+type LoadOptions struct {
+	// Maximum number of slots read on a single call, it is reduced
+	// automatically if a call hits the gas or the response size limit
+	BatchSize uint
+
+	// Number of calls running at the same time
+	Concurrency uint
+
+	// Number of times a failed call is retried, waiting Backoff before
+	// the first retry, and doubling it after each one
+	Retries uint
+	Backoff time.Duration
+
+	// Called after each batch, with the number of slots read so far
+	Progress func(loaded uint, total uint)
+}
+
+func DefaultLoadOptions() *LoadOptions {
+	return &LoadOptions{
+		BatchSize:   2048,
+		Concurrency: 4,
+		Retries:     3,
+		Backoff:     500 * time.Millisecond,
+	}
+}
+
+func (o *LoadOptions) withDefaults() *LoadOptions {
+	defaults := DefaultLoadOptions()
+	if o == nil {
+		return defaults
+	}
+
+	next := *o
+	if next.BatchSize == 0 {
+		next.BatchSize = defaults.BatchSize
+	}
+
+	if next.Concurrency == 0 {
+		next.Concurrency = defaults.Concurrency
+	}
+
+	// Retries without a wait would hit a rate limited provider again right away
+	if next.Backoff == 0 {
+		next.Backoff = defaults.Backoff
+	}
+
+	return &next
+}
+
+// Reads the slots of the indexes between skip and total, batches are read concurrently
+// and split in half when they are too large for the provider.
+type storageLoader struct {
+	provider  StateReader
+	contract  common.Address
+	block     *big.Int
+	itemplate func(uint) []byte
+	opts      *LoadOptions
+
+	mutex     sync.Mutex
+	batchSize uint
+	loaded    uint
+	total     uint
+	out       map[string]uint
+}
+
+func newStorageLoader(provider StateReader, contract common.Address, block uint64, itemplate func(uint) []byte, opts *LoadOptions) *storageLoader {
+	opts = opts.withDefaults()
+
+	return &storageLoader{
+		provider:  provider,
+		contract:  contract,
+		block:     new(big.Int).SetUint64(block),
+		itemplate: itemplate,
+		opts:      opts,
+		batchSize: opts.BatchSize,
+		out:       make(map[string]uint),
+	}
+}
+
+func (l *storageLoader) load(ctx context.Context, skip uint, total uint) (map[string]uint, error) {
+	if skip >= total {
+		return l.out, nil
+	}
+
+	l.total = total - skip
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Ranges are fetched in the order of the indexes, each worker takes the next one
+	ranges := make(chan [2]uint)
+	go func() {
+		defer close(ranges)
+		for i := skip; i < total; i += l.opts.BatchSize {
+			end := i + l.opts.BatchSize
+			if end > total {
+				end = total
+			}
+
+			select {
+			case ranges <- [2]uint{i, end}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var ferr error
+
+	for w := uint(0); w < l.opts.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range ranges {
+				if err := l.fetch(ctx, r[0], r[1]); err != nil {
+					once.Do(func() {
+						ferr = err
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if ferr != nil {
+		return nil, ferr
+	}
+
+	return l.out, nil
+}
+
+// Fetches the range using batches of the current size, shrinking it if needed
+func (l *storageLoader) fetch(ctx context.Context, from uint, to uint) error {
+	for from < to {
+		end := from + l.currentBatchSize()
+		if end > to {
+			end = to
+		}
+
+		res, err := l.read(ctx, from, end)
+		if err != nil {
+			if isLimitError(err) && end-from > 1 {
+				l.shrink(end - from)
+				continue
+			}
+
+			return fmt.Errorf("reading indexes %d to %d: %w", from, end, err)
+		}
+
+		if err := l.store(res, from, end); err != nil {
+			return err
+		}
+
+		from = end
+	}
+
+	return nil
+}
+
+// Reads a batch, retrying any error that is not caused by the size of the batch
+func (l *storageLoader) read(ctx context.Context, from uint, to uint) ([]byte, error) {
+	return callWithRetries(ctx, l.opts, func() ([]byte, error) {
+		res, err := l.provider.CallContract(ctx, ethereum.CallMsg{
+			To:   &l.contract,
+			Data: append([]byte{byte(METHOD_READ_STORAGE_SLOTS)}, GenBatch(from, to, to-from, l.itemplate)...),
+		}, l.block)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if uint(len(res)) != (to-from)*32 {
+			return nil, fmt.Errorf("invalid result length: expected %d bytes, got %d", (to-from)*32, len(res))
+		}
+
+		return res, nil
+	})
+}
+
+// Progress is reported while holding the lock, so it is never called concurrently
+func (l *storageLoader) store(res []byte, from uint, to uint) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := ParseBatchResult(l.out, res, from); err != nil {
+		return err
+	}
+
+	l.loaded += to - from
+	if l.opts.Progress != nil {
+		l.opts.Progress(l.loaded, l.total)
+	}
+
+	return nil
+}
+
+func (l *storageLoader) currentBatchSize() uint {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.batchSize
+}
+
+// All the next batches use half the size of the one that failed
+func (l *storageLoader) shrink(failed uint) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if next := failed / 2; next < l.batchSize {
+		l.batchSize = next
+	}
+}
+
+func callWithRetries(ctx context.Context, opts *LoadOptions, call func() ([]byte, error)) ([]byte, error) {
+	backoff := opts.Backoff
+
+	for attempt := uint(0); ; attempt++ {
+		res, err := call()
+		if err == nil || isLimitError(err) || attempt >= opts.Retries || ctx.Err() != nil {
+			return res, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		backoff *= 2
+	}
+}
+
+// Errors returned by the providers when a call is too large, these are not
+// retried with the same batch, the batch is split in half instead. Rate limits
+// also say "limit exceeded", they are retried after the backoff.
+var limitErrors = []string{
+	"out of gas",
+	"gas required exceeds",
+	"exceeds block gas limit",
+	"response size",
+	"response too large",
+	"response is too big",
+	"request too large",
+	"request entity too large",
+}
+
+var rateLimitErrors = []string{
+	"rate limit",
+	"too many requests",
+}
+
+func isLimitError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range rateLimitErrors {
+		if strings.Contains(msg, s) {
+			return false
+		}
+	}
+
+	for _, s := range limitErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	return false
+}
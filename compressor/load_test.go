@@ -0,0 +1,345 @@
+package compressor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xsequence/ethkit/go-ethereum"
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+)
+
+// A decompressor contract in memory, it answers the calls used to load the indexes
+type stubState struct {
+	mutex sync.Mutex
+
+	block     uint64
+	addresses [][]byte
+	bytes32   [][]byte
+
+	// Calls that read more slots fail with a response size error
+	maxSlots int
+
+	// Number of calls that fail with failure before they succeed
+	failures int
+	failure  error
+
+	// Each call waits this long, so the calls overlap
+	delay time.Duration
+
+	calls       int
+	inflight    int
+	maxInflight int
+
+	// Number of slots of each successful read
+	reads []int
+}
+
+func newStubState(addresses int, bytes32 int) *stubState {
+	s := &stubState{block: 100}
+
+	for i := 0; i < addresses; i++ {
+		s.addresses = append(s.addresses, common.BigToAddress(big.NewInt(int64(0x1000+i))).Bytes())
+	}
+
+	for i := 0; i < bytes32; i++ {
+		s.bytes32 = append(s.bytes32, common.BigToHash(big.NewInt(int64(0x2000+i))).Bytes())
+	}
+
+	return s
+}
+
+// The indexes the contract has, address i is on slot i + 1 and bytes32 i on its own slot
+func (s *stubState) indexes() (map[string]uint, map[string]uint) {
+	addresses := make(map[string]uint)
+	for i, a := range s.addresses {
+		addresses[string(common.LeftPadBytes(a, 32))] = uint(i + 1)
+	}
+
+	bytes32 := make(map[string]uint)
+	for i, b := range s.bytes32 {
+		bytes32[string(b)] = uint(i + 1)
+	}
+
+	return addresses, bytes32
+}
+
+func (s *stubState) slot(slot []byte) []byte {
+	for i, a := range s.addresses {
+		if string(slot) == string(AddressIndex(uint(i+1))) {
+			return common.LeftPadBytes(a, 32)
+		}
+	}
+
+	for i, b := range s.bytes32 {
+		if string(slot) == string(Bytes32Index(uint(i+1))) {
+			return b
+		}
+	}
+
+	return make([]byte, 32)
+}
+
+func (s *stubState) BlockNumber(ctx context.Context) (uint64, error) {
+	return s.block, nil
+}
+
+func (s *stubState) CodeAt(ctx context.Context, account common.Address, blockNum *big.Int) ([]byte, error) {
+	return []byte{0x01}, nil
+}
+
+func (s *stubState) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNum *big.Int) ([]byte, error) {
+	s.mutex.Lock()
+	s.calls++
+	s.inflight++
+	if s.inflight > s.maxInflight {
+		s.maxInflight = s.inflight
+	}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		s.inflight--
+		s.mutex.Unlock()
+	}()
+
+	time.Sleep(s.delay)
+
+	if len(msg.Data) == 0 {
+		return nil, fmt.Errorf("empty call")
+	}
+
+	switch uint(msg.Data[0]) {
+	case METHOD_READ_SIZES:
+		res := make([]byte, 32)
+		binary.BigEndian.PutUint64(res[8:16], uint64(len(s.addresses)))
+		binary.BigEndian.PutUint64(res[24:32], uint64(len(s.bytes32)))
+		return res, nil
+
+	case METHOD_READ_STORAGE_SLOTS:
+		slots := len(msg.Data[1:]) / 32
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if s.maxSlots != 0 && slots > s.maxSlots {
+			return nil, fmt.Errorf("response size exceeded")
+		}
+
+		if s.failures > 0 {
+			s.failures--
+			return nil, s.failure
+		}
+
+		s.reads = append(s.reads, slots)
+
+		var res []byte
+		for i := 0; i < slots; i++ {
+			res = append(res, s.slot(msg.Data[1+i*32:1+i*32+32])...)
+		}
+
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("unexpected call %x", msg.Data)
+}
+
+func checkLoaded(t *testing.T, name string, loaded map[string]uint, expected map[string]uint) {
+	t.Helper()
+
+	if len(loaded) != len(expected) {
+		t.Fatalf("%d %s loaded, expected %d", len(loaded), name, len(expected))
+	}
+
+	for k, v := range expected {
+		if loaded[k] != v {
+			t.Fatalf("%s %x loaded on %d, expected %d", name, k, loaded[k], v)
+		}
+	}
+}
+
+func TestLoadStateConcurrently(t *testing.T) {
+	s := newStubState(50, 30)
+	s.delay = 2 * time.Millisecond
+
+	opts := &LoadOptions{BatchSize: 4, Concurrency: 3}
+
+	asize, addresses, bsize, bytes32, err := LoadState(context.Background(), s, common.Address{}, opts, 0, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if asize != 51 || bsize != 31 {
+		t.Fatalf("sizes %d and %d, expected 51 and 31", asize, bsize)
+	}
+
+	expectedAddresses, expectedBytes32 := s.indexes()
+	checkLoaded(t, "addresses", addresses, expectedAddresses)
+	checkLoaded(t, "bytes32", bytes32, expectedBytes32)
+
+	if s.maxInflight > 3 {
+		t.Fatalf("%d calls at the same time, expected at most 3", s.maxInflight)
+	}
+
+	if s.maxInflight < 2 {
+		t.Fatalf("the calls were not concurrent")
+	}
+}
+
+func TestLoadStorageShrinksBatch(t *testing.T) {
+	s := newStubState(100, 0)
+	s.maxSlots = 10
+
+	opts := &LoadOptions{BatchSize: 64, Concurrency: 1, Backoff: time.Millisecond}
+
+	_, addresses, err := LoadAddresses(context.Background(), s, common.Address{}, opts, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := s.indexes()
+	checkLoaded(t, "addresses", addresses, expected)
+
+	// 64 is split into 32, 16 and 8, once, and 8 is used for all the next reads
+	for _, n := range s.reads {
+		if n > 8 {
+			t.Fatalf("read %d slots after the batch was shrunk: %v", n, s.reads)
+		}
+	}
+
+	// One call for the sizes, the failed ones are not retried with the same size
+	if expected := 1 + 3 + len(s.reads); s.calls != expected {
+		t.Fatalf("%d calls, expected %d", s.calls, expected)
+	}
+}
+
+func TestLoadStorageRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failure  error
+		failures int
+		retries  uint
+		err      bool
+	}{
+		{name: "transient", failure: errors.New("connection reset by peer"), failures: 2, retries: 3},
+		{name: "rate limit", failure: errors.New("429 Too Many Requests: rate limit exceeded"), failures: 3, retries: 3},
+		{name: "too many failures", failure: errors.New("connection reset by peer"), failures: 3, retries: 2, err: true},
+		{name: "no retries", failure: errors.New("internal error"), failures: 1, retries: 0, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStubState(20, 0)
+			s.failure = tt.failure
+			s.failures = tt.failures
+
+			opts := &LoadOptions{BatchSize: 8, Concurrency: 1, Retries: tt.retries, Backoff: time.Millisecond}
+
+			_, addresses, err := LoadAddresses(context.Background(), s, common.Address{}, opts, 0, 0)
+			if tt.err {
+				if err == nil || !errors.Is(err, tt.failure) {
+					t.Fatalf("error %v, expected %v", err, tt.failure)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected, _ := s.indexes()
+			checkLoaded(t, "addresses", addresses, expected)
+
+			// The failures must not shrink the batch
+			for _, n := range s.reads[:len(s.reads)-1] {
+				if n != 8 {
+					t.Fatalf("reads of %v slots, expected 8", s.reads)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadStorageBacksOff(t *testing.T) {
+	s := newStubState(4, 0)
+	s.failure = errors.New("rate limit exceeded")
+	s.failures = 2
+
+	// Without a backoff the default one is used, 500ms and then 1s
+	start := time.Now()
+	if _, _, err := LoadAddresses(context.Background(), s, common.Address{}, &LoadOptions{Retries: 2}, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Fatalf("retried after %s, expected at least 1.5s", elapsed)
+	}
+}
+
+func TestLoadStorageProgress(t *testing.T) {
+	s := newStubState(0, 45)
+	s.delay = time.Millisecond
+
+	var calls []uint
+	var inside int32
+
+	opts := &LoadOptions{BatchSize: 4, Concurrency: 4, Progress: func(loaded uint, total uint) {
+		if !atomic.CompareAndSwapInt32(&inside, 0, 1) {
+			t.Errorf("progress called concurrently")
+			return
+		}
+
+		defer atomic.StoreInt32(&inside, 0)
+
+		if total != 45 {
+			t.Errorf("total %d, expected 45", total)
+		}
+
+		calls = append(calls, loaded)
+	}}
+
+	if _, _, err := LoadBytes32(context.Background(), s, common.Address{}, opts, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < len(calls); i++ {
+		if calls[i] <= calls[i-1] {
+			t.Fatalf("progress went back: %v", calls)
+		}
+	}
+
+	if len(calls) != 12 || calls[len(calls)-1] != 45 {
+		t.Fatalf("progress %v, expected 12 calls up to 45", calls)
+	}
+}
+
+func TestIsLimitError(t *testing.T) {
+	tests := []struct {
+		err   string
+		limit bool
+	}{
+		{err: "execution reverted: out of gas", limit: true},
+		{err: "gas required exceeds allowance (30000000)", limit: true},
+		{err: "exceeds block gas limit", limit: true},
+		{err: "response size exceeded", limit: true},
+		{err: "Response is too big", limit: true},
+		{err: "413 Request Entity Too Large", limit: true},
+		{err: "rate limit exceeded", limit: false},
+		{err: "daily request limit exceeded, too many requests", limit: false},
+		{err: "429 Too Many Requests", limit: false},
+		{err: "connection reset by peer", limit: false},
+	}
+
+	for _, tt := range tests {
+		if limit := isLimitError(errors.New(tt.err)); limit != tt.limit {
+			t.Errorf("%q is a limit error: %v, expected %v", tt.err, limit, tt.limit)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package compressor
+
+import "bytes"
+
+// Size of the grams used to index the past data, data
+// shorter than this is searched without using the index.
+const gramSize = 4
+
+// Indexes the position of every gram of the commited data, so past data
+// can be found without scanning the whole buffer. Positions are kept in
+// ascending order, so the first match is always the lowest (cheapest) pointer.
+type pastDataIndex struct {
+	grams map[uint32][]int
+
+	// Number of bytes of the commited data that are indexed
+	size int
+}
+
+func newPastDataIndex() *pastDataIndex {
+	return &pastDataIndex{
+		grams: make(map[uint32][]int),
+	}
+}
+
+func gramAt(data []byte, i int) uint32 {
+	return uint32(data[i])<<24 | uint32(data[i+1])<<16 | uint32(data[i+2])<<8 | uint32(data[i+3])
+}
+
+// Indexes all the new grams of data, data must start with
+// the bytes that were already indexed.
+func (idx *pastDataIndex) extend(data []byte) {
+	from := idx.size - gramSize + 1
+	if from < 0 {
+		from = 0
+	}
+
+	for i := from; i+gramSize <= len(data); i++ {
+		g := gramAt(data, i)
+		idx.grams[g] = append(idx.grams[g], i)
+	}
+
+	idx.size = len(data)
+}
+
+// Removes all the grams that don't fit in the first n bytes,
+// data must be the data that is currently indexed.
+func (idx *pastDataIndex) truncate(data []byte, n int) {
+	if n >= idx.size {
+		return
+	}
+
+	from := n - gramSize + 1
+	if from < 0 {
+		from = 0
+	}
+
+	// Positions are removed from the last one, so they
+	// are always at the end of their lists
+	for i := idx.size - gramSize; i >= from; i-- {
+		g := gramAt(data, i)
+		positions := idx.grams[g]
+		if len(positions) == 1 {
+			delete(idx.grams, g)
+		} else {
+			idx.grams[g] = positions[:len(positions)-1]
+		}
+	}
+
+	idx.size = n
+}
+
+// Returns the lowest position of data on the indexed bytes, the match
+// must end before the last byte, or -1 if it can't be found.
+func (idx *pastDataIndex) find(commited []byte, data []byte) int {
+	// Use the gram of data that appears the least times, every
+	// match of data must contain it at the same offset
+	var best []int
+	bestOffset := -1
+
+	for j := 0; j+gramSize <= len(data); j++ {
+		positions, ok := idx.grams[gramAt(data, j)]
+		if !ok {
+			return -1
+		}
+
+		if bestOffset == -1 || len(positions) < len(best) {
+			best = positions
+			bestOffset = j
+		}
+	}
+
+	for _, p := range best {
+		i := p - bestOffset
+		if i < 0 {
+			continue
+		}
+
+		if i+len(data) >= len(commited) {
+			break
+		}
+
+		if bytes.Equal(commited[i:i+len(data)], data) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Returns the longest prefix of data that can be found on the indexed bytes,
+// only the first maxCandidates positions of the first gram are tried.
+func (idx *pastDataIndex) longestMatch(commited []byte, data []byte, maxCandidates int) (int, int) {
+	if len(data) < gramSize {
+		return -1, 0
+	}
+
+	pos, size := -1, 0
+
+	positions := idx.grams[gramAt(data, 0)]
+	for n, p := range positions {
+		if n >= maxCandidates {
+			break
+		}
+
+		l := commonPrefix(commited[p:], data)
+		if l > size {
+			pos, size = p, l
+		}
+
+		if size == len(data) {
+			break
+		}
+	}
+
+	return pos, size
+}
+
+// Brings the index up to date with the commited data, Commited
+// is exported so it may have been changed outside of the buffer.
+func (cb *Buffer) syncPastDataIndex() {
+	if cb.pastData == nil || cb.pastData.size > len(cb.Commited) {
+		cb.pastData = newPastDataIndex()
+	}
+
+	if cb.pastData.size < len(cb.Commited) {
+		cb.pastData.extend(cb.Commited)
+	}
+}
+
+// Returns the length of the common prefix of a and b
+func commonPrefix(a []byte, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+
+	if bytes.Equal(a[:n], b[:n]) {
+		return n
+	}
+
+	// Compare in chunks first, most of the prefix is usually shared
+	const chunk = 64
+
+	i := 0
+	for i+chunk <= n && bytes.Equal(a[i:i+chunk], b[i:i+chunk]) {
+		i += chunk
+	}
+
+	for i < n && a[i] == b[i] {
+		i++
+	}
+
+	return i
+}
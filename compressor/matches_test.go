@@ -0,0 +1,133 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// The scan FindPastData used before the index, the results must be the same
+func linearFindPastData(commited []byte, data []byte) int {
+	for i := 0; i+len(data) < len(commited); i++ {
+		if bytes.Equal(commited[i:i+len(data)], data) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Bytes from a small alphabet, so grams and longer matches repeat often
+func randomData(r *rand.Rand, n int) []byte {
+	res := make([]byte, n)
+	for i := range res {
+		res[i] = byte(r.Intn(4))
+	}
+
+	return res
+}
+
+func commitData(buf *Buffer, data []byte) {
+	buf.commitBytes(data)
+	buf.endData(Stateless)
+}
+
+func TestFindPastDataWithRestore(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	buf := NewBuffer(METHOD_DECODE_ANY, &Indexes{}, nil, false)
+
+	var snaps []*Snapshot
+
+	for step := 0; step < 2000; step++ {
+		switch op := r.Intn(10); {
+		case op < 5:
+			commitData(buf, randomData(r, 1+r.Intn(40)))
+		case op < 7:
+			snaps = append(snaps, buf.Snapshot())
+		case op < 9 && len(snaps) != 0:
+			// Snapshots can be older or newer than the commited data
+			buf.Restore(snaps[r.Intn(len(snaps))].Copy())
+		default:
+			// Commited is exported, it can be replaced outside of the buffer
+			buf.Commited = append([]byte{}, buf.Commited[:r.Intn(len(buf.Commited)+1)]...)
+		}
+
+		for i := 0; i < 8; i++ {
+			var data []byte
+			if len(buf.Commited) != 0 && r.Intn(2) == 0 {
+				from := r.Intn(len(buf.Commited))
+				data = buf.Commited[from : from+r.Intn(len(buf.Commited)-from)+1]
+			} else {
+				data = randomData(r, 1+r.Intn(12))
+			}
+
+			data = append([]byte{}, data...)
+
+			if got, expected := buf.FindPastData(data), linearFindPastData(buf.Commited, data); got != expected {
+				t.Fatalf("step %d: found %x at %d, expected %d", step, data, got, expected)
+			}
+
+			if len(data) < gramSize {
+				continue
+			}
+
+			// The index may not have been created by FindPastData
+			buf.syncPastDataIndex()
+
+			pos, size := buf.pastData.longestMatch(buf.Commited, data, len(buf.Commited))
+			expected := 0
+			for p := 0; p+gramSize <= len(buf.Commited); p++ {
+				if l := commonPrefix(buf.Commited[p:], data); l > expected {
+					expected = l
+				}
+			}
+
+			if expected < gramSize {
+				expected = 0
+			}
+
+			if size != expected || (size != 0 && !bytes.Equal(buf.Commited[pos:pos+size], data[:size])) {
+				t.Fatalf("step %d: longest match of %x is %d bytes at %d, expected %d bytes", step, data, size, pos, expected)
+			}
+		}
+	}
+}
+
+func BenchmarkFindPastData(b *testing.B) {
+	for _, size := range []int{4 << 10, 16 << 10, 64 << 10} {
+		r := rand.New(rand.NewSource(1))
+
+		buf := NewBuffer(METHOD_DECODE_ANY, &Indexes{}, nil, false)
+		commitData(buf, randomBytes(r, size))
+
+		// Half of the lookups are found near the end, the other half are missing
+		lookups := make([][]byte, 64)
+		for i := range lookups {
+			if i%2 == 0 {
+				from := size - 1024 + r.Intn(1024-64)
+				lookups[i] = append([]byte{}, buf.Commited[from:from+32]...)
+			} else {
+				lookups[i] = randomBytes(r, 32)
+			}
+		}
+
+		b.Run(fmt.Sprintf("index/%dKB", size>>10), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				buf.FindPastData(lookups[i%len(lookups)])
+			}
+		})
+
+		b.Run(fmt.Sprintf("linear/%dKB", size>>10), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				linearFindPastData(buf.Commited, lookups[i%len(lookups)])
+			}
+		})
+	}
+}
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	res := make([]byte, n)
+	r.Read(res)
+	return res
+}
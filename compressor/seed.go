@@ -0,0 +1,192 @@
+package compressor
+
+import (
+	"fmt"
+)
+
+// Budget and starting point of the seeding payloads, see SeedPayloads
+type SeedOptions struct {
+	// Number of values on the contract (GetTotals returns them plus one),
+	// the seeded values get the indexes that follow them.
+	Addresses uint
+	Bytes32   uint
+
+	// Maximum size and gas of each payload, 0 for no limit. The gas includes
+	// the calldata and the execution of the decompressor, but not the base
+	// cost of the transaction.
+	MaxBytes int
+	MaxGas   uint64
+
+	// Used to price the gas of the payloads, defaults to L1CostModel
+	CostModel CostModel
+}
+
+// A payload that only saves values on storage, to be sent to the decompressor as is
+type SeedPayload struct {
+	Payload []byte
+	Writes  []PendingWrite
+	Gas     uint64
+}
+
+// Saving more flags would need a bigger nested flag
+const maxSeedsPerPayload = 0xffff
+
+// Builds the payloads that save the values on storage, values are addresses (20 bytes)
+// or bytes32 (32 bytes). Values that are already on the indexes, or repeated, are dropped.
+// Payloads must be executed in order, as the indexes are assigned in the order of the writes.
+func SeedPayloads(values [][]byte, indexes *Indexes, opts *SeedOptions) ([]*SeedPayload, error) {
+	if opts == nil {
+		opts = &SeedOptions{}
+	}
+
+	costModel := opts.CostModel
+	if costModel == nil {
+		costModel = L1CostModel()
+	}
+
+	writes, err := seedWrites(values, indexes, opts.Addresses, opts.Bytes32)
+	if err != nil {
+		return nil, err
+	}
+
+	var payloads []*SeedPayload
+
+	for len(writes) != 0 {
+		// Grows the payload one value at a time, until the next one doesn't fit
+		size, gas := seedWriteCost(writes[0], costModel)
+		n := 1
+		for n < len(writes) && n < maxSeedsPerPayload {
+			wsize, wgas := seedWriteCost(writes[n], costModel)
+			hsize, hgas := seedHeaderCost(n+1, costModel)
+			if (opts.MaxBytes != 0 && hsize+size+wsize > opts.MaxBytes) || (opts.MaxGas != 0 && hgas+gas+wgas > opts.MaxGas) {
+				break
+			}
+
+			size += wsize
+			gas += wgas
+			n++
+		}
+
+		payload, err := buildSeedPayload(writes[:n], costModel)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.MaxBytes != 0 && len(payload.Payload) > opts.MaxBytes {
+			return nil, fmt.Errorf("seeding 0x%x needs %d bytes, over the budget of %d bytes", writes[0].Value, len(payload.Payload), opts.MaxBytes)
+		}
+
+		if opts.MaxGas != 0 && payload.Gas > opts.MaxGas {
+			return nil, fmt.Errorf("seeding 0x%x needs %d gas, over the budget of %d gas", writes[0].Value, payload.Gas, opts.MaxGas)
+		}
+
+		payloads = append(payloads, payload)
+		writes = writes[n:]
+	}
+
+	return payloads, nil
+}
+
+// Assigns the index of each value, values keep their own size (20 or 32 bytes)
+func seedWrites(values [][]byte, indexes *Indexes, addresses uint, bytes32 uint) ([]PendingWrite, error) {
+	var writes []PendingWrite
+	seen := make(map[string]bool)
+
+	for i, value := range values {
+		padded := make([]byte, 32)
+
+		var flag uint
+		var known map[string]uint
+
+		switch len(value) {
+		case 20:
+			flag = FLAG_SAVE_ADDRESS
+			copy(padded[12:], value)
+			if indexes != nil {
+				known = indexes.AddressIndexes
+			}
+		case 32:
+			flag = FLAG_SAVE_BYTES32
+			copy(padded, value)
+			if indexes != nil {
+				known = indexes.Bytes32Indexes
+			}
+		default:
+			return nil, fmt.Errorf("value %d is %d bytes, expected an address (20 bytes) or a bytes32 (32 bytes)", i, len(value))
+		}
+
+		key := string(rune(flag)) + string(padded)
+		if seen[key] || known[string(padded)] != 0 {
+			continue
+		}
+
+		seen[key] = true
+
+		var index uint
+		if flag == FLAG_SAVE_ADDRESS {
+			addresses++
+			index = addresses
+		} else {
+			bytes32++
+			index = bytes32
+		}
+
+		writes = append(writes, PendingWrite{Flag: flag, Index: index, Value: value})
+	}
+
+	return writes, nil
+}
+
+// Size and gas of the method, plus the nested flag that holds n writes
+func seedHeaderCost(n int, costModel CostModel) (int, uint64) {
+	header := []byte{byte(METHOD_DECODE_ANY)}
+	if n > 0xff {
+		header = append(header, byte(FLAG_NESTED_N_FLAGS_L), byte(n>>8), byte(n))
+	} else if n > 1 {
+		header = append(header, byte(FLAG_NESTED_N_FLAGS_S), byte(n))
+	}
+
+	gas := costModel.CalldataCost(header)
+	if n > 1 {
+		gas += costModel.FlagCost(uint(header[1]))
+	}
+
+	return len(header), gas
+}
+
+// Size and gas of a single save flag, including its storage write
+func seedWriteCost(w PendingWrite, costModel CostModel) (int, uint64) {
+	encoded := append([]byte{byte(w.Flag)}, w.Value...)
+	return len(encoded), costModel.CalldataCost(encoded) + costModel.FlagCost(w.Flag) + costModel.SstoreCost()
+}
+
+func buildSeedPayload(writes []PendingWrite, costModel CostModel) (*SeedPayload, error) {
+	buf := NewBuffer(METHOD_DECODE_ANY, nil, nil, true)
+	buf.Refs.CostModel = costModel
+
+	// A single value doesn't need to be nested
+	if len(writes) > 1 {
+		if err := buf.commitNestedFlags(len(writes)); err != nil {
+			return nil, err
+		}
+
+		buf.end(nil, Stateless)
+	}
+
+	for _, w := range writes {
+		encoded := append([]byte{byte(w.Flag)}, w.Value...)
+
+		padded := make([]byte, 32)
+		copy(padded[32-len(w.Value):], w.Value)
+
+		buf.Refs.gas += buf.wordCost(encoded, WriteStorage) - costModel.CalldataCost(encoded)
+		buf.commitBytes(encoded)
+		buf.end(padded, WriteStorage)
+	}
+
+	return &SeedPayload{
+		Payload: buf.Commited,
+		Writes:  writes,
+		Gas:     buf.Gas() + costModel.CalldataCost(buf.Commited),
+	}, nil
+}
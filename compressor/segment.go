@@ -0,0 +1,209 @@
+package compressor
+
+import "fmt"
+
+// Kinds of segments a blob can be split into
+const (
+	segmentWord = iota
+	segmentZeros
+	segmentCopy
+	segmentRaw
+)
+
+type segment struct {
+	kind  int
+	start int
+	end   int
+
+	// Only used by copies, the position on the calldata
+	index int
+}
+
+// Common grams can appear many times, only this many
+// positions are tried when looking for a copy source
+const maxCopyCandidates = 32
+
+// Splits the blob into the cheapest sequence of words, zero runs, copies of
+// the calldata and raw bytes. Costs are estimated using the current state of
+// the buffer, the words may end up using a different encoding once written.
+func (buf *Buffer) segmentBytes(data []byte, saveWord bool) []segment {
+	const inf = ^uint64(0)
+
+	n := len(data)
+	model := buf.costModel()
+
+	// best[i] is the cost of encoding data[:i], and from[i] the last segment used
+	// raw[i] is the cost of encoding data[:i] ending on a raw segment that can be extended
+	best := make([]uint64, n+1)
+	from := make([]segment, n+1)
+	raw := make([]uint64, n+1)
+	rawStart := make([]int, n+1)
+
+	for i := range best {
+		best[i] = inf
+		raw[i] = inf
+	}
+	best[0] = 0
+
+	// The size of the raw segments is encoded as a word, it is priced
+	// using the size of the whole blob, as it is never bigger than that
+	rawOverhead := model.CalldataCost([]byte{byte(FLAG_READ_N_BYTES)}) + model.FlagCost(FLAG_READ_N_BYTES)
+	if _, cost, err := buf.encodeWordOptimized(uintToBytes(uint64(n)), false); err == nil {
+		rawOverhead += cost
+	}
+
+	relax := func(seg segment, cost uint64) {
+		if c := best[seg.start] + cost; c < best[seg.end] {
+			best[seg.end] = c
+			from[seg.end] = seg
+		}
+	}
+
+	buf.syncPastDataIndex()
+
+	for i := 0; i <= n; i++ {
+		// Raw segments can end anywhere
+		if raw[i] < best[i] {
+			best[i] = raw[i]
+			from[i] = segment{kind: segmentRaw, start: rawStart[i], end: i}
+		}
+
+		if i == n || best[i] == inf {
+			continue
+		}
+
+		// Either extend the current raw segment or start a new one
+		byteCost := model.CalldataCost(data[i : i+1])
+		if buf.Allows(FLAG_READ_N_BYTES) {
+			if open := best[i] + rawOverhead; open < raw[i] {
+				raw[i+1] = open + byteCost
+				rawStart[i+1] = i
+			} else {
+				raw[i+1] = raw[i] + byteCost
+				rawStart[i+1] = rawStart[i]
+			}
+		}
+
+		if i+32 <= n {
+			if _, cost, err := buf.encodeWordOptimized(data[i:i+32], saveWord); err == nil {
+				relax(segment{kind: segmentWord, start: i, end: i + 32}, cost)
+			}
+		}
+
+		if data[i] == 0 && buf.Allows(FLAG_WRITE_ZEROS) {
+			for j := i + 1; j <= n && j-i <= 0xff && data[j-1] == 0; j++ {
+				encoded := []byte{byte(FLAG_WRITE_ZEROS), byte(j - i)}
+				relax(segment{kind: segmentZeros, start: i, end: j}, model.CalldataCost(encoded)+model.FlagCost(FLAG_WRITE_ZEROS))
+			}
+		}
+
+		index, size := buf.pastData.longestMatch(buf.Commited, data[i:], maxCopyCandidates)
+		if size >= gramSize {
+			if size > 0xffff {
+				size = 0xffff
+			}
+
+			if encoded := encodeCopyCalldata(uint(index), uint(size)); encoded != nil && buf.Allows(uint(encoded[0])) {
+				relax(segment{kind: segmentCopy, start: i, end: i + size, index: index}, model.CalldataCost(encoded)+model.FlagCost(uint(encoded[0])))
+			}
+		}
+	}
+
+	if best[n] == inf {
+		return nil
+	}
+
+	var segments []segment
+	for i := n; i > 0; i = from[i].start {
+		segments = append([]segment{from[i]}, segments...)
+	}
+
+	return segments
+}
+
+// Writes the blob as a list of nested segments, see segmentBytes
+func (buf *Buffer) WriteBytesSegmented(data []byte, saveWord bool) (EncodeType, error) {
+	// Empty data is still a flag, a no-op or an empty list of nested flags
+	if len(data) == 0 {
+		if buf.Allows(FLAG_NO_OP) {
+			buf.commitFlag(FLAG_NO_OP)
+		} else if err := buf.commitNestedFlags(0); err != nil {
+			return Stateless, err
+		}
+
+		buf.end(data, Stateless)
+		return Stateless, nil
+	}
+
+	segments := buf.segmentBytes(data, saveWord)
+	if len(segments) == 0 {
+		return Stateless, fmt.Errorf("no allowed segments for %d bytes", len(data))
+	}
+
+	mark := -1
+
+	// A single segment doesn't need to be nested
+	if len(segments) > 1 {
+		mark = buf.statsMark()
+		if err := buf.commitNestedFlags(len(segments)); err != nil {
+			return Stateless, err
+		}
+
+		buf.end(data, Stateless)
+	}
+
+	encodeType := Stateless
+
+	for _, seg := range segments {
+		t, err := buf.writeSegment(data[seg.start:seg.end], seg, saveWord)
+		if err != nil {
+			return Stateless, err
+		}
+
+		encodeType = maxPriority(encodeType, t)
+	}
+
+	buf.nestedStats(mark)
+	return encodeType, nil
+}
+
+func (buf *Buffer) writeSegment(data []byte, seg segment, saveWord bool) (EncodeType, error) {
+	switch seg.kind {
+	case segmentWord:
+		return buf.WriteWord(data, saveWord)
+
+	case segmentZeros:
+		buf.commitFlag(FLAG_WRITE_ZEROS)
+		buf.commitByte(byte(len(data)))
+		buf.end(data, Stateless)
+		return Stateless, nil
+
+	case segmentCopy:
+		encoded := encodeCopyCalldata(uint(seg.index), uint(len(data)))
+		buf.commitFlag(uint(encoded[0]))
+		buf.commitBytes(encoded[1:])
+		buf.endWith([]byte{}, len(data), Stateless)
+
+		// The same as the copies of words, the copied data is not a new pointer
+		return Stateless, nil
+
+	default:
+		return buf.WriteNBytesRaw(data)
+	}
+}
+
+// Commits the header of a list of n nested flags, using the smallest allowed flag
+func (buf *Buffer) commitNestedFlags(n int) error {
+	if n <= 0xff && buf.Allows(FLAG_NESTED_N_FLAGS_S) {
+		buf.commitFlag(FLAG_NESTED_N_FLAGS_S)
+		buf.commitByte(byte(n))
+	} else if n <= 0xffff && buf.Allows(FLAG_NESTED_N_FLAGS_L) {
+		buf.commitFlag(FLAG_NESTED_N_FLAGS_L)
+		buf.commitByte(byte(n >> 8))
+		buf.commitByte(byte(n))
+	} else {
+		return fmt.Errorf("can't nest %d flags", n)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package compressor
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestWriteBytesSegmentedEmpty(t *testing.T) {
+	tests := []struct {
+		name     string
+		allow    *AllowOpcodes
+		expected []byte
+	}{
+		{name: "no-op", expected: []byte{byte(FLAG_NO_OP)}},
+		{name: "empty nested flags", allow: &AllowOpcodes{Default: true, List: map[uint]bool{FLAG_NO_OP: true}}, expected: []byte{byte(FLAG_NESTED_N_FLAGS_S), 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := NewBuffer(METHOD_DECODE_ANY, &Indexes{}, tt.allow, false)
+			before := len(buf.Commited)
+
+			if _, err := buf.WriteBytesSegmented(nil, false); err != nil {
+				t.Fatal(err)
+			}
+
+			if written := buf.Commited[before:]; !bytes.Equal(written, tt.expected) {
+				t.Fatalf("wrote %x, expected %x", written, tt.expected)
+			}
+		})
+	}
+}
+
+// A blob copied as a single segment has the same type as the copies of words
+func TestWriteBytesSegmentedCopy(t *testing.T) {
+	blob := randomBytes(rand.New(rand.NewSource(1)), 45)
+
+	buf := NewBuffer(METHOD_DECODE_ANY, &Indexes{}, nil, false)
+	commitData(buf, blob)
+	before := len(buf.Commited)
+
+	typ, err := buf.WriteBytesSegmented(blob, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if flag := uint(buf.Commited[before]); flag != FLAG_COPY_CALLDATA_S {
+		t.Fatalf("blob written with flag 0x%02x, expected a copy", flag)
+	}
+
+	if typ != Stateless {
+		t.Fatalf("copy segment is %s, expected %s", typ, Stateless)
+	}
+}
@@ -0,0 +1,106 @@
+package compressor
+
+import (
+	"sync"
+)
+
+// Indexes that can be updated while payloads are being encoded from other goroutines.
+// Every update creates a new version of the indexes (copy-on-write), versions are never
+// modified once published, so a Buffer can keep using the version it started with.
+type SharedIndexes struct {
+	mutex sync.RWMutex
+
+	// Serializes updates, so none of them is lost
+	updates sync.Mutex
+
+	indexes *Indexes
+	version uint64
+}
+
+// An IndexSource that also reports the version of the indexes, see SharedIndexes
+type VersionedIndexSource interface {
+	IndexSource
+	Snapshot() (*Indexes, uint64)
+}
+
+// Creates shared indexes with a copy of the given ones, as version 1
+func NewSharedIndexes(indexes *Indexes) *SharedIndexes {
+	return &SharedIndexes{
+		indexes: copyIndexes(indexes),
+		version: 1,
+	}
+}
+
+// Returns the current version of the indexes, they must not be modified
+func (s *SharedIndexes) Snapshot() (*Indexes, uint64) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.indexes, s.version
+}
+
+func (s *SharedIndexes) Indexes() *Indexes {
+	indexes, _ := s.Snapshot()
+	return indexes
+}
+
+func (s *SharedIndexes) Version() uint64 {
+	_, version := s.Snapshot()
+	return version
+}
+
+// Applies the update to a copy of the current indexes, and publishes it as a new version.
+// Buffers that already pinned a version are not affected. If the update fails nothing changes.
+func (s *SharedIndexes) Update(update func(next *Indexes) error) (uint64, error) {
+	s.updates.Lock()
+	defer s.updates.Unlock()
+
+	current, _ := s.Snapshot()
+
+	next := copyIndexes(current)
+	if err := update(next); err != nil {
+		return 0, err
+	}
+
+	return s.publish(next), nil
+}
+
+// Adds values to the address and bytes32 indexes, as returned by LoadState
+func (s *SharedIndexes) Add(addresses map[string]uint, bytes32 map[string]uint) uint64 {
+	// The update never fails
+	version, _ := s.Update(func(next *Indexes) error {
+		for k, v := range addresses {
+			next.AddressIndexes[k] = v
+		}
+
+		for k, v := range bytes32 {
+			next.Bytes32Indexes[k] = v
+		}
+
+		return nil
+	})
+
+	return version
+}
+
+// Replaces the indexes with new ones, that must not be modified after this call
+func (s *SharedIndexes) Store(indexes *Indexes) uint64 {
+	if indexes == nil {
+		indexes = copyIndexes(nil)
+	}
+
+	s.updates.Lock()
+	defer s.updates.Unlock()
+
+	return s.publish(indexes)
+}
+
+func (s *SharedIndexes) publish(indexes *Indexes) uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.indexes = indexes
+	s.version++
+
+	return s.version
+}
@@ -0,0 +1,213 @@
+package compressor
+
+import (
+	"sort"
+)
+
+// A flag written on the payload, In is the number of bytes it writes when decompressed
+// and Out the number of bytes it uses on the payload. Flags that nest other flags
+// only count their own bytes, the nested flags are recorded after them.
+type FlagStat struct {
+	Flag uint
+	In   int
+	Out  int
+}
+
+// All the uses of a flag on one or more payloads, literals are counted as LITERAL_ZERO
+type FlagCount struct {
+	Flag     uint   `json:"flag"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+	In       int    `json:"in"`
+	Out      int    `json:"out"`
+}
+
+type Stats struct {
+	// Size of the data before and after compression, ratio is compressed / size
+	Size           int     `json:"size"`
+	CompressedSize int     `json:"compressedSize"`
+	Ratio          float64 `json:"ratio"`
+
+	// Sorted by flag
+	Flags []*FlagCount `json:"flags"`
+
+	// Bytes saved by each category of flags, negative if they
+	// use more bytes than the data they write, see FlagCategory
+	Saved map[string]int `json:"saved"`
+
+	// Selectors read from the table, and selectors written as they are
+	SelectorHits   int `json:"selectorHits"`
+	SelectorMisses int `json:"selectorMisses"`
+}
+
+const (
+	CategoryLiterals      = "literals"
+	CategoryPow2          = "pow2"
+	CategoryPow10         = "pow10"
+	CategoryMirror        = "mirror"
+	CategoryCopy          = "copy"
+	CategoryStorageReads  = "storage_reads"
+	CategoryStorageWrites = "storage_writes"
+	CategorySelectors     = "selectors"
+	CategoryABI           = "abi"
+	CategoryNested        = "nested"
+	CategorySequence      = "sequence"
+)
+
+// Category of the flag on the stats, selectors are part of the ABI
+// flags and they are reported on their own as CategorySelectors
+func FlagCategory(flag uint) string {
+	switch {
+	case flag >= LITERAL_ZERO:
+		return CategoryLiterals
+	case flag <= FLAG_WRITE_ZEROS:
+		return CategoryLiterals
+	case flag == FLAG_NESTED_N_FLAGS_S || flag == FLAG_NESTED_N_FLAGS_L:
+		return CategoryNested
+	case flag == FLAG_SAVE_ADDRESS || flag == FLAG_SAVE_BYTES32:
+		return CategoryStorageWrites
+	case flag >= FLAG_READ_ADDRESS_2 && flag <= FLAG_READ_BYTES32_4:
+		return CategoryStorageReads
+	case flag == FLAG_READ_STORE_FLAG_S || flag == FLAG_READ_STORE_FLAG_L:
+		return CategoryMirror
+	case flag == FLAG_POW_2 || flag == FLAG_POW_2_MINUS_1:
+		return CategoryPow2
+	case flag >= FLAG_POW_10 && flag <= FLAG_POW_10_MANTISSA_L:
+		return CategoryPow10
+	case flag >= FLAG_ABI_0_PARAM && flag <= FLAG_READ_DYNAMIC_ABI:
+		return CategoryABI
+	case flag == FLAG_MIRROR_FLAG_S || flag == FLAG_MIRROR_FLAG_L:
+		return CategoryMirror
+	case flag >= FLAG_COPY_CALLDATA_S && flag <= FLAG_COPY_CALLDATA_XL:
+		return CategoryCopy
+	default:
+		return CategorySequence
+	}
+}
+
+// Returns the flags recorded so far, Refs.RecordStats must be set before writing them
+func (cb *Buffer) FlagStats() []FlagStat {
+	return cb.Refs.stats
+}
+
+// Builds the stats of the flags recorded so far, size is the size of the data they encode
+func (cb *Buffer) Stats(size int) *Stats {
+	s := &Stats{
+		Size:           size,
+		CompressedSize: cb.Len(),
+		Saved:          make(map[string]int),
+		SelectorHits:   cb.Refs.selectorHits,
+		SelectorMisses: cb.Refs.selectorMisses,
+	}
+
+	names := make(map[uint]string)
+	for name, flag := range FlagNames() {
+		names[flag] = name
+	}
+
+	counts := make(map[uint]*FlagCount)
+	for _, stat := range cb.Refs.stats {
+		flag := stat.Flag
+		if flag > LITERAL_ZERO {
+			flag = LITERAL_ZERO
+		}
+
+		count := counts[flag]
+		if count == nil {
+			count = &FlagCount{Flag: flag, Name: names[flag], Category: FlagCategory(flag)}
+			counts[flag] = count
+		}
+
+		count.Count++
+		count.In += stat.In
+		count.Out += stat.Out
+	}
+
+	for _, count := range counts {
+		s.Flags = append(s.Flags, count)
+	}
+
+	s.finish()
+	return s
+}
+
+// Adds the stats of another payload
+func (s *Stats) Add(other *Stats) {
+	s.Size += other.Size
+	s.CompressedSize += other.CompressedSize
+	s.SelectorHits += other.SelectorHits
+	s.SelectorMisses += other.SelectorMisses
+
+	for _, count := range other.Flags {
+		found := false
+		for _, c := range s.Flags {
+			if c.Flag == count.Flag {
+				c.Count += count.Count
+				c.In += count.In
+				c.Out += count.Out
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			c := *count
+			s.Flags = append(s.Flags, &c)
+		}
+	}
+
+	s.finish()
+}
+
+func (s *Stats) finish() {
+	sort.Slice(s.Flags, func(i, j int) bool {
+		return s.Flags[i].Flag < s.Flags[j].Flag
+	})
+
+	s.Saved = make(map[string]int)
+	for _, count := range s.Flags {
+		s.Saved[count.Category] += count.In - count.Out
+	}
+
+	// A selector on the table uses 1 byte, any other uses 5
+	if s.SelectorHits != 0 || s.SelectorMisses != 0 {
+		selectors := 3*s.SelectorHits - s.SelectorMisses
+		s.Saved[CategorySelectors] = selectors
+		s.Saved[CategoryABI] -= selectors
+	}
+
+	s.Ratio = 0
+	if s.Size != 0 {
+		s.Ratio = float64(s.CompressedSize) / float64(s.Size)
+	}
+}
+
+// Position of the next flag recorded
+func (cb *Buffer) statsMark() int {
+	return len(cb.Refs.stats)
+}
+
+// Flags that nest other flags are recorded with all the data they write, once
+// the nested flags are written they only keep the bytes that are their own
+func (cb *Buffer) nestedStats(mark int) {
+	if mark < 0 || mark >= len(cb.Refs.stats) {
+		return
+	}
+
+	stat := &cb.Refs.stats[mark]
+	for _, nested := range cb.Refs.stats[mark+1:] {
+		stat.In -= nested.In
+	}
+
+	if stat.In < 0 {
+		stat.In = 0
+	}
+}
+
+// The flags recorded since mark are read by another flag, they don't write any data
+func (cb *Buffer) consumedStats(mark int) {
+	for i := mark; i < len(cb.Refs.stats); i++ {
+		cb.Refs.stats[i].In = 0
+	}
+}
@@ -0,0 +1,490 @@
+package compressor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+)
+
+// Persists the address and bytes32 indexes loaded from the contract, together with the
+// metadata of the state they were loaded from. Indexes are write-once on the contract, so
+// most updates only add values, Save is only needed when values were evicted (see VerifyIndexes).
+type IndexStore interface {
+	// Returns the stored indexes and their metadata, the metadata is nil if the store is empty
+	Load() (*Indexes, *IndexMetadata, error)
+
+	// Adds new values to the stored indexes
+	Append(values *Indexes, meta *IndexMetadata) error
+
+	// Replaces all the stored indexes
+	Save(indexes *Indexes, meta *IndexMetadata) error
+}
+
+// Identifies the decompressor the indexes belong to, and the state they were loaded from
+type IndexMetadata struct {
+	ChainID  uint64         `json:"chainId"`
+	Contract common.Address `json:"contract"`
+	CodeHash common.Hash    `json:"codeHash"`
+
+	// Block the indexes were loaded on, and the number of values on it
+	Block     uint64 `json:"block"`
+	Addresses uint   `json:"addresses"`
+	Bytes32   uint   `json:"bytes32"`
+
+	// Version of the compressor that loaded the indexes
+	Version string `json:"version"`
+}
+
+// Checks that the stored indexes belong to the given decompressor, indexes
+// of a different chain, contract or deployment must never be used.
+func (m *IndexMetadata) Validate(chainID uint64, contract common.Address, codeHash common.Hash) error {
+	if m == nil {
+		return fmt.Errorf("indexes have no metadata")
+	}
+
+	if m.ChainID != chainID {
+		return fmt.Errorf("indexes belong to chain %d, not %d", m.ChainID, chainID)
+	}
+
+	if m.Contract != contract {
+		return fmt.Errorf("indexes belong to contract %s, not %s", m.Contract.Hex(), contract.Hex())
+	}
+
+	if m.CodeHash != codeHash {
+		return fmt.Errorf("indexes belong to code hash %s, not %s", m.CodeHash.Hex(), codeHash.Hex())
+	}
+
+	return nil
+}
+
+func (m *IndexMetadata) copy() *IndexMetadata {
+	if m == nil {
+		return nil
+	}
+
+	next := *m
+	return &next
+}
+
+// Keeps the indexes in memory, mostly useful for tests and long running processes
+type MemoryIndexStore struct {
+	mutex   sync.Mutex
+	indexes *Indexes
+	meta    *IndexMetadata
+}
+
+func NewMemoryIndexStore() *MemoryIndexStore {
+	return &MemoryIndexStore{indexes: newStoredIndexes()}
+}
+
+func (s *MemoryIndexStore) Load() (*Indexes, *IndexMetadata, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return copyStoredIndexes(s.indexes), s.meta.copy(), nil
+}
+
+func (s *MemoryIndexStore) Append(values *Indexes, meta *IndexMetadata) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	mergeStoredIndexes(s.indexes, values)
+	s.meta = meta.copy()
+	return nil
+}
+
+func (s *MemoryIndexStore) Save(indexes *Indexes, meta *IndexMetadata) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.indexes = copyStoredIndexes(indexes)
+	s.meta = meta.copy()
+	return nil
+}
+
+// Keeps the indexes on a single JSON file, with hex encoded values. Every write
+// rewrites the whole file, so the file can be read and edited by hand.
+type FileIndexStore struct {
+	path string
+}
+
+func NewFileIndexStore(path string) *FileIndexStore {
+	return &FileIndexStore{path: path}
+}
+
+type fileIndexes struct {
+	Metadata       *IndexMetadata  `json:"metadata,omitempty"`
+	AddressIndexes map[string]uint `json:"AddressIndexes"`
+	Bytes32Indexes map[string]uint `json:"Bytes32Indexes"`
+}
+
+func (s *FileIndexStore) Load() (*Indexes, *IndexMetadata, error) {
+	var indexes *Indexes
+	var meta *IndexMetadata
+
+	err := withFileLock(s.path, false, func() error {
+		var err error
+		indexes, meta, err = s.read()
+		return err
+	})
+
+	return indexes, meta, err
+}
+
+// Values written by other processes since the last load are kept
+func (s *FileIndexStore) Append(values *Indexes, meta *IndexMetadata) error {
+	return withFileLock(s.path, true, func() error {
+		indexes, _, err := s.read()
+		if err != nil {
+			return err
+		}
+
+		mergeStoredIndexes(indexes, values)
+		return s.write(indexes, meta)
+	})
+}
+
+func (s *FileIndexStore) Save(indexes *Indexes, meta *IndexMetadata) error {
+	return withFileLock(s.path, true, func() error {
+		return s.write(indexes, meta)
+	})
+}
+
+func (s *FileIndexStore) read() (*Indexes, *IndexMetadata, error) {
+	dat, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return newStoredIndexes(), nil, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tmp fileIndexes
+	if err := json.Unmarshal(dat, &tmp); err != nil {
+		return nil, nil, fmt.Errorf("invalid index file %s: %w", s.path, err)
+	}
+
+	indexes := newStoredIndexes()
+
+	for k, v := range tmp.AddressIndexes {
+		indexes.AddressIndexes[string(common.FromHex(k))] = v
+	}
+
+	for k, v := range tmp.Bytes32Indexes {
+		indexes.Bytes32Indexes[string(common.FromHex(k))] = v
+	}
+
+	return indexes, tmp.Metadata, nil
+}
+
+func (s *FileIndexStore) write(indexes *Indexes, meta *IndexMetadata) error {
+	tmp := fileIndexes{
+		Metadata:       meta,
+		AddressIndexes: make(map[string]uint, len(indexes.AddressIndexes)),
+		Bytes32Indexes: make(map[string]uint, len(indexes.Bytes32Indexes)),
+	}
+
+	for k, v := range indexes.AddressIndexes {
+		tmp.AddressIndexes[common.Bytes2Hex([]byte(k))] = v
+	}
+
+	for k, v := range indexes.Bytes32Indexes {
+		tmp.Bytes32Indexes[common.Bytes2Hex([]byte(k))] = v
+	}
+
+	dat, err := json.Marshal(tmp)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, dat)
+}
+
+// Keeps the indexes on a file with one JSON record per line, new values are appended
+// to the end of the file. Save compacts the file, writing only the current values,
+// and Append compacts it too once most of its records are stale, so periodic
+// refreshes that only update the metadata don't grow it without limit.
+type LogIndexStore struct {
+	path string
+}
+
+func NewLogIndexStore(path string) *LogIndexStore {
+	return &LogIndexStore{path: path}
+}
+
+// A record either sets the metadata, or adds a value
+type logRecord struct {
+	Metadata *IndexMetadata `json:"metadata,omitempty"`
+	Address  string         `json:"address,omitempty"`
+	Bytes32  string         `json:"bytes32,omitempty"`
+	Index    *uint          `json:"index,omitempty"`
+}
+
+func (s *LogIndexStore) Load() (*Indexes, *IndexMetadata, error) {
+	var indexes *Indexes
+	var meta *IndexMetadata
+
+	err := withFileLock(s.path, false, func() error {
+		var err error
+		indexes, meta, _, err = s.read()
+		return err
+	})
+
+	return indexes, meta, err
+}
+
+// Only the values that are not on the log yet are appended, nothing is
+// written if there are none and the metadata didn't change either
+func (s *LogIndexStore) Append(values *Indexes, meta *IndexMetadata) error {
+	return withFileLock(s.path, true, func() error {
+		if err := s.dropPartialLine(); err != nil {
+			return err
+		}
+
+		stored, storedMeta, records, err := s.read()
+		if err != nil {
+			return err
+		}
+
+		fresh := newStoredIndexes()
+		mergeNewIndexes(stored.AddressIndexes, values.AddressIndexes, fresh.AddressIndexes)
+		mergeNewIndexes(stored.Bytes32Indexes, values.Bytes32Indexes, fresh.Bytes32Indexes)
+
+		changed := len(fresh.AddressIndexes) != 0 || len(fresh.Bytes32Indexes) != 0
+		if !changed && (meta == nil || (storedMeta != nil && *storedMeta == *meta)) {
+			return nil
+		}
+
+		// Rewrite the log once there are more stale records than current ones
+		live := len(stored.AddressIndexes) + len(stored.Bytes32Indexes) + 1
+		if records+len(fresh.AddressIndexes)+len(fresh.Bytes32Indexes)+1 > 2*live {
+			if meta == nil {
+				meta = storedMeta
+			}
+
+			return writeFileAtomic(s.path, encodeLogRecords(stored, meta))
+		}
+
+		f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+
+		// A single write, so a crash can only leave a partial last line
+		_, err = f.Write(encodeLogRecords(fresh, meta))
+		if err == nil {
+			err = f.Sync()
+		}
+
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		return err
+	})
+}
+
+// Removes the incomplete last line left by an interrupted write, so it
+// doesn't end up in the middle of the file after the next append
+func (s *LogIndexStore) dropPartialLine() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+
+	// Only the last byte is read, unless the file was left incomplete
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil || last[0] == '\n' {
+		return err
+	}
+
+	dat, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	return os.Truncate(s.path, int64(bytes.LastIndexByte(dat, '\n')+1))
+}
+
+func (s *LogIndexStore) Save(indexes *Indexes, meta *IndexMetadata) error {
+	return withFileLock(s.path, true, func() error {
+		return writeFileAtomic(s.path, encodeLogRecords(indexes, meta))
+	})
+}
+
+// Returns the current indexes and metadata, and the number of records on the log
+func (s *LogIndexStore) read() (*Indexes, *IndexMetadata, int, error) {
+	indexes := newStoredIndexes()
+
+	dat, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return indexes, nil, 0, nil
+	}
+
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var meta *IndexMetadata
+	records := 0
+
+	lines := bytes.Split(dat, []byte("\n"))
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+
+		var record logRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			// The last line is incomplete if a write was interrupted
+			if i == len(lines)-1 {
+				break
+			}
+
+			return nil, nil, 0, fmt.Errorf("invalid index log %s at line %d: %w", s.path, i+1, err)
+		}
+
+		records++
+
+		switch {
+		case record.Address != "" && record.Index != nil:
+			indexes.AddressIndexes[string(common.FromHex(record.Address))] = *record.Index
+		case record.Bytes32 != "" && record.Index != nil:
+			indexes.Bytes32Indexes[string(common.FromHex(record.Bytes32))] = *record.Index
+		case record.Metadata != nil:
+			meta = record.Metadata
+		}
+	}
+
+	return indexes, meta, records, nil
+}
+
+// Adds to stored the values that are not on it, or that are on another index, and to fresh too
+func mergeNewIndexes(stored map[string]uint, values map[string]uint, fresh map[string]uint) {
+	for k, v := range values {
+		if i, ok := stored[k]; !ok || i != v {
+			stored[k] = v
+			fresh[k] = v
+		}
+	}
+}
+
+func encodeLogRecords(indexes *Indexes, meta *IndexMetadata) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	// Encoding plain values can't fail
+	for k, v := range indexes.AddressIndexes {
+		v := v
+		_ = enc.Encode(logRecord{Address: common.Bytes2Hex([]byte(k)), Index: &v})
+	}
+
+	for k, v := range indexes.Bytes32Indexes {
+		v := v
+		_ = enc.Encode(logRecord{Bytes32: common.Bytes2Hex([]byte(k)), Index: &v})
+	}
+
+	// The metadata goes last, so it is only updated if all the values were written
+	if meta != nil {
+		_ = enc.Encode(logRecord{Metadata: meta})
+	}
+
+	return buf.Bytes()
+}
+
+// Writes to a temporary file on the same directory and renames it,
+// readers either see the old file or the new one, never a partial one.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	return err
+}
+
+// The lock is held on a separate file, the data file is replaced on every atomic write
+func withFileLock(path string, exclusive bool, fn func() error) error {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if err := lockFile(f, exclusive); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	defer unlockFile(f)
+
+	return fn()
+}
+
+func newStoredIndexes() *Indexes {
+	return &Indexes{
+		AddressIndexes: make(map[string]uint),
+		Bytes32Indexes: make(map[string]uint),
+	}
+}
+
+// Bytes4 indexes are part of the decompressor, so they are not stored
+func copyStoredIndexes(indexes *Indexes) *Indexes {
+	next := newStoredIndexes()
+	mergeStoredIndexes(next, indexes)
+	return next
+}
+
+func mergeStoredIndexes(to *Indexes, from *Indexes) {
+	if from == nil {
+		return
+	}
+
+	for k, v := range from.AddressIndexes {
+		to.AddressIndexes[k] = v
+	}
+
+	for k, v := range from.Bytes32Indexes {
+		to.Bytes32Indexes[k] = v
+	}
+}
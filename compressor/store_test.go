@@ -0,0 +1,102 @@
+package compressor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+)
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.Count(dat, []byte("\n"))
+}
+
+func TestLogIndexStoreAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "indexes.log")
+	store := NewLogIndexStore(path)
+
+	values := newStoredIndexes()
+	for i := 1; i <= 10; i++ {
+		values.AddressIndexes[string(common.LeftPadBytes([]byte{byte(i)}, 32))] = uint(i)
+	}
+
+	meta := &IndexMetadata{ChainID: 1, Block: 100, Addresses: 10}
+	if err := store.Append(values, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := countLines(t, path); lines != 11 {
+		t.Fatalf("%d records, expected 11", lines)
+	}
+
+	// The loader reads the last index again on every run, nothing changed
+	last := newStoredIndexes()
+	last.AddressIndexes[string(common.LeftPadBytes([]byte{10}, 32))] = 10
+
+	for i := 0; i < 5; i++ {
+		if err := store.Append(last, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if lines := countLines(t, path); lines != 11 {
+		t.Fatalf("%d records after appending the same values, expected 11", lines)
+	}
+
+	// Only the metadata changes, the log is compacted before it doubles
+	for block := uint64(101); block < 200; block++ {
+		next := *meta
+		next.Block = block
+
+		if err := store.Append(last, &next); err != nil {
+			t.Fatal(err)
+		}
+
+		if lines := countLines(t, path); lines > 2*11 {
+			t.Fatalf("%d records on block %d, expected at most %d", lines, block, 2*11)
+		}
+	}
+
+	// New values are appended, and a value on another index replaces the old one
+	update := newStoredIndexes()
+	update.AddressIndexes[string(common.LeftPadBytes([]byte{11}, 32))] = 11
+	update.Bytes32Indexes[string(common.LeftPadBytes([]byte{1}, 32))] = 1
+	update.AddressIndexes[string(common.LeftPadBytes([]byte{1}, 32))] = 12
+
+	final := &IndexMetadata{ChainID: 1, Block: 300, Addresses: 12, Bytes32: 1}
+	if err := store.Append(update, final); err != nil {
+		t.Fatal(err)
+	}
+
+	indexes, loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *loaded != *final {
+		t.Fatalf("metadata %+v, expected %+v", loaded, final)
+	}
+
+	if len(indexes.AddressIndexes) != 11 || len(indexes.Bytes32Indexes) != 1 {
+		t.Fatalf("%d addresses and %d bytes32, expected 11 and 1", len(indexes.AddressIndexes), len(indexes.Bytes32Indexes))
+	}
+
+	for i := 2; i <= 11; i++ {
+		if v := indexes.AddressIndexes[string(common.LeftPadBytes([]byte{byte(i)}, 32))]; v != uint(i) {
+			t.Fatalf("address %d is on %d", i, v)
+		}
+	}
+
+	if v := indexes.AddressIndexes[string(common.LeftPadBytes([]byte{1}, 32))]; v != 12 {
+		t.Fatalf("replaced address is on %d, expected 12", v)
+	}
+}
@@ -0,0 +1,345 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Learns from a corpus of calls which selectors should be on the selector table, and which
+// addresses and bytes32 are worth seeding on storage, see Bytes4Table and SeedCandidates.
+type Trainer struct {
+	samples []trainSample
+
+	selectors map[string]uint
+
+	// Words are padded to 32 bytes, as they are on the indexes
+	addresses map[string]uint
+	bytes32   map[string]uint
+}
+
+type trainSample struct {
+	to   []byte
+	data []byte
+}
+
+// A selector of the corpus, and the number of calls that use it
+type SelectorCount struct {
+	Selector []byte
+	Count    uint
+}
+
+// A value that would save bytes if it was on storage before the corpus was compressed
+type SeedCandidate struct {
+	// FLAG_SAVE_ADDRESS or FLAG_SAVE_BYTES32
+	Flag  uint
+	Value []byte
+	Count uint
+
+	// Index the value gets if the candidates are seeded in order, on an empty contract
+	Index uint
+
+	// Bytes saved on the corpus, minus the bytes used to seed the value
+	Savings int
+}
+
+type TrainReport struct {
+	Samples int
+
+	// Size of the calldata, and its size compressed without storage using the default
+	// selector table, the trained table, and the trained table plus the seeded values
+	Size         int
+	Compressed   int
+	TrainedTable int
+	Trained      int
+}
+
+func NewTrainer() *Trainer {
+	return &Trainer{
+		selectors: make(map[string]uint),
+		addresses: make(map[string]uint),
+		bytes32:   make(map[string]uint),
+	}
+}
+
+// Adds a call to the corpus, to may be empty if the data is not a call
+func (t *Trainer) Add(to []byte, data []byte) {
+	t.samples = append(t.samples, trainSample{to: to, data: data})
+
+	if len(to) != 0 {
+		t.countWord(to)
+	}
+
+	if len(data) < 4 {
+		return
+	}
+
+	t.selectors[string(data[:4])]++
+
+	for i := 4; i+32 <= len(data); i += 32 {
+		t.countWord(data[i : i+32])
+	}
+}
+
+// Words are classified in the same way encodeWordOptimized chooses between saving
+// them as an address or as a bytes32, any other word is not worth saving
+func (t *Trainer) countWord(word []byte) {
+	padded := make([]byte, 32)
+	copy(padded[32-len(word):], word)
+
+	trimmed := bytes.TrimLeft(padded, "\x00")
+
+	if len(trimmed) >= 15 && len(trimmed) <= 20 {
+		t.addresses[string(padded)]++
+	} else if len(trimmed) >= 27 {
+		t.bytes32[string(padded)]++
+	}
+}
+
+func (t *Trainer) Samples() int {
+	return len(t.samples)
+}
+
+// Returns the selectors of the corpus, most used first
+func (t *Trainer) Selectors() []SelectorCount {
+	selectors := make([]SelectorCount, 0, len(t.selectors))
+	for k, v := range t.selectors {
+		selectors = append(selectors, SelectorCount{Selector: []byte(k), Count: v})
+	}
+
+	sort.Slice(selectors, func(i, j int) bool {
+		if selectors[i].Count != selectors[j].Count {
+			return selectors[i].Count > selectors[j].Count
+		}
+
+		return bytes.Compare(selectors[i].Selector, selectors[j].Selector) < 0
+	})
+
+	return selectors
+}
+
+// Builds a selector table with the most used selectors of the corpus, index 0 is left
+// empty as it is reserved for selectors out of the table. Selectors used only once are
+// not worth a place on the table. The table always has BYTES4_TABLE_SIZE entries, the
+// unused ones are zeros, so it can replace the table of the decompressor as is.
+func (t *Trainer) Bytes4Table() []byte {
+	table := make([]byte, BYTES4_TABLE_SIZE*4)
+
+	index := 1
+	for _, s := range t.Selectors() {
+		if index == BYTES4_TABLE_SIZE || s.Count < 2 || bytesAreZero(s.Selector) {
+			break
+		}
+
+		copy(table[index*4:index*4+4], s.Selector)
+		index++
+	}
+
+	return table
+}
+
+// Returns the values that save the most bytes if they are on storage, up to limit
+// candidates (0 for no limit). Values used only once never pay for their seeding.
+func (t *Trainer) SeedCandidates(limit int) ([]SeedCandidate, error) {
+	var candidates []SeedCandidate
+
+	for _, kind := range []struct {
+		flag   uint
+		counts map[string]uint
+	}{{FLAG_SAVE_ADDRESS, t.addresses}, {FLAG_SAVE_BYTES32, t.bytes32}} {
+		var ranked []SeedCandidate
+
+		// First ranked assuming the smallest index, then the
+		// savings are computed again with the index they get
+		for k, v := range kind.counts {
+			if v < 2 {
+				continue
+			}
+
+			c := SeedCandidate{Flag: kind.flag, Value: []byte(k), Count: v, Index: 1}
+			if err := c.computeSavings(); err != nil {
+				return nil, err
+			}
+
+			if c.Savings > 0 {
+				ranked = append(ranked, c)
+			}
+		}
+
+		sortSeedCandidates(ranked)
+
+		for i := range ranked {
+			ranked[i].Index = uint(i) + 1
+			if err := ranked[i].computeSavings(); err != nil {
+				return nil, err
+			}
+
+			if ranked[i].Savings > 0 {
+				candidates = append(candidates, ranked[i])
+			}
+		}
+	}
+
+	sortSeedCandidates(candidates)
+
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	// The indexes must follow the order of the list, as that is the order they are seeded in
+	next := map[uint]uint{FLAG_SAVE_ADDRESS: 1, FLAG_SAVE_BYTES32: 1}
+	for i := range candidates {
+		candidates[i].Index = next[candidates[i].Flag]
+		next[candidates[i].Flag]++
+
+		if err := candidates[i].computeSavings(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Values are stored trimmed to the size of their repository
+	for i := range candidates {
+		if candidates[i].Flag == FLAG_SAVE_ADDRESS {
+			candidates[i].Value = candidates[i].Value[12:]
+		}
+	}
+
+	return candidates, nil
+}
+
+// Compares reading the value from storage against the cheapest stateless encoding
+func (c *SeedCandidate) computeSavings() error {
+	stateless := NewBuffer(METHOD_DECODE_ANY, nil, nil, false)
+	if _, err := stateless.WriteWord(c.Value, false); err != nil {
+		return err
+	}
+
+	indexes := &Indexes{
+		AddressIndexes: make(map[string]uint),
+		Bytes32Indexes: make(map[string]uint),
+	}
+
+	seeding := 1 + 32
+	if c.Flag == FLAG_SAVE_ADDRESS {
+		indexes.AddressIndexes[string(c.Value)] = c.Index
+		seeding = 1 + 20
+	} else {
+		indexes.Bytes32Indexes[string(c.Value)] = c.Index
+	}
+
+	stateful := NewBuffer(METHOD_DECODE_ANY, indexes, nil, true)
+	if _, err := stateful.WriteWord(c.Value, false); err != nil {
+		return err
+	}
+
+	c.Savings = int(c.Count)*(stateless.Len()-stateful.Len()) - seeding
+	return nil
+}
+
+func sortSeedCandidates(candidates []SeedCandidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Savings != candidates[j].Savings {
+			return candidates[i].Savings > candidates[j].Savings
+		}
+
+		return bytes.Compare(candidates[i].Value, candidates[j].Value) < 0
+	})
+}
+
+// Returns the indexes of the seeded candidates, together with a selector table
+func SeededIndexes(candidates []SeedCandidate, table []byte) (*Indexes, error) {
+	bytes4, err := LoadBytes4Table(table)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := &Indexes{
+		AddressIndexes: make(map[string]uint),
+		Bytes32Indexes: make(map[string]uint),
+		Bytes4Indexes:  bytes4,
+	}
+
+	for _, c := range candidates {
+		padded := make([]byte, 32)
+		copy(padded[32-len(c.Value):], c.Value)
+
+		if c.Flag == FLAG_SAVE_ADDRESS {
+			indexes.AddressIndexes[string(padded)] = c.Index
+		} else {
+			indexes.Bytes32Indexes[string(padded)] = c.Index
+		}
+	}
+
+	return indexes, nil
+}
+
+// Compresses the whole corpus with the given indexes, storage is only read, never written.
+// Returns the size of the calldata and the size of the payloads, the size of a call
+// doesn't include its address, the same as EncodeResult.Size.
+func (t *Trainer) Simulate(indexes *Indexes, useStorage bool, costModel CostModel) (int, int, error) {
+	// Only reads are simulated, writes would change the indexes of the seeded values
+	noSaves := &AllowOpcodes{
+		Default: true,
+		List:    map[uint]bool{FLAG_SAVE_ADDRESS: true, FLAG_SAVE_BYTES32: true},
+	}
+
+	size, compressed := 0, 0
+
+	for i, sample := range t.samples {
+		var buf *Buffer
+		var err error
+
+		if len(sample.to) != 0 {
+			buf = NewBuffer(METHOD_DECODE_CALL, indexes, noSaves, useStorage)
+			buf.Refs.CostModel = costModel
+			_, err = buf.WriteCall(sample.to, sample.data)
+		} else {
+			buf = NewBuffer(METHOD_DECODE_ANY, indexes, noSaves, useStorage)
+			buf.Refs.CostModel = costModel
+			_, err = buf.WriteBytesOptimized(sample.data, false)
+		}
+
+		if err != nil {
+			return 0, 0, fmt.Errorf("sample %d: %w", i, err)
+		}
+
+		size += len(sample.data)
+		compressed += buf.Len()
+	}
+
+	return size, compressed, nil
+}
+
+// Compresses the corpus with the default selector table, the trained table,
+// and the trained table plus the seeded candidates
+func (t *Trainer) Report(candidates []SeedCandidate, costModel CostModel) (*TrainReport, error) {
+	report := &TrainReport{Samples: len(t.samples)}
+
+	var err error
+	report.Size, report.Compressed, err = t.Simulate(&Indexes{Bytes4Indexes: LoadBytes4()}, false, costModel)
+	if err != nil {
+		return nil, err
+	}
+
+	trained, err := SeededIndexes(nil, t.Bytes4Table())
+	if err != nil {
+		return nil, err
+	}
+
+	_, report.TrainedTable, err = t.Simulate(trained, false, costModel)
+	if err != nil {
+		return nil, err
+	}
+
+	seeded, err := SeededIndexes(candidates, t.Bytes4Table())
+	if err != nil {
+		return nil, err
+	}
+
+	_, report.Trained, err = t.Simulate(seeded, true, costModel)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
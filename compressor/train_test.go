@@ -0,0 +1,67 @@
+package compressor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/0xsequence/ethkit/go-ethereum/common"
+)
+
+func TestTrainerBytes4Table(t *testing.T) {
+	trainer := NewTrainer()
+	to := common.HexToAddress("0x8ba1f109551bd432803012645ac136ddd64dba72").Bytes()
+
+	for i := 0; i < 3; i++ {
+		trainer.Add(to, common.FromHex("0xaabbccdd"))
+	}
+
+	for i := 0; i < 2; i++ {
+		trainer.Add(to, common.FromHex("0x11223344"))
+	}
+
+	// Used once, it doesn't get a place
+	trainer.Add(to, common.FromHex("0x55667788"))
+
+	table := trainer.Bytes4Table()
+	if len(table) != BYTES4_TABLE_SIZE*4 {
+		t.Fatalf("table of %d bytes, expected %d", len(table), BYTES4_TABLE_SIZE*4)
+	}
+
+	expected := common.FromHex("0x00000000aabbccdd11223344")
+	if !bytes.Equal(table[:len(expected)], expected) || !bytesAreZero(table[len(expected):]) {
+		t.Fatalf("table starts with %x, expected %x and zeros", table[:len(expected)+4], expected)
+	}
+
+	// It can be loaded in the same way as the table of the decompressor
+	indexes, err := LoadBytes4Table(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if indexes[string(common.FromHex("0xaabbccdd"))] != 1 || indexes[string(common.FromHex("0x11223344"))] != 2 {
+		t.Fatalf("unexpected indexes %v", indexes)
+	}
+}
+
+// The size of the corpus is the size of the calldata, the addresses of the calls are not counted
+func TestTrainerSimulateSize(t *testing.T) {
+	trainer := NewTrainer()
+	to := common.HexToAddress("0x8ba1f109551bd432803012645ac136ddd64dba72").Bytes()
+	data := common.FromHex("0xa9059cbb0000000000000000000000008ba1f109551bd432803012645ac136ddd64dba720000000000000000000000000000000000000000000000000de0b6b3a7640000")
+
+	trainer.Add(to, data)
+	trainer.Add(nil, data)
+
+	size, compressed, err := trainer.Simulate(&Indexes{Bytes4Indexes: LoadBytes4()}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 2*len(data) {
+		t.Fatalf("size %d, expected %d", size, 2*len(data))
+	}
+
+	if compressed == 0 || compressed >= size {
+		t.Fatalf("compressed size %d of %d", compressed, size)
+	}
+}
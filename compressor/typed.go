@@ -0,0 +1,382 @@
+package compressor
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/0xsequence/ethkit/go-ethereum/accounts/abi"
+)
+
+// Writes a part of ABI encoded data using its own flags
+type abiPiece func() (EncodeType, error)
+
+// Encodes calldata using the types of the arguments of its method, the method
+// is found on the contract ABI using the selector. Tuples, arrays and nested
+// bytes are walked recursively, so every word is encoded knowing its type.
+func (buf *Buffer) WriteCalldataABI(data []byte, contract *abi.ABI, saveWord bool) (EncodeType, error) {
+	if len(data) < 4 {
+		return Stateless, fmt.Errorf("calldata too short for a selector: %d bytes", len(data))
+	}
+
+	method, err := contract.MethodById(data[:4])
+	if err != nil {
+		return Stateless, err
+	}
+
+	types := make([]*abi.Type, len(method.Inputs))
+	for i := range method.Inputs {
+		types[i] = &method.Inputs[i].Type
+	}
+
+	var candidates []func() (EncodeType, error)
+
+	// If the only dynamic arguments are bytes and strings, the ABI flags can rebuild the calldata
+	if params, bitmap, ok := abiFlatParams(types, data); ok {
+		if bitmap == 0 && len(params) <= 6 && buf.Allows(FLAG_ABI_0_PARAM+uint(len(params))) {
+			candidates = append(candidates, func() (EncodeType, error) {
+				mark := buf.statsMark()
+				buf.commitFlag(FLAG_ABI_0_PARAM + uint(len(params)))
+				buf.commitBytes(buf.Encode4Bytes(data[:4]))
+				buf.end(data, Stateless)
+
+				t, err := buf.writeABIParams(params, saveWord)
+				buf.nestedStats(mark)
+				return t, err
+			})
+		}
+
+		if len(params) != 0 && len(params) <= 0xff && buf.Allows(FLAG_READ_DYNAMIC_ABI) {
+			candidates = append(candidates, func() (EncodeType, error) {
+				return buf.writeDynamicABI(data, params, bitmap, saveWord)
+			})
+		}
+	}
+
+	// Any other calldata is written as the selector followed by each word and value,
+	// if the values can't be walked only this candidate is dropped
+	pieces, piecesErr := buf.abiTuplePieces(types, data[4:], saveWord)
+	if piecesErr == nil && buf.Allows(FLAG_ABI_0_PARAM) {
+		selector := func() (EncodeType, error) {
+			buf.commitFlag(FLAG_ABI_0_PARAM)
+			buf.commitBytes(buf.Encode4Bytes(data[:4]))
+			buf.end(data[:4], Stateless)
+			return Stateless, nil
+		}
+
+		candidates = append(candidates, func() (EncodeType, error) {
+			return buf.writePieces(data, append([]abiPiece{selector}, pieces...))
+		})
+	}
+
+	if len(candidates) == 0 {
+		if piecesErr != nil {
+			return Stateless, fmt.Errorf("invalid calldata for %s: %w", method.Sig, piecesErr)
+		}
+
+		return Stateless, fmt.Errorf("no allowed encoding for %s", method.Sig)
+	}
+
+	start := buf.Len()
+	t, err := buf.writeCheapest(candidates)
+	buf.forgetStorageWrites(data, start, t)
+	return t, err
+}
+
+// Returns the pieces that write the encoding of a tuple, data must contain
+// exactly the encoding of the tuple, including the values of its dynamic types.
+func (buf *Buffer) abiTuplePieces(types []*abi.Type, data []byte, saveWord bool) ([]abiPiece, error) {
+	headSize := 0
+	for _, t := range types {
+		headSize += abiHeadSize(t)
+	}
+
+	if headSize > len(data) {
+		return nil, fmt.Errorf("expected at least %d bytes, got %d", headSize, len(data))
+	}
+
+	var pieces []abiPiece
+	var dynamic []*abi.Type
+	var offsets []int
+
+	head := 0
+	for _, t := range types {
+		size := abiHeadSize(t)
+
+		if !abiIsDynamic(t) {
+			pieces = append(pieces, buf.abiStaticPieces(t, data[head:head+size], saveWord)...)
+			head += size
+			continue
+		}
+
+		// The values must be sorted and next to each other, as there is
+		// no other way to know where they end
+		offset, ok := abiPointer(data[head : head+32])
+		if !ok || (len(offsets) == 0 && offset != headSize) || (len(offsets) != 0 && offset <= offsets[len(offsets)-1]) || offset > len(data) {
+			return nil, fmt.Errorf("unexpected offset %x at %d", data[head:head+32], head)
+		}
+
+		pieces = append(pieces, buf.wordPiece(data[head:head+32], false))
+		dynamic = append(dynamic, t)
+		offsets = append(offsets, offset)
+		head += size
+	}
+
+	if len(dynamic) == 0 && headSize != len(data) {
+		return nil, fmt.Errorf("expected %d bytes, got %d", headSize, len(data))
+	}
+
+	for i, t := range dynamic {
+		end := len(data)
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+
+		p, err := buf.abiDynamicPieces(t, data[offsets[i]:end], saveWord)
+		if err != nil {
+			return nil, err
+		}
+
+		pieces = append(pieces, p...)
+	}
+
+	return pieces, nil
+}
+
+// Static types are a list of words, arrays and tuples are written inline
+func (buf *Buffer) abiStaticPieces(t *abi.Type, data []byte, saveWord bool) []abiPiece {
+	switch t.T {
+	case abi.ArrayTy:
+		var pieces []abiPiece
+		size := abiHeadSize(t.Elem)
+		for i := 0; i < t.Size; i++ {
+			pieces = append(pieces, buf.abiStaticPieces(t.Elem, data[i*size:(i+1)*size], saveWord)...)
+		}
+		return pieces
+
+	case abi.TupleTy:
+		var pieces []abiPiece
+		head := 0
+		for _, e := range t.TupleElems {
+			size := abiHeadSize(e)
+			pieces = append(pieces, buf.abiStaticPieces(e, data[head:head+size], saveWord)...)
+			head += size
+		}
+		return pieces
+	}
+
+	return []abiPiece{buf.wordPiece(data, saveWord && abiSavesWord(t))}
+}
+
+// Returns the pieces of the value of a dynamic type, data must contain exactly the value
+func (buf *Buffer) abiDynamicPieces(t *abi.Type, data []byte, saveWord bool) ([]abiPiece, error) {
+	switch t.T {
+	case abi.BytesTy, abi.StringTy:
+		value, end, ok := abiTail(data, 0)
+		if !ok || end != len(data) {
+			return nil, fmt.Errorf("invalid %s value of %d bytes", t.String(), len(data))
+		}
+
+		// The size goes first, then the value and the padding
+		pieces := []abiPiece{buf.wordPiece(data[:32], false)}
+		if len(value) != 0 {
+			pieces = append(pieces, func() (EncodeType, error) {
+				return buf.WriteBytesOptimized(value, saveWord)
+			})
+		}
+
+		if pad := data[32+len(value):]; len(pad) != 0 {
+			pieces = append(pieces, func() (EncodeType, error) {
+				return buf.WriteBytesOptimized(pad, false)
+			})
+		}
+
+		return pieces, nil
+
+	case abi.SliceTy:
+		if len(data) < 32 {
+			return nil, fmt.Errorf("invalid %s value of %d bytes", t.String(), len(data))
+		}
+
+		// Every element uses at least one word
+		n, ok := abiPointer(data[:32])
+		if !ok || n*32 > len(data)-32 {
+			return nil, fmt.Errorf("invalid %s size %x", t.String(), data[:32])
+		}
+
+		pieces, err := buf.abiTuplePieces(abiRepeat(t.Elem, n), data[32:], saveWord)
+		if err != nil {
+			return nil, err
+		}
+
+		return append([]abiPiece{buf.wordPiece(data[:32], false)}, pieces...), nil
+
+	case abi.ArrayTy:
+		return buf.abiTuplePieces(abiRepeat(t.Elem, t.Size), data, saveWord)
+
+	case abi.TupleTy:
+		return buf.abiTuplePieces(t.TupleElems, data, saveWord)
+	}
+
+	return nil, fmt.Errorf("unexpected dynamic type %s", t.String())
+}
+
+func (buf *Buffer) wordPiece(word []byte, saveWord bool) abiPiece {
+	return func() (EncodeType, error) {
+		return buf.WriteWord(word, saveWord)
+	}
+}
+
+// Writes all the pieces of data, nested on a single flag if needed
+func (buf *Buffer) writePieces(data []byte, pieces []abiPiece) (EncodeType, error) {
+	mark := -1
+	if len(pieces) > 1 {
+		mark = buf.statsMark()
+		if err := buf.commitNestedFlags(len(pieces)); err != nil {
+			return Stateless, err
+		}
+
+		buf.end(data, Stateless)
+	}
+
+	encodeType := Stateless
+
+	for _, piece := range pieces {
+		t, err := piece()
+		if err != nil {
+			return Stateless, err
+		}
+
+		encodeType = maxPriority(encodeType, t)
+	}
+
+	buf.nestedStats(mark)
+	return encodeType, nil
+}
+
+// Splits the calldata into the parameters of the ABI flags, returns false if any
+// dynamic argument is not bytes or string, or if the flags can't rebuild it exactly.
+func abiFlatParams(types []*abi.Type, data []byte) ([]abiParam, byte, bool) {
+	args := data[4:]
+
+	var params []abiParam
+	var bitmap byte
+
+	var flatten func(t *abi.Type, word int)
+	flatten = func(t *abi.Type, word int) {
+		switch t.T {
+		case abi.ArrayTy:
+			for i := 0; i < t.Size; i++ {
+				flatten(t.Elem, word+i*abiHeadSize(t.Elem)/32)
+			}
+		case abi.TupleTy:
+			for _, e := range t.TupleElems {
+				flatten(e, word)
+				word += abiHeadSize(e) / 32
+			}
+		default:
+			params = append(params, abiParam{data: args[word*32 : word*32+32], typ: t})
+		}
+	}
+
+	head := 0
+	for _, t := range types {
+		size := abiHeadSize(t)
+		if head+size > len(args) {
+			return nil, 0, false
+		}
+
+		switch {
+		case !abiIsDynamic(t):
+			flatten(t, head/32)
+
+		case (t.T == abi.BytesTy || t.T == abi.StringTy) && len(params) < maxDynamicABIParams:
+			pointer, ok := abiPointer(args[head : head+32])
+			if !ok {
+				return nil, 0, false
+			}
+
+			value, _, ok := abiTail(args, pointer)
+			if !ok {
+				return nil, 0, false
+			}
+
+			bitmap |= 1 << len(params)
+			params = append(params, abiParam{data: value, dynamic: true, typ: t})
+
+		default:
+			return nil, 0, false
+		}
+
+		head += size
+	}
+
+	if !bytes.Equal(rebuildDynamicABI(data[:4], params, bitmap), data) {
+		return nil, 0, false
+	}
+
+	return params, bitmap, true
+}
+
+func abiIsDynamic(t *abi.Type) bool {
+	switch t.T {
+	case abi.StringTy, abi.BytesTy, abi.SliceTy:
+		return true
+	case abi.ArrayTy:
+		return abiIsDynamic(t.Elem)
+	case abi.TupleTy:
+		for _, e := range t.TupleElems {
+			if abiIsDynamic(e) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Size of the type on the head of a tuple, dynamic types only use a pointer
+func abiHeadSize(t *abi.Type) int {
+	if abiIsDynamic(t) {
+		return 32
+	}
+
+	switch t.T {
+	case abi.ArrayTy:
+		return t.Size * abiHeadSize(t.Elem)
+	case abi.TupleTy:
+		size := 0
+		for _, e := range t.TupleElems {
+			size += abiHeadSize(e)
+		}
+		return size
+	}
+
+	return 32
+}
+
+func abiRepeat(t *abi.Type, n int) []*abi.Type {
+	types := make([]*abi.Type, n)
+	for i := range types {
+		types[i] = t
+	}
+
+	return types
+}
+
+// Addresses and bytes32 values are likely to be used again, other
+// values (amounts, flags, etc) are not worth saving on storage.
+// Words without a known type can always be saved.
+func abiSavesWord(t *abi.Type) bool {
+	if t == nil {
+		return true
+	}
+
+	switch t.T {
+	case abi.AddressTy, abi.HashTy:
+		return true
+	case abi.FixedBytesTy:
+		return t.Size == 32
+	}
+
+	return false
+}
@@ -85,26 +85,24 @@ func isPow10(b []byte) int {
 
 func isPow10Mantissa(b []byte, maxExp int, maxMantissa int) (int, int) {
 	num := big.NewInt(0).SetBytes(b)
-
-	var n int
+	if num.Sign() == 0 {
+		return -1, -1
+	}
 
 	ten := big.NewInt(10)
 	maxByteValue := big.NewInt(int64(maxMantissa))
-	zero := big.NewInt(0)
+	remainder := new(big.Int)
 
-	for n = 1; n < maxExp; n++ {
-		powerOfTen := new(big.Int).Exp(ten, big.NewInt(int64(n)), nil)
-
-		if powerOfTen.Cmp(num) > 0 {
+	// Divide by 10 until the remainder is not zero, if 10 ** n doesn't
+	// divide the number then no bigger exponent will divide it either
+	for n := 1; n < maxExp; n++ {
+		num.QuoRem(num, ten, remainder)
+		if remainder.Sign() != 0 {
 			break
 		}
 
-		quotient := new(big.Int)
-		remainder := new(big.Int)
-		quotient.DivMod(num, powerOfTen, remainder)
-
-		if remainder.Cmp(zero) == 0 && quotient.Cmp(maxByteValue) <= 0 {
-			return n, int(quotient.Int64())
+		if num.Cmp(maxByteValue) <= 0 {
+			return n, int(num.Int64())
 		}
 	}
 